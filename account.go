@@ -0,0 +1,161 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Balances holds an account's cash, keyed by currency code.
+type Balances map[string]Money
+
+// UnmarshalJSON reads accounts written before balances were kept per
+// currency, whose single cashBalance becomes their only balance.
+func (a *Account) UnmarshalJSON(data []byte) error {
+	type account Account
+	var decoded struct {
+		account
+		CashBalance *Money `json:"cashBalance"`
+	}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*a = Account(decoded.account)
+	if decoded.CashBalance != nil && len(a.Balances) == 0 {
+		a.Balances = Balances{decoded.CashBalance.Currency: *decoded.CashBalance}
+	}
+	return nil
+}
+
+// balance returns the account's cash in currency, zero if it holds none.
+func (a Account) balance(currency string) Money {
+	if amount, ok := a.Balances[currency]; ok {
+		return amount
+	}
+	return Money{Currency: currency}
+}
+
+func (a *Account) credit(amount Money) error {
+	balance, err := a.balance(amount.Currency).Add(amount)
+	if err != nil {
+		return err
+	}
+	if a.Balances == nil {
+		a.Balances = make(Balances)
+	}
+	a.Balances[amount.Currency] = balance
+	return nil
+}
+
+// debit fails, leaving the account unchanged, if it holds less than amount
+// in the amount's currency.
+func (a *Account) debit(amount Money) error {
+	balance := a.balance(amount.Currency)
+	short, err := balance.LessThan(amount)
+	if err != nil {
+		return err
+	}
+	if short {
+		return errors.New("The company " + a.ID + " doesn't have enough " + amount.Currency + " to pay " + amount.String())
+	}
+	balance, err = balance.Sub(amount)
+	if err != nil {
+		return err
+	}
+	if a.Balances == nil {
+		a.Balances = make(Balances)
+	}
+	a.Balances[amount.Currency] = balance
+	return nil
+}
+
+// addAsset records that the account holds paper with the given CUSIP.
+func (a *Account) addAsset(cusip string) {
+	for _, id := range a.AssetsIds {
+		if id == cusip {
+			return
+		}
+	}
+	a.AssetsIds = append(a.AssetsIds, cusip)
+}
+
+// requireCompany returns the company's account, failing unless the caller's
+// certificate is the one that created it.
+func requireCompany(stub StateStub, id string) (Account, error) {
+	company, err := GetCompany(id, stub)
+	if err != nil {
+		return company, err
+	}
+	caller, err := callerFingerprint(stub)
+	if err != nil {
+		return company, err
+	}
+	if company.Owner == "" || caller != company.Owner {
+		fmt.Println("Caller " + caller + " does not own " + id)
+		return company, errors.New("Only the owner of " + id + " may act for it")
+	}
+	return company, nil
+}
+
+// adminKey holds the fingerprint of the certificate that deployed the
+// chaincode, recorded by init.
+var adminKey = "admin"
+
+// recordAdmin records the caller as the administrator unless one already
+// is. Callers without a certificate are not recorded.
+func recordAdmin(stub StateStub) error {
+	existing, err := stub.GetState(adminKey)
+	if err != nil {
+		return errors.New("Error retrieving the administrator")
+	}
+	if existing != nil {
+		return nil
+	}
+	caller, err := callerFingerprint(stub)
+	if err != nil {
+		return nil
+	}
+	err = stub.PutState(adminKey, []byte(caller))
+	if err != nil {
+		fmt.Println("Failed to record the administrator")
+		return errors.New("Failed to record the administrator")
+	}
+	return nil
+}
+
+// requireAdmin fails unless the caller is the administrator, returning its
+// fingerprint.
+func requireAdmin(stub StateStub) (string, error) {
+	caller, err := callerFingerprint(stub)
+	if err != nil {
+		return "", err
+	}
+	admin, err := stub.GetState(adminKey)
+	if err != nil {
+		return "", errors.New("Error retrieving the administrator")
+	}
+	if admin == nil || string(admin) != caller {
+		fmt.Println("Caller " + caller + " is not the administrator")
+		return "", errors.New("Caller is not the administrator")
+	}
+	return caller, nil
+}
@@ -33,28 +33,10 @@ var cpPrefix = "cp:"
 var accountPrefix = "acct:"
 var accountsKey = "accounts"
 
-var recentLeapYear = 2016
-
 // SimpleChaincode example simple Chaincode implementation
 type SimpleChaincode struct {
 }
 
-func generateCUSIPSuffix(issueDate string, days int) (string, error) {
-
-	t, err := msToTime(issueDate)
-	if err != nil {
-		return "", err
-	}
-
-	maturityDate := t.AddDate(0, 0, days)
-	month := int(maturityDate.Month())
-	day := maturityDate.Day()
-
-	suffix := seventhDigit[month] + eigthDigit[day]
-	return suffix, nil
-
-}
-
 const (
 	millisPerSecond     = int64(time.Second / time.Millisecond)
 	nanosPerMillisecond = int64(time.Millisecond / time.Nanosecond)
@@ -80,10 +62,17 @@ type Owner struct {
 type CP struct {
 	CUSIP     string  `json:"cusip"`
 	Ticker    string  `json:"ticker"`
-	Par       float64 `json:"par"`
+	Par       Money   `json:"par"`
 	Qty       int     `json:"qty"`
-	Discount  float64 `json:"discount"`
+	Discount  Rate    `json:"discount"`
 	Maturity  int     `json:"maturity"`
+	// DayCount is the convention discount accrues under, ACT/360 if unset
+	DayCount  string  `json:"dayCount,omitempty"`
+	// Matured is set once the paper has been redeemed
+	Matured   bool    `json:"matured,omitempty"`
+	// Escrowed is the quantity held in escrow for unsettled trades, not
+	// counted in Owners
+	Escrowed  int     `json:"escrowed,omitempty"`
 	Owners    []Owner `json:"owner"`
 	Issuer    string  `json:"issuer"`
 	IssueDate string  `json:"issueDate"`
@@ -91,34 +80,60 @@ type CP struct {
 
 type Account struct {
 	ID          string  `json:"id"`
+	// Prefix is the CUSIP issuer number, allocated when the account first
+	// issues paper
 	Prefix      string  `json:"prefix"`
-	CashBalance float64 `json:"cashBalance"`
+	Balances    Balances `json:"balances"`
 	AssetsIds   []string `json:"assetIds"`
+	// Owner is the fingerprint of the certificate that created the
+	// account; only it may trade for the company
+	Owner       string   `json:"owner,omitempty"`
 }
 
-type Transaction struct {
-	CUSIP       string   `json:"cusip"`
-	FromCompany string   `json:"fromCompany"`
-	ToCompany   string   `json:"toCompany"`
-	Quantity    int      `json:"quantity"`
-	Discount    float64  `json:"discount"`
-}
+func (t *SimpleChaincode) init(stub StateStub, args []string) ([]byte, error) {
+    // The certificate that deploys the chaincode administers it
+    err := recordAdmin(stub)
+    if err != nil {
+        return nil, err
+    }
 
-func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    // Initialize the collection of commercial paper keys
-    fmt.Println("Initializing paper keys collection")
-	var blank []string
-	blankBytes, _ := json.Marshal(&blank)
-	err := stub.PutState("PaperKeys", blankBytes)
+    // Papers are listed by a range query over cpPrefix, so the paper
+    // keys collection earlier versions kept is no longer needed
+    err = stub.DelState("PaperKeys")
     if err != nil {
-        fmt.Println("Failed to initialize paper key collection")
+        fmt.Println("Failed to remove paper key collection")
+    }
+
+    // The optional argument lists the certificate fingerprints of the FX
+    // oracles, comma separated. Once set, only a current oracle may
+    // replace the list
+    if len(args) > 0 && args[0] != "" {
+        existing, err := stub.GetState(fxOraclesKey)
+        if err != nil {
+            return nil, errors.New("Error retrieving FX oracles")
+        }
+        if existing != nil {
+            _, err = requireOracle(stub)
+            if err != nil {
+                return nil, err
+            }
+        }
+        oraclesBytes, err := json.Marshal(strings.Split(args[0], ","))
+        if err != nil {
+            return nil, errors.New("Invalid FX oracle list")
+        }
+        err = stub.PutState(fxOraclesKey, oraclesBytes)
+        if err != nil {
+            fmt.Println("Failed to record FX oracles")
+            return nil, errors.New("Failed to record FX oracles")
+        }
     }
 
 	fmt.Println("Initialization complete")
 	return nil, nil
 }
 
-func (t *SimpleChaincode) createAccounts(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode) createAccounts(stub StateStub, args []string) ([]byte, error) {
 
 	//  				0
 	// "number of accounts to create"
@@ -128,25 +143,35 @@ func (t *SimpleChaincode) createAccounts(stub *shim.ChaincodeStub, args []string
 		fmt.Println("error creating accounts with input")
 		return nil, errors.New("createAccounts accepts a single integer argument")
 	}
+	// Only the administrator creates accounts in bulk, and owns them
+	owner, err := requireAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+	for counter := 1; counter <= numAccounts; counter++ {
+		id := "company" + strconv.Itoa(counter)
+		existing, err := stub.GetState(accountPrefix + id)
+		if err != nil || existing != nil {
+			fmt.Println("Account already exists for " + id)
+			return nil, errors.New("Can't reinitialize existing user " + id)
+		}
+	}
 	//create a bunch of accounts
 	var account Account
 	counter := 1
 	for counter <= numAccounts {
-		var prefix string
-		suffix := "000A"
-		if counter < 10 {
-			prefix = strconv.Itoa(counter) + "0" + suffix
-		} else {
-			prefix = strconv.Itoa(counter) + suffix
-		}
 		var assetIds []string
-		account = Account{ID: "company" + strconv.Itoa(counter), Prefix: prefix, CashBalance: 10000000.0, AssetsIds: assetIds}
+		account = Account{ID: "company" + strconv.Itoa(counter), Balances: Balances{defaultCurrency: wholeMoney(10000000, defaultCurrency)}, AssetsIds: assetIds, Owner: owner}
 		accountBytes, err := json.Marshal(&account)
 		if err != nil {
 			fmt.Println("error creating account" + account.ID)
 			return nil, errors.New("Error creating account " + account.ID)
 		}
-		err = stub.PutState(accountPrefix+account.ID, accountBytes)
+		err = putWithHistory(stub, accountPrefix+account.ID, accountBytes, HistoryAccount, account.ID, "createAccount")
+		if err != nil {
+			fmt.Println("failed to create account " + account.ID)
+			return nil, errors.New("Error creating account " + account.ID)
+		}
 		counter++
 		fmt.Println("created account" + accountPrefix + account.ID)
 	}
@@ -156,19 +181,33 @@ func (t *SimpleChaincode) createAccounts(stub *shim.ChaincodeStub, args []string
 
 }
 
-func (t *SimpleChaincode) createAccount(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode) createAccount(stub StateStub, args []string) ([]byte, error) {
     // Obtain the username to associate with the account
-    if len(args) != 1 {
+    if len(args) < 1 || len(args) > 2 {
         fmt.Println("Error obtaining username")
-        return nil, errors.New("createAccount accepts a single username argument")
+        return nil, errors.New("createAccount accepts a username and an optional currency argument")
     }
     username := args[0]
     
+    // The opening balance is in the given currency
+    currency := defaultCurrency
+    if len(args) > 1 {
+        currency = strings.ToUpper(args[1])
+        _, err := currencyExponent(currency)
+        if err != nil {
+            return nil, err
+        }
+    }
+    
+    // The caller's certificate owns the account
+    owner, err := callerFingerprint(stub)
+    if err != nil {
+        return nil, err
+    }
+
     // Build an account object for the user
     var assetIds []string
-    suffix := "000A"
-    prefix := username + suffix
-    var account = Account{ID: username, Prefix: prefix, CashBalance: 10000000.0, AssetsIds: assetIds}
+    var account = Account{ID: username, Balances: Balances{currency: wholeMoney(10000000, currency)}, AssetsIds: assetIds, Owner: owner}
     accountBytes, err := json.Marshal(&account)
     if err != nil {
         fmt.Println("error creating account" + account.ID)
@@ -186,7 +225,7 @@ func (t *SimpleChaincode) createAccount(stub *shim.ChaincodeStub, args []string)
             
             if strings.Contains(err.Error(), "unexpected end") {
                 fmt.Println("No data means existing account found for " + account.ID + ", initializing account.")
-                err = stub.PutState(accountPrefix+account.ID, accountBytes)
+                err = putWithHistory(stub, accountPrefix+account.ID, accountBytes, HistoryAccount, account.ID, "createAccount")
                 
                 if err == nil {
                     fmt.Println("created account" + accountPrefix + account.ID)
@@ -205,7 +244,7 @@ func (t *SimpleChaincode) createAccount(stub *shim.ChaincodeStub, args []string)
     } else {
         
         fmt.Println("No existing account found for " + account.ID + ", initializing account.")
-        err = stub.PutState(accountPrefix+account.ID, accountBytes)
+        err = putWithHistory(stub, accountPrefix+account.ID, accountBytes, HistoryAccount, account.ID, "createAccount")
         
         if err == nil {
             fmt.Println("created account" + accountPrefix + account.ID)
@@ -220,16 +259,17 @@ func (t *SimpleChaincode) createAccount(stub *shim.ChaincodeStub, args []string)
     
 }
 
-func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode) issueCommercialPaper(stub StateStub, args []string) ([]byte, error) {
 
 	/*		0
 		json
 	  	{
 			"ticker":  "string",
-			"par": 0.00,
+			"par": "1000.00 USD",
 			"qty": 10,
-			"discount": 7.5,
+			"discount": "7.5",
 			"maturity": 30,
+			"dayCount": "ACT/360", (ACT/360, ACT/365 or 30/360; ACT/360 if omitted)
 			"owners": [ // This one is not required
 				{
 					"company": "company1",
@@ -265,21 +305,39 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 		fmt.Println("error invalid paper issue")
 		return nil, errors.New("Invalid commercial paper issue")
 	}
+	if cp.Par.Sign() <= 0 {
+		fmt.Println("Par value must be positive")
+		return nil, errors.New("Par value must be positive")
+	}
+	if cp.Maturity <= 0 {
+		fmt.Println("Maturity must be positive")
+		return nil, errors.New("Maturity must be positive")
+	}
+	cp.DayCount, err = normalizeDayCount(cp.DayCount)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
 
-	//generate the CUSIP
-	//get account prefix
+	//get the issuer number; only the issuer's owner issues its paper
 	fmt.Println("Getting state of - " + accountPrefix + cp.Issuer)
-	accountBytes, err := stub.GetState(accountPrefix + cp.Issuer)
+	account, err = requireCompany(stub, cp.Issuer)
 	if err != nil {
-		fmt.Println("Error Getting state of - " + accountPrefix + cp.Issuer)
-		return nil, errors.New("Error retrieving account " + cp.Issuer)
+		return nil, err
 	}
-	err = json.Unmarshal(accountBytes, &account)
+	err = assignIssuerNumber(stub, &account)
 	if err != nil {
-		fmt.Println("Error Unmarshalling accountBytes")
-		return nil, errors.New("Error retrieving account " + cp.Issuer)
+		fmt.Println("Error allocating an issuer number to " + cp.Issuer)
+		return nil, err
 	}
-	
+
+	//generate the CUSIP from the issuer's next free issue number
+	cp.CUSIP, err = nextCUSIP(stub, account.Prefix)
+	if err != nil {
+		fmt.Println("Error generating cusip")
+		return nil, err
+	}
+
 	account.AssetsIds = append(account.AssetsIds, cp.CUSIP)
 
 	// Set the issuer to be the owner of all quantity
@@ -289,154 +347,65 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 	
 	cp.Owners = append(cp.Owners, owner)
 
-	suffix, err := generateCUSIPSuffix(cp.IssueDate, cp.Maturity)
+	fmt.Println("Marshalling CP bytes")
+	cpBytes, err := json.Marshal(&cp)
 	if err != nil {
-		fmt.Println("Error generating cusip")
-		return nil, errors.New("Error generating CUSIP")
+		fmt.Println("Error marshalling cp")
+		return nil, errors.New("Error issuing commercial paper")
+	}
+	err = putWithHistory(stub, cpPrefix+cp.CUSIP, cpBytes, HistoryCUSIP, cp.CUSIP, "issueCommercialPaper")
+	if err != nil {
+		fmt.Println("Error issuing paper")
+		return nil, errors.New("Error issuing commercial paper")
 	}
 
-	fmt.Println("Marshalling CP bytes")
-	cp.CUSIP = account.Prefix + suffix
+	fmt.Println("Marshalling account bytes to write")
+	accountBytesToWrite, err := json.Marshal(&account)
+	if err != nil {
+		fmt.Println("Error marshalling account")
+		return nil, errors.New("Error issuing commercial paper")
+	}
+	err = putWithHistory(stub, accountPrefix+cp.Issuer, accountBytesToWrite, HistoryAccount, cp.Issuer, "issueCommercialPaper")
+	if err != nil {
+		fmt.Println("Error putting state on accountBytesToWrite")
+		return nil, errors.New("Error issuing commercial paper")
+	}
 	
-	fmt.Println("Getting State on CP " + cp.CUSIP)
-	cpRxBytes, err := stub.GetState(cpPrefix+cp.CUSIP)
-	if cpRxBytes == nil {
-		fmt.Println("CUSIP does not exist, creating it")
-		cpBytes, err := json.Marshal(&cp)
-		if err != nil {
-			fmt.Println("Error marshalling cp")
-			return nil, errors.New("Error issuing commercial paper")
-		}
-		err = stub.PutState(cpPrefix+cp.CUSIP, cpBytes)
-		if err != nil {
-			fmt.Println("Error issuing paper")
-			return nil, errors.New("Error issuing commercial paper")
-		}
-
-		fmt.Println("Marshalling account bytes to write")
-		accountBytesToWrite, err := json.Marshal(&account)
-		if err != nil {
-			fmt.Println("Error marshalling account")
-			return nil, errors.New("Error issuing commercial paper")
-		}
-		err = stub.PutState(accountPrefix + cp.Issuer, accountBytesToWrite)
-		if err != nil {
-			fmt.Println("Error putting state on accountBytesToWrite")
-			return nil, errors.New("Error issuing commercial paper")
-		}
-		
-		
-		// Update the paper keys by adding the new key
-		fmt.Println("Getting Paper Keys")
-		keysBytes, err := stub.GetState("PaperKeys")
-		if err != nil {
-			fmt.Println("Error retrieving paper keys")
-			return nil, errors.New("Error retrieving paper keys")
-		}
-		var keys []string
-		err = json.Unmarshal(keysBytes, &keys)
-		if err != nil {
-			fmt.Println("Error unmarshel keys")
-			return nil, errors.New("Error unmarshalling paper keys ")
-		}
-		
-		fmt.Println("Appending the new key to Paper Keys")
-		foundKey := false
-		for _, key := range keys {
-			if key == cpPrefix+cp.CUSIP {
-				foundKey = true
-			}
-		}
-		if foundKey == false {
-			keys = append(keys, cpPrefix+cp.CUSIP)
-			keysBytesToWrite, err := json.Marshal(&keys)
-			if err != nil {
-				fmt.Println("Error marshalling keys")
-				return nil, errors.New("Error marshalling the keys")
-			}
-			fmt.Println("Put state on PaperKeys")
-			err = stub.PutState("PaperKeys", keysBytesToWrite)
-			if err != nil {
-				fmt.Println("Error writting keys back")
-				return nil, errors.New("Error writing the keys back")
-			}
-		}
-		
-		fmt.Println("Issue commercial paper %+v\n", cp)
-		return nil, nil
-	} else {
-		fmt.Println("CUSIP exists")
-		
-		var cprx CP
-		fmt.Println("Unmarshalling CP " + cp.CUSIP)
-		err = json.Unmarshal(cpRxBytes, &cprx)
-		if err != nil {
-			fmt.Println("Error unmarshalling cp " + cp.CUSIP)
-			return nil, errors.New("Error unmarshalling cp " + cp.CUSIP)
-		}
-		
-		cprx.Qty = cprx.Qty + cp.Qty
-		
-		for key, val := range cprx.Owners {
-			if val.Company == cp.Issuer {
-				cprx.Owners[key].Quantity += cp.Qty
-				break
-			}
-		}
-				
-		cpWriteBytes, err := json.Marshal(&cprx)
-		if err != nil {
-			fmt.Println("Error marshalling cp")
-			return nil, errors.New("Error issuing commercial paper")
-		}
-		err = stub.PutState(cpPrefix+cp.CUSIP, cpWriteBytes)
-		if err != nil {
-			fmt.Println("Error issuing paper")
-			return nil, errors.New("Error issuing commercial paper")
-		}
-
-		fmt.Println("Updated commercial paper %+v\n", cprx)
-		return nil, nil
+	err = setEvent(stub, Event{Type: EventPaperIssued, CUSIP: cp.CUSIP, Issuer: cp.Issuer, Quantity: cp.Qty})
+	if err != nil {
+		return nil, err
 	}
+	
+	fmt.Printf("Issue commercial paper %+v\n", cp)
+	return nil, nil
 }
 
 
-func GetAllCPs(stub *shim.ChaincodeStub) ([]CP, error){
+func GetAllCPs(stub StateStub) ([]CP, error){
 	
 	var allCPs []CP
 	
-	// Get list of all the keys
-	keysBytes, err := stub.GetState("PaperKeys")
-	if err != nil {
-		fmt.Println("Error retrieving paper keys")
-		return nil, errors.New("Error retrieving paper keys")
-	}
-	var keys []string
-	err = json.Unmarshal(keysBytes, &keys)
-	if err != nil {
-		fmt.Println("Error unmarshalling paper keys")
-		return nil, errors.New("Error unmarshalling paper keys")
-	}
-
-	// Get all the cps
-	for _, value := range keys {
-		cpBytes, err := stub.GetState(value)
-		
+	// Papers are keyed by cpPrefix, so a range over it yields them all
+	err := forEachInRange(stub, cpPrefix, func(key string, cpBytes []byte) error {
 		var cp CP
-		err = json.Unmarshal(cpBytes, &cp)
+		err := json.Unmarshal(cpBytes, &cp)
 		if err != nil {
-			fmt.Println("Error retrieving cp " + value)
-			return nil, errors.New("Error retrieving cp " + value)
+			fmt.Println("Error retrieving cp " + key)
+			return errors.New("Error retrieving cp " + key)
 		}
 		
-		fmt.Println("Appending CP" + value)
+		fmt.Println("Appending CP" + key)
 		allCPs = append(allCPs, cp)
-	}	
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	
 	return allCPs, nil
 }
 
-func GetCP(cpid string, stub *shim.ChaincodeStub) (CP, error){
+func GetCP(cpid string, stub StateStub) (CP, error){
 	var cp CP
 
 	cpBytes, err := stub.GetState(cpid)
@@ -455,7 +424,7 @@ func GetCP(cpid string, stub *shim.ChaincodeStub) (CP, error){
 }
 
 
-func GetCompany(companyID string, stub *shim.ChaincodeStub) (Account, error){
+func GetCompany(companyID string, stub StateStub) (Account, error){
 	var company Account
 	companyBytes, err := stub.GetState(accountPrefix+companyID)
 	if err != nil {
@@ -473,186 +442,11 @@ func GetCompany(companyID string, stub *shim.ChaincodeStub) (Account, error){
 }
 
 
-// Still working on this one
-func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	/*		0
-		json
-	  	{
-			  "CUSIP": "",
-			  "fromCompany":"",
-			  "toCompany":"",
-			  "quantity": 1
-		}
-	*/
-	//need one arg
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting commercial paper record")
-	}
-	
-	var tr Transaction
-
-	fmt.Println("Unmarshalling Transaction")
-	err := json.Unmarshal([]byte(args[0]), &tr)
-	if err != nil {
-		fmt.Println("Error Unmarshalling Transaction")
-		return nil, errors.New("Invalid commercial paper issue")
-	}
-
-	fmt.Println("Getting State on CP " + tr.CUSIP)
-	cpBytes, err := stub.GetState(cpPrefix+tr.CUSIP)
-	if err != nil {
-		fmt.Println("CUSIP not found")
-		return nil, errors.New("CUSIP not found " + tr.CUSIP)
-	}
-
-	var cp CP
-	fmt.Println("Unmarshalling CP " + tr.CUSIP)
-	err = json.Unmarshal(cpBytes, &cp)
-	if err != nil {
-		fmt.Println("Error unmarshalling cp " + tr.CUSIP)
-		return nil, errors.New("Error unmarshalling cp " + tr.CUSIP)
-	}
-
-	var fromCompany Account
-	fmt.Println("Getting State on fromCompany " + tr.FromCompany)	
-	fromCompanyBytes, err := stub.GetState(accountPrefix+tr.FromCompany)
-	if err != nil {
-		fmt.Println("Account not found " + tr.FromCompany)
-		return nil, errors.New("Account not found " + tr.FromCompany)
-	}
-
-	fmt.Println("Unmarshalling FromCompany ")
-	err = json.Unmarshal(fromCompanyBytes, &fromCompany)
-	if err != nil {
-		fmt.Println("Error unmarshalling account " + tr.FromCompany)
-		return nil, errors.New("Error unmarshalling account " + tr.FromCompany)
-	}
-
-	var toCompany Account
-	fmt.Println("Getting State on ToCompany " + tr.ToCompany)
-	toCompanyBytes, err := stub.GetState(accountPrefix+tr.ToCompany)
-	if err != nil {
-		fmt.Println("Account not found " + tr.ToCompany)
-		return nil, errors.New("Account not found " + tr.ToCompany)
-	}
-
-	fmt.Println("Unmarshalling tocompany")
-	err = json.Unmarshal(toCompanyBytes, &toCompany)
-	if err != nil {
-		fmt.Println("Error unmarshalling account " + tr.ToCompany)
-		return nil, errors.New("Error unmarshalling account " + tr.ToCompany)
-	}
-
-	// Check for all the possible errors
-	ownerFound := false 
-	quantity := 0
-	for _, owner := range cp.Owners {
-		if owner.Company == tr.FromCompany {
-			ownerFound = true
-			quantity = owner.Quantity
-		}
-	}
-	
-	// If fromCompany doesn't own this paper
-	if ownerFound == false {
-		fmt.Println("The company " + tr.FromCompany + "doesn't own any of this paper")
-		return nil, errors.New("The company " + tr.FromCompany + "doesn't own any of this paper")	
-	} else {
-		fmt.Println("The FromCompany does own this paper")
-	}
-	
-	// If fromCompany doesn't own enough quantity of this paper
-	if quantity < tr.Quantity {
-		fmt.Println("The company " + tr.FromCompany + "doesn't own enough of this paper")		
-		return nil, errors.New("The company " + tr.FromCompany + "doesn't own enough of this paper")			
-	} else {
-		fmt.Println("The FromCompany owns enough of this paper")
-	}
-	
-	amountToBeTransferred := float64(tr.Quantity) * cp.Par
-	amountToBeTransferred -= (amountToBeTransferred) * (cp.Discount / 100.0) * (float64(cp.Maturity) / 360.0)
-	
-	// If toCompany doesn't have enough cash to buy the papers
-	if toCompany.CashBalance < amountToBeTransferred {
-		fmt.Println("The company " + tr.ToCompany + "doesn't have enough cash to purchase the papers")		
-		return nil, errors.New("The company " + tr.ToCompany + "doesn't have enough cash to purchase the papers")	
-	} else {
-		fmt.Println("The ToCompany has enough money to be transferred for this paper")
-	}
-	
-	toCompany.CashBalance -= amountToBeTransferred
-	fromCompany.CashBalance += amountToBeTransferred
-
-	toOwnerFound := false
-	for key, owner := range cp.Owners {
-		if owner.Company == tr.FromCompany {
-			fmt.Println("Reducing Quantity from the FromCompany")
-			cp.Owners[key].Quantity -= tr.Quantity
-//			owner.Quantity -= tr.Quantity
-		}
-		if owner.Company == tr.ToCompany {
-			fmt.Println("Increasing Quantity from the ToCompany")
-			toOwnerFound = true
-			cp.Owners[key].Quantity += tr.Quantity
-//			owner.Quantity += tr.Quantity
-		}
-	}
-	
-	if toOwnerFound == false {
-		var newOwner Owner
-		fmt.Println("As ToOwner was not found, appending the owner to the CP")
-		newOwner.Quantity = tr.Quantity
-		newOwner.Company = tr.ToCompany
-		cp.Owners = append(cp.Owners, newOwner)
-	}
-	
-	fromCompany.AssetsIds = append(fromCompany.AssetsIds, tr.CUSIP)
-
-	// Write everything back
-	// To Company
-	toCompanyBytesToWrite, err := json.Marshal(&toCompany)
-	if err != nil {
-		fmt.Println("Error marshalling the toCompany")
-		return nil, errors.New("Error marshalling the toCompany")
-	}
-	fmt.Println("Put state on toCompany")
-	err = stub.PutState(accountPrefix+tr.ToCompany, toCompanyBytesToWrite)
-	if err != nil {
-		fmt.Println("Error writing the toCompany back")
-		return nil, errors.New("Error writing the toCompany back")
-	}
-		
-	// From company
-	fromCompanyBytesToWrite, err := json.Marshal(&fromCompany)
-	if err != nil {
-		fmt.Println("Error marshalling the fromCompany")
-		return nil, errors.New("Error marshalling the fromCompany")
-	}
-	fmt.Println("Put state on fromCompany")
-	err = stub.PutState(accountPrefix+tr.FromCompany, fromCompanyBytesToWrite)
-	if err != nil {
-		fmt.Println("Error writing the fromCompany back")
-		return nil, errors.New("Error writing the fromCompany back")
-	}
-	
-	// cp
-	cpBytesToWrite, err := json.Marshal(&cp)
-	if err != nil {
-		fmt.Println("Error marshalling the cp")
-		return nil, errors.New("Error marshalling the cp")
-	}
-	fmt.Println("Put state on CP")
-	err = stub.PutState(cpPrefix+tr.CUSIP, cpBytesToWrite)
-	if err != nil {
-		fmt.Println("Error writing the cp back")
-		return nil, errors.New("Error writing the cp back")
-	}
-	
-	fmt.Println("Successfully completed Invoke")
-	return nil, nil
+func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return t.query(newShimStub(stub), function, args)
 }
 
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) query(stub StateStub, function string, args []string) ([]byte, error) {
 	//need one arg
 	if len(args) < 1 {
 		return nil, errors.New("Incorrect number of arguments. Expecting ......")
@@ -703,6 +497,122 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 			fmt.Println("All success, returning the company")
 			return companyBytes, nil		 
 		}
+	} else if args[0] == "GetFXRate" {
+		fmt.Println("Getting FX rate")
+		if len(args) != 3 {
+			return nil, errors.New("Incorrect number of arguments. Expecting GetFXRate, base and quote currency")
+		}
+		rate, err := getFXRate(stub, strings.ToUpper(args[1]), strings.ToUpper(args[2]))
+		if err != nil {
+			return nil, err
+		}
+		rateBytes, err := json.Marshal(&rate)
+		if err != nil {
+			fmt.Println("Error marshalling FX rate")
+			return nil, err
+		}
+		return rateBytes, nil
+	} else if args[0] == "GetPrice" || args[0] == "GetDiscountYield" || args[0] == "GetBondEquivalentYield" {
+		fmt.Println("Pricing paper")
+		if len(args) != 3 {
+			return nil, errors.New("Incorrect number of arguments. Expecting " + args[0] + ", CUSIP and as-of time in milliseconds")
+		}
+		quote, err := getQuote(stub, args[1], args[2])
+		if err != nil {
+			fmt.Println("Error pricing paper " + args[1])
+			return nil, err
+		}
+		var value interface{} = quote.Price
+		if args[0] == "GetDiscountYield" {
+			value = quote.DiscountYield
+		} else if args[0] == "GetBondEquivalentYield" {
+			value = quote.BondEquivalentYield
+		}
+		valueBytes, err := json.Marshal(value)
+		if err != nil {
+			fmt.Println("Error marshalling " + args[0])
+			return nil, err
+		}
+		return valueBytes, nil
+	} else if args[0] == "GetMaturingCPs" {
+		fmt.Println("Getting maturing cps")
+		if len(args) != 3 {
+			return nil, errors.New("Incorrect number of arguments. Expecting GetMaturingCPs, from and to in milliseconds")
+		}
+		maturing, err := getMaturingCPs(stub, args[1], args[2])
+		if err != nil {
+			fmt.Println("Error from getMaturingCPs")
+			return nil, err
+		}
+		maturingBytes, err := json.Marshal(&maturing)
+		if err != nil {
+			fmt.Println("Error marshalling maturing cps")
+			return nil, err
+		}
+		return maturingBytes, nil
+	} else if args[0] == "ValidateCUSIP" {
+		fmt.Println("Validating CUSIP")
+		if len(args) != 2 {
+			return nil, errors.New("Incorrect number of arguments. Expecting ValidateCUSIP and the CUSIP")
+		}
+		validation, err := validateCUSIP(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+		validationBytes, err := json.Marshal(&validation)
+		if err != nil {
+			fmt.Println("Error marshalling CUSIP validation")
+			return nil, err
+		}
+		return validationBytes, nil
+	} else if args[0] == "GetOrders" {
+		fmt.Println("Getting orders")
+		if len(args) != 2 {
+			return nil, errors.New("Incorrect number of arguments. Expecting GetOrders and the CUSIP")
+		}
+		orders, err := getOrders(stub, args[1])
+		if err != nil {
+			fmt.Println("Error from getOrders")
+			return nil, err
+		}
+		ordersBytes, err := json.Marshal(&orders)
+		if err != nil {
+			fmt.Println("Error marshalling orders")
+			return nil, err
+		}
+		return ordersBytes, nil
+	} else if args[0] == "GetSettlement" {
+		fmt.Println("Getting settlement")
+		if len(args) != 2 {
+			return nil, errors.New("Incorrect number of arguments. Expecting GetSettlement and the settlement ID")
+		}
+		settlement, err := getSettlement(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+		settlementBytes, err := json.Marshal(&settlement)
+		if err != nil {
+			fmt.Println("Error marshalling settlement")
+			return nil, err
+		}
+		return settlementBytes, nil
+	} else if args[0] == "GetHistory" {
+		fmt.Println("Getting history")
+		if len(args) != 3 {
+			return nil, errors.New("Incorrect number of arguments. Expecting GetHistory, subject (cusip or account) and ID")
+		}
+		history, err := getHistory(stub, args[1], args[2])
+		if err != nil {
+			fmt.Println("Error from getHistory")
+			return nil, err
+		}
+		historyBytes, err := json.Marshal(&history)
+		if err != nil {
+			fmt.Println("Error marshalling history")
+			return nil, err
+		}
+		fmt.Println("All success, returning history")
+		return historyBytes, nil
 	} else {
 		fmt.Println("Generic Query call")
 		bytes, err := stub.GetState(args[0])
@@ -718,15 +628,16 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 }
 
 func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return t.run(newShimStub(stub), function, args)
+}
+
+func (t *SimpleChaincode) run(stub StateStub, function string, args []string) ([]byte, error) {
 	fmt.Println("run is running " + function)
 	
 	if function == "issueCommercialPaper" {
 		fmt.Println("Firing issueCommercialPaper")
 		//Create an asset with some value
 		return t.issueCommercialPaper(stub, args)
-	} else if function == "transferPaper" {
-		fmt.Println("Firing cretransferPaperateAccounts")
-		return t.transferPaper(stub, args)
 	} else if function == "createAccounts" {
 		fmt.Println("Firing createAccounts")
 		return t.createAccounts(stub, args)
@@ -736,6 +647,27 @@ func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []
     } else if function == "init" {
         fmt.Println("Firing init")
         return t.init(stub, args)
+    } else if function == "redeemPaper" {
+        fmt.Println("Firing redeemPaper")
+        return t.redeemPaper(stub, args)
+    } else if function == "placeOrder" {
+        fmt.Println("Firing placeOrder")
+        return t.placeOrder(stub, args)
+    } else if function == "cancelOrder" {
+        fmt.Println("Firing cancelOrder")
+        return t.cancelOrder(stub, args)
+    } else if function == "matchOrders" {
+        fmt.Println("Firing matchOrders")
+        return t.matchOrders(stub, args)
+    } else if function == "confirmSettlement" {
+        fmt.Println("Firing confirmSettlement")
+        return t.confirmSettlement(stub, args)
+    } else if function == "cancelSettlement" {
+        fmt.Println("Firing cancelSettlement")
+        return t.cancelSettlement(stub, args)
+    } else if function == "setFXRate" {
+        fmt.Println("Firing setFXRate")
+        return t.setFXRate(stub, args)
     }
 
 	return nil, errors.New("Received unknown function invocation")
@@ -744,68 +676,6 @@ func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []
 func main() {
 	err := shim.Start(new(SimpleChaincode))
 	if err != nil {
-		fmt.Println("Error starting Simple chaincode: %s", err)
+		fmt.Printf("Error starting Simple chaincode: %s\n", err)
 	}
 }
-
-//lookup tables for last two digits of CUSIP
-var seventhDigit = map[int]string{
-	1:  "A",
-	2:  "B",
-	3:  "C",
-	4:  "D",
-	5:  "E",
-	6:  "F",
-	7:  "G",
-	8:  "H",
-	9:  "J",
-	10: "K",
-	11: "L",
-	12: "M",
-	13: "N",
-	14: "P",
-	15: "Q",
-	16: "R",
-	17: "S",
-	18: "T",
-	19: "U",
-	20: "V",
-	21: "W",
-	22: "X",
-	23: "Y",
-	24: "Z",
-}
-
-var eigthDigit = map[int]string{
-	1:  "1",
-	2:  "2",
-	3:  "3",
-	4:  "4",
-	5:  "5",
-	6:  "6",
-	7:  "7",
-	8:  "8",
-	9:  "9",
-	10: "A",
-	11: "B",
-	12: "C",
-	13: "D",
-	14: "E",
-	15: "F",
-	16: "G",
-	17: "H",
-	18: "J",
-	19: "K",
-	20: "L",
-	21: "M",
-	22: "N",
-	23: "P",
-	24: "Q",
-	25: "R",
-	26: "S",
-	27: "T",
-	28: "U",
-	29: "V",
-	30: "W",
-	31: "X",
-}
@@ -0,0 +1,515 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func run(t *testing.T, cc *SimpleChaincode, stub *MockStub, function string, args ...string) {
+	t.Helper()
+	if _, err := cc.run(stub, function, args); err != nil {
+		t.Fatalf("%s: %v", function, err)
+	}
+}
+
+func query(t *testing.T, cc *SimpleChaincode, stub *MockStub, v interface{}, args ...string) {
+	t.Helper()
+	out, err := cc.query(stub, "query", args)
+	if err != nil {
+		t.Fatalf("%s: %v", args[0], err)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		t.Fatalf("%s: decoding %s: %v", args[0], out, err)
+	}
+}
+
+// createAccount creates the company's account signed by its own
+// certificate, which then owns it.
+func createAccount(t *testing.T, cc *SimpleChaincode, stub *MockStub, args ...string) {
+	t.Helper()
+	stub.Certificate = []byte(args[0] + "-cert")
+	run(t, cc, stub, "createAccount", args...)
+}
+
+func fingerprint(cert string) string {
+	sum := sha256.Sum256([]byte(cert))
+	return hex.EncodeToString(sum[:])
+}
+
+const testPaper = `{"ticker":"ACME","par":1000,"qty":10,"discount":7.5,"maturity":30,"issuer":"company1","issueDate":"1464771600000"}`
+
+// newPaperLedger returns a ledger with two accounts and one paper issued by
+// company1.
+func newPaperLedger(t *testing.T) (*SimpleChaincode, *MockStub, string) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	run(t, cc, stub, "init")
+	createAccount(t, cc, stub, "company1")
+	createAccount(t, cc, stub, "company2")
+	stub.Certificate = []byte("company1-cert")
+	run(t, cc, stub, "issueCommercialPaper", testPaper)
+
+	cusip, err := newCUSIP("000001", "00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc, stub, cusip
+}
+
+func TestCreateAccounts(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	if _, err := cc.run(stub, "createAccounts", []string{"12"}); err == nil {
+		t.Fatal("expected accounts to need an owner certificate")
+	}
+	stub.Certificate = []byte("admin-cert")
+	run(t, cc, stub, "init")
+	createAccount(t, cc, stub, "company12")
+
+	// Only the administrator creates accounts in bulk
+	stub.Certificate = []byte("intruder-cert")
+	if _, err := cc.run(stub, "createAccounts", []string{"3"}); err == nil {
+		t.Error("expected bulk creation by another certificate to be refused")
+	}
+
+	// Existing companies are never taken over, and a refused batch writes nothing
+	stub.Certificate = []byte("admin-cert")
+	before := stub.Snapshot()
+	if _, err := cc.run(stub, "createAccounts", []string{"12"}); err == nil {
+		t.Error("expected bulk creation over an existing company to be refused")
+	}
+	if !reflect.DeepEqual(before, stub.Snapshot()) {
+		t.Error("refused bulk creation changed the ledger")
+	}
+	run(t, cc, stub, "createAccounts", "11")
+	if _, err := cc.run(stub, "createAccounts", []string{"11"}); err == nil {
+		t.Error("expected repeated bulk creation to be refused")
+	}
+
+	var company Account
+	query(t, cc, stub, &company, "GetCompany", "company3")
+	if company.ID != "company3" || company.balance(defaultCurrency).String() != "10000000.00 USD" || company.Owner != fingerprint("admin-cert") {
+		t.Errorf("unexpected account %+v", company)
+	}
+	query(t, cc, stub, &company, "GetCompany", "company11")
+	if company.Prefix != "" {
+		t.Errorf("expected no issuer number before issuing paper, got %q", company.Prefix)
+	}
+	query(t, cc, stub, &company, "GetCompany", "company12")
+	if company.Owner != fingerprint("company12-cert") {
+		t.Errorf("expected company12 to keep its owner, got %+v", company)
+	}
+}
+
+func TestCreateAccount(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	createAccount(t, cc, stub, "company1")
+
+	var company Account
+	query(t, cc, stub, &company, "GetCompany", "company1")
+	if company.ID != "company1" || company.Prefix != "" || company.Owner != fingerprint("company1-cert") {
+		t.Errorf("unexpected account %+v", company)
+	}
+	if _, err := cc.run(stub, "createAccount", []string{"company1"}); err == nil {
+		t.Error("expected an error re-creating an existing account")
+	}
+}
+
+func TestIssueCommercialPaper(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+
+	var cps []CP
+	query(t, cc, stub, &cps, "GetAllCPs")
+	if len(cps) != 1 || cps[0].CUSIP != cusip {
+		t.Fatalf("unexpected papers %+v", cps)
+	}
+	if len(cps[0].Owners) != 1 || cps[0].Owners[0] != (Owner{Company: "company1", Quantity: 10}) {
+		t.Errorf("expected the issuer to own the whole issue, got %+v", cps[0].Owners)
+	}
+
+	var issuer Account
+	query(t, cc, stub, &issuer, "GetCompany", "company1")
+	if issuer.Prefix != "000001" || len(issuer.AssetsIds) != 1 || issuer.AssetsIds[0] != cusip {
+		t.Errorf("unexpected issuer %+v", issuer)
+	}
+
+	// A second paper maturing on the same day gets the next issue number
+	run(t, cc, stub, "issueCommercialPaper", testPaper)
+	second, err := newCUSIP("000001", "01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query(t, cc, stub, &cps, "GetAllCPs")
+	if len(cps) != 2 || cps[1].CUSIP != second {
+		t.Fatalf("expected the second paper to be %s, got %+v", second, cps)
+	}
+
+	// Numbers taken by paper issued before numbers were allocated are skipped
+	third, err := newCUSIP("000001", "02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.State[cpPrefix+third] = stub.State[cpPrefix+cusip]
+	run(t, cc, stub, "issueCommercialPaper", testPaper)
+	fourth, err := newCUSIP("000001", "03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.State[cpPrefix+fourth] == nil {
+		t.Errorf("expected the next paper to skip %s for %s", third, fourth)
+	}
+
+	// Only the owner of the issuer issues its paper
+	if _, err := cc.run(stub, "issueCommercialPaper", []string{strings.Replace(testPaper, `"issuer":"company1"`, `"issuer":"company2"`, 1)}); err == nil {
+		t.Error("expected paper issued for another company to be refused")
+	}
+
+	// Each issuer gets its own issuer number
+	stub.Certificate = []byte("company2-cert")
+	run(t, cc, stub, "issueCommercialPaper", strings.Replace(testPaper, `"issuer":"company1"`, `"issuer":"company2"`, 1))
+	other, err := newCUSIP("000002", "00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issued CP
+	query(t, cc, stub, &issued, "GetCP", cpPrefix+other)
+	if issued.Issuer != "company2" {
+		t.Errorf("unexpected paper %+v", issued)
+	}
+}
+
+func TestTransferPaper(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	trade(t, cc, stub, cusip, "company1", "company2", 4, "993.75 USD", "")
+
+	var cp CP
+	query(t, cc, stub, &cp, "GetCP", cpPrefix+cusip)
+	want := []Owner{{Company: "company1", Quantity: 6}, {Company: "company2", Quantity: 4}}
+	if len(cp.Owners) != 2 || cp.Owners[0] != want[0] || cp.Owners[1] != want[1] {
+		t.Errorf("unexpected owners %+v", cp.Owners)
+	}
+
+	var seller, buyer Account
+	query(t, cc, stub, &seller, "GetCompany", "company1")
+	query(t, cc, stub, &buyer, "GetCompany", "company2")
+	if seller.balance(defaultCurrency).String() != "10003975.00 USD" || buyer.balance(defaultCurrency).String() != "9996025.00 USD" {
+		t.Errorf("unexpected balances: seller %v, buyer %v", seller.Balances, buyer.Balances)
+	}
+
+	// Papers change hands only through the order book
+	if _, err := cc.run(stub, "transferPaper", []string{`{"cusip":"` + cusip + `","fromCompany":"company1","toCompany":"company2","quantity":1}`}); err == nil {
+		t.Error("expected a bilateral transfer to be refused")
+	}
+}
+
+func TestRefusedOrdersLeaveNoTrace(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	poor, err := json.Marshal(&Account{ID: "company3", Balances: Balances{defaultCurrency: wholeMoney(100, defaultCurrency)}, Owner: fingerprint("company3-cert")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.State[accountPrefix+"company3"] = poor
+
+	for _, tc := range []struct {
+		name  string
+		cert  string
+		order string
+	}{
+		{"more than owned", "company1", `{"side":"sell","company":"company1","quantity":11}`},
+		{"not an owner", "company2", `{"side":"sell","company":"company2","quantity":1}`},
+		{"insufficient funds", "company3", `{"side":"buy","company":"company3","quantity":1}`},
+		{"zero quantity", "company1", `{"side":"sell","company":"company1","quantity":0}`},
+		{"unknown buyer", "company9", `{"side":"buy","company":"company9","quantity":1}`},
+		{"another company", "company2", `{"side":"sell","company":"company1","quantity":1}`},
+		{"unknown paper", "company1", `{"cusip":"company1000AZZ","side":"sell","company":"company1","quantity":1}`},
+	} {
+		before := stub.Snapshot()
+		order := strings.Replace(tc.order, "{", `{"cusip":"`+cusip+`","price":"993.75 USD",`, 1)
+		stub.Certificate = []byte(tc.cert + "-cert")
+		if _, err := cc.run(stub, "placeOrder", []string{order}); err == nil {
+			t.Errorf("%s: expected the order to be refused", tc.name)
+		}
+		if !reflect.DeepEqual(before, stub.Snapshot()) {
+			t.Errorf("%s: refused order changed the ledger", tc.name)
+		}
+	}
+}
+
+func TestPaperEvents(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	trade(t, cc, stub, cusip, "company1", "company2", 4, "993.75 USD", "")
+
+	if len(stub.Events) != 4 || stub.Events[0].Name != EventPaperIssued || stub.Events[1].Name != EventOrderPlaced || stub.Events[3].Name != EventOrdersMatched {
+		t.Fatalf("unexpected events %+v", stub.Events)
+	}
+	var event Event
+	if err := json.Unmarshal(stub.Events[3].Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.SchemaVersion != eventSchemaVersion || event.Type != EventOrdersMatched || event.TxID != "tx0" ||
+		event.CUSIP != cusip || event.Quantity != 4 {
+		t.Errorf("unexpected payload %+v", event)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	stub.TxID = "tx1"
+	stub.TxTimestamp = stub.TxTimestamp.Add(time.Hour)
+	trade(t, cc, stub, cusip, "company1", "company2", 4, "993.75 USD", "")
+
+	var history []HistoryEntry
+	query(t, cc, stub, &history, "GetHistory", HistoryCUSIP, cusip)
+	if len(history) != 2 || history[0].Action != "issueCommercialPaper" || history[1].Action != "matchOrders" || history[1].TxID != "tx1" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	if len(history[1].Changes) != 1 || history[1].Changes[0].Field != "owner" {
+		t.Errorf("expected only the owners to change, got %+v", history[1].Changes)
+	}
+
+	query(t, cc, stub, &history, "GetHistory", HistoryAccount, "company1")
+	last := history[len(history)-1]
+	if last.Action != "matchOrders" || len(last.Changes) != 1 || last.Changes[0].Field != "balances" ||
+		string(last.Changes[0].Before) != `{"USD":"10000000.00 USD"}` || string(last.Changes[0].After) != `{"USD":"10003975.00 USD"}` {
+		t.Errorf("unexpected balance change %+v", last)
+	}
+}
+
+func TestTransferPaperAcrossCurrencies(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	oracle := sha256.Sum256([]byte("oracle-cert"))
+	run(t, cc, stub, "init", hex.EncodeToString(oracle[:]))
+	createAccount(t, cc, stub, "company3", "INR")
+
+	const rate = `{"base":"USD","quote":"INR","rate":"83.25"}`
+	stub.Certificate = []byte("someone-else")
+	if _, err := cc.run(stub, "setFXRate", []string{rate}); err == nil {
+		t.Fatal("expected a caller who is not an oracle to be refused")
+	}
+	stub.Certificate = []byte("oracle-cert")
+	run(t, cc, stub, "setFXRate", rate)
+
+	outsider := sha256.Sum256([]byte("someone-else"))
+	stub.Certificate = []byte("someone-else")
+	if _, err := cc.run(stub, "init", []string{hex.EncodeToString(outsider[:])}); err == nil {
+		t.Fatal("expected a caller who is not an oracle to be refused replacing the oracles")
+	}
+	if _, err := cc.run(stub, "setFXRate", []string{rate}); err == nil {
+		t.Fatal("expected the refused init to leave the oracles unchanged")
+	}
+	stub.Certificate = []byte("oracle-cert")
+
+	stub.Certificate = []byte("company3-cert")
+	if _, err := cc.run(stub, "placeOrder", []string{`{"side":"buy","cusip":"` + cusip + `","company":"company3","quantity":4,"price":"993.75 USD"}`}); err == nil {
+		t.Error("expected a buyer without USD to need a payment currency")
+	}
+	trade(t, cc, stub, cusip, "company1", "company3", 4, "993.75 USD", `,"paymentCurrency":"inr"`)
+
+	var seller, buyer Account
+	query(t, cc, stub, &seller, "GetCompany", "company1")
+	query(t, cc, stub, &buyer, "GetCompany", "company3")
+	if seller.balance("USD").String() != "10003975.00 USD" {
+		t.Errorf("expected the seller to be paid in USD, got %v", seller.Balances)
+	}
+	// 3975 USD at 83.25
+	if buyer.balance("INR").String() != "9669081.25 INR" || len(buyer.Balances) != 1 {
+		t.Errorf("expected the buyer to pay in INR, got %v", buyer.Balances)
+	}
+}
+
+func TestRedeemPaper(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	trade(t, cc, stub, cusip, "company1", "company2", 4, "993.75 USD", "")
+
+	if _, err := cc.run(stub, "redeemPaper", []string{cusip}); err == nil {
+		t.Fatal("expected paper to be redeemable only at maturity")
+	}
+
+	stub.TxTimestamp = stub.TxTimestamp.AddDate(0, 0, 30)
+	stub.Certificate = []byte("company2-cert")
+	if _, err := cc.run(stub, "placeOrder", []string{`{"side":"sell","cusip":"` + cusip + `","company":"company2","quantity":1,"price":"999 USD"}`}); err == nil {
+		t.Error("expected paper past maturity not to be tradable")
+	}
+	run(t, cc, stub, "redeemPaper", cusip)
+
+	var cp CP
+	query(t, cc, stub, &cp, "GetCP", cpPrefix+cusip)
+	if !cp.Matured {
+		t.Error("expected the paper to be marked matured")
+	}
+	// the issuer pays par for the 4 units it no longer holds
+	var issuer, holder Account
+	query(t, cc, stub, &issuer, "GetCompany", "company1")
+	query(t, cc, stub, &holder, "GetCompany", "company2")
+	if issuer.balance(defaultCurrency).String() != "9999975.00 USD" || holder.balance(defaultCurrency).String() != "10000025.00 USD" {
+		t.Errorf("unexpected balances: issuer %v, holder %v", issuer.Balances, holder.Balances)
+	}
+	if last := stub.Events[len(stub.Events)-1]; last.Name != EventPaperRedeemed {
+		t.Errorf("expected a %s event, got %s", EventPaperRedeemed, last.Name)
+	}
+
+	before := stub.Snapshot()
+	if _, err := cc.run(stub, "redeemPaper", []string{cusip}); err == nil {
+		t.Error("expected paper to be redeemed only once")
+	}
+	if !reflect.DeepEqual(before, stub.Snapshot()) {
+		t.Error("failed redemption changed the ledger")
+	}
+}
+
+func TestGetMaturingCPs(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	run(t, cc, stub, "issueCommercialPaper", strings.Replace(testPaper, `"maturity":30`, `"maturity":90`, 1))
+
+	day := func(days int) string { return ms(stub.TxTimestamp.AddDate(0, 0, days)) }
+	var maturing []CP
+	query(t, cc, stub, &maturing, "GetMaturingCPs", day(0), day(30))
+	if len(maturing) != 1 || maturing[0].CUSIP != cusip {
+		t.Errorf("expected only the 30 day paper, got %+v", maturing)
+	}
+	query(t, cc, stub, &maturing, "GetMaturingCPs", day(0), day(120))
+	if len(maturing) != 2 || maturing[0].CUSIP != cusip || maturing[1].Maturity != 90 {
+		t.Errorf("expected both papers soonest first, got %+v", maturing)
+	}
+	query(t, cc, stub, &maturing, "GetMaturingCPs", day(31), day(89))
+	if len(maturing) != 0 {
+		t.Errorf("expected no papers, got %+v", maturing)
+	}
+}
+
+func TestCUSIPCheckDigit(t *testing.T) {
+	for _, cusip := range []string{"037833100", "17275R102", "38259P508", "594918104"} {
+		check, err := cusipCheckDigit(cusip[:8])
+		if err != nil || check != cusip[8] {
+			t.Errorf("%s: got check digit %q, %v", cusip, check, err)
+		}
+	}
+	if _, err := cusipCheckDigit("0378331-"); err == nil {
+		t.Error("expected an invalid character to be rejected")
+	}
+}
+
+func TestValidateCUSIP(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	validate := func(cusip string) (result CUSIPValidation) {
+		query(t, cc, stub, &result, "ValidateCUSIP", cusip)
+		return result
+	}
+	result := validate(strings.ToLower(cusip))
+	if !result.Valid || result.IssuerNumber != "000001" || result.Issuer != "company1" || !result.Issued {
+		t.Errorf("unexpected validation %+v", result)
+	}
+
+	result = validate("037833100")
+	if !result.Valid || result.Issuer != "" || result.Issued {
+		t.Errorf("expected a valid CUSIP not on the ledger, got %+v", result)
+	}
+	for _, invalid := range []string{"037833101", "03783310", "0378331-0"} {
+		result = validate(invalid)
+		if result.Valid || result.Reason == "" {
+			t.Errorf("%s: expected to be invalid, got %+v", invalid, result)
+		}
+	}
+}
+
+func TestEscrowedTransfer(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	escrowed := `,"escrowFor":"` + strconv.Itoa(int(time.Hour/time.Millisecond)) + `"`
+	balances := func() (string, string) {
+		var seller, buyer Account
+		query(t, cc, stub, &seller, "GetCompany", "company1")
+		query(t, cc, stub, &buyer, "GetCompany", "company2")
+		return seller.balance(defaultCurrency).String(), buyer.balance(defaultCurrency).String()
+	}
+	paper := func() (cp CP) {
+		query(t, cc, stub, &cp, "GetCP", cpPrefix+cusip)
+		return cp
+	}
+
+	stub.TxID = "t1"
+	s1 := trade(t, cc, stub, cusip, "company1", "company2", 4, "993.75 USD", escrowed)[0].SettlementID
+	cp := paper()
+	if s1 == "" || cp.holding("company1") != 6 || cp.holding("company2") != 0 || cp.Escrowed != 4 {
+		t.Errorf("expected 4 units in escrow under %q, got %+v", s1, cp)
+	}
+	if seller, buyer := balances(); seller != "10000000.00 USD" || buyer != "9996025.00 USD" {
+		t.Errorf("expected only the buyer's cash to be held, got %s and %s", seller, buyer)
+	}
+
+	// Only the buyer confirms the delivery it pays for
+	for _, cert := range []string{"someone-else", "company1-cert"} {
+		stub.Certificate = []byte(cert)
+		if _, err := cc.run(stub, "confirmSettlement", []string{s1}); err == nil {
+			t.Errorf("expected %s to be refused releasing the settlement", cert)
+		}
+	}
+	stub.Certificate = []byte("company2-cert")
+	run(t, cc, stub, "confirmSettlement", s1)
+	cp = paper()
+	if cp.holding("company1") != 6 || cp.holding("company2") != 4 || cp.Escrowed != 0 {
+		t.Errorf("expected the paper delivered, got %+v", cp)
+	}
+	if seller, buyer := balances(); seller != "10003975.00 USD" || buyer != "9996025.00 USD" {
+		t.Errorf("expected the seller paid, got %s and %s", seller, buyer)
+	}
+	if _, err := cc.run(stub, "cancelSettlement", []string{s1}); err == nil {
+		t.Error("expected a released settlement not to be refunded")
+	}
+
+	// Unconfirmed settlements are refunded once they time out
+	stub.TxID = "t2"
+	s2 := trade(t, cc, stub, cusip, "company1", "company2", 2, "993.75 USD", escrowed)[0].SettlementID
+	for _, cert := range []string{"someone-else", "company2-cert"} {
+		stub.Certificate = []byte(cert)
+		if _, err := cc.run(stub, "cancelSettlement", []string{s2}); err == nil {
+			t.Errorf("expected %s to be refused cancelling before the timeout", cert)
+		}
+	}
+	stub.TxTimestamp = stub.TxTimestamp.Add(2 * time.Hour)
+	stub.Certificate = []byte("someone-else")
+	run(t, cc, stub, "cancelSettlement", s2)
+	var settlement Settlement
+	query(t, cc, stub, &settlement, "GetSettlement", s2)
+	cp = paper()
+	if settlement.Status != SettlementRefunded || cp.holding("company1") != 6 || cp.Escrowed != 0 {
+		t.Errorf("expected the settlement refunded, got %+v and %+v", settlement, cp)
+	}
+	if seller, buyer := balances(); seller != "10003975.00 USD" || buyer != "9996025.00 USD" {
+		t.Errorf("expected the buyer's cash returned, got %s and %s", seller, buyer)
+	}
+
+	// The seller may give up the payment before the timeout
+	stub.TxID = "t3"
+	s3 := trade(t, cc, stub, cusip, "company1", "company2", 1, "993.75 USD", escrowed)[0].SettlementID
+	stub.Certificate = []byte("company1-cert")
+	run(t, cc, stub, "cancelSettlement", s3)
+	query(t, cc, stub, &settlement, "GetSettlement", s3)
+	if settlement.Status != SettlementRefunded {
+		t.Errorf("expected the settlement refunded, got %+v", settlement)
+	}
+}
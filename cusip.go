@@ -0,0 +1,281 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A CUSIP is nine characters: a 6-character issuer number, a 2-character
+// issue number and a check digit. Issuer numbers are allocated to accounts
+// from a ledger sequence, and issue numbers to each paper from a sequence
+// kept per issuer number.
+const (
+	cusipLength       = 9
+	issuerNumberLen   = 6
+	issuerPrefix      = "issuer:"
+	issuerSequenceKey = "issuerSequence"
+	// issueSequencePrefix + issuer number holds its issue number sequence
+	issueSequencePrefix = "issueSequence:"
+	maxIssuerNumber     = 999999
+)
+
+// cusipCharValue is the value of a CUSIP character in the check digit sum:
+// digits are themselves, letters A-Z 10 to 35 and *, @, # 36 to 38.
+func cusipCharValue(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10, true
+	case c == '*':
+		return 36, true
+	case c == '@':
+		return 37, true
+	case c == '#':
+		return 38, true
+	}
+	return 0, false
+}
+
+// cusipCheckDigit computes the modulus 10 "double-add-double" check digit
+// of the first eight characters: every second character's value is doubled
+// and the digits of all the values summed.
+func cusipCheckDigit(base string) (byte, error) {
+	if len(base) != cusipLength-1 {
+		return 0, errors.New("CUSIP base " + base + " must be 8 characters")
+	}
+	sum := 0
+	for i := 0; i < len(base); i++ {
+		v, ok := cusipCharValue(base[i])
+		if !ok {
+			return 0, errors.New("Invalid character " + strconv.Quote(string(base[i])) + " in CUSIP " + base)
+		}
+		if i%2 == 1 {
+			v *= 2
+		}
+		sum += v/10 + v%10
+	}
+	return byte('0' + (10-sum%10)%10), nil
+}
+
+// newCUSIP assembles a CUSIP from an issuer number and an issue number.
+func newCUSIP(issuerNumber string, issue string) (string, error) {
+	if !validIssuerNumber(issuerNumber) {
+		return "", errors.New("Invalid issuer number " + issuerNumber)
+	}
+	check, err := cusipCheckDigit(issuerNumber + issue)
+	if err != nil {
+		return "", err
+	}
+	return issuerNumber + issue + string(check), nil
+}
+
+func validIssuerNumber(issuerNumber string) bool {
+	if len(issuerNumber) != issuerNumberLen {
+		return false
+	}
+	for i := 0; i < len(issuerNumber); i++ {
+		c := issuerNumber[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// issuerOf returns the account an issuer number is allocated to, or "".
+func issuerOf(stub StateStub, issuerNumber string) (string, error) {
+	idBytes, err := stub.GetState(issuerPrefix + issuerNumber)
+	if err != nil {
+		fmt.Println("Error retrieving issuer " + issuerNumber)
+		return "", errors.New("Error retrieving issuer " + issuerNumber)
+	}
+	return string(idBytes), nil
+}
+
+// assignIssuerNumber makes sure the account holds an issuer number of its
+// own, allocating the next free one from the ledger sequence when it does
+// not. Accounts opened before issuer numbers were allocated carry a prefix
+// that is not registered to them and get a new number the first time they
+// issue paper.
+func assignIssuerNumber(stub StateStub, account *Account) error {
+	if validIssuerNumber(account.Prefix) {
+		owner, err := issuerOf(stub, account.Prefix)
+		if err != nil {
+			return err
+		}
+		if owner == account.ID {
+			return nil
+		}
+	}
+
+	sequence := 0
+	sequenceBytes, err := stub.GetState(issuerSequenceKey)
+	if err != nil {
+		return errors.New("Error retrieving the issuer number sequence")
+	}
+	if sequenceBytes != nil {
+		err = json.Unmarshal(sequenceBytes, &sequence)
+		if err != nil {
+			return errors.New("Error reading the issuer number sequence")
+		}
+	}
+	for {
+		sequence++
+		if sequence > maxIssuerNumber {
+			fmt.Println("Issuer numbers exhausted")
+			return errors.New("No issuer numbers left to allocate")
+		}
+		issuerNumber := fmt.Sprintf("%06d", sequence)
+		owner, err := issuerOf(stub, issuerNumber)
+		if err != nil {
+			return err
+		}
+		if owner == "" {
+			account.Prefix = issuerNumber
+			break
+		}
+	}
+
+	sequenceBytes, err = json.Marshal(sequence)
+	if err != nil {
+		return errors.New("Error writing the issuer number sequence")
+	}
+	err = stub.PutState(issuerSequenceKey, sequenceBytes)
+	if err != nil {
+		return errors.New("Error writing the issuer number sequence")
+	}
+	err = stub.PutState(issuerPrefix+account.Prefix, []byte(account.ID))
+	if err != nil {
+		fmt.Println("Error registering issuer number " + account.Prefix)
+		return errors.New("Error registering issuer number " + account.Prefix)
+	}
+	fmt.Println("Allocated issuer number " + account.Prefix + " to " + account.ID)
+	return nil
+}
+
+// issueNumberChars are the characters of an issue number, leaving out I
+// and O, which read as 1 and 0.
+const issueNumberChars = "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// maxIssueNumber is how many issue numbers one issuer number has.
+const maxIssueNumber = len(issueNumberChars) * len(issueNumberChars)
+
+func issueNumber(n int) string {
+	base := len(issueNumberChars)
+	return string(issueNumberChars[n/base]) + string(issueNumberChars[n%base])
+}
+
+// nextCUSIP allocates the issuer's next free issue number from its ledger
+// sequence and returns the CUSIP it makes. Numbers already taken by paper
+// issued before numbers were allocated are skipped.
+func nextCUSIP(stub StateStub, issuerNumber string) (string, error) {
+	sequence := 0
+	sequenceBytes, err := stub.GetState(issueSequencePrefix + issuerNumber)
+	if err != nil {
+		return "", errors.New("Error retrieving the issue number sequence of " + issuerNumber)
+	}
+	if sequenceBytes != nil {
+		err = json.Unmarshal(sequenceBytes, &sequence)
+		if err != nil {
+			return "", errors.New("Error reading the issue number sequence of " + issuerNumber)
+		}
+	}
+	for ; sequence < maxIssueNumber; sequence++ {
+		cusip, err := newCUSIP(issuerNumber, issueNumber(sequence))
+		if err != nil {
+			return "", err
+		}
+		existing, err := stub.GetState(cpPrefix + cusip)
+		if err != nil {
+			fmt.Println("Error retrieving cp " + cusip)
+			return "", errors.New("Error retrieving cp " + cusip)
+		}
+		if existing != nil {
+			continue
+		}
+		sequenceBytes, err = json.Marshal(sequence + 1)
+		if err != nil {
+			return "", errors.New("Error writing the issue number sequence of " + issuerNumber)
+		}
+		err = stub.PutState(issueSequencePrefix+issuerNumber, sequenceBytes)
+		if err != nil {
+			return "", errors.New("Error writing the issue number sequence of " + issuerNumber)
+		}
+		return cusip, nil
+	}
+	fmt.Println("Issue numbers of " + issuerNumber + " exhausted")
+	return "", errors.New("No issue numbers left to allocate to issuer " + issuerNumber)
+}
+
+// CUSIPValidation is the result of the ValidateCUSIP query.
+type CUSIPValidation struct {
+	CUSIP        string `json:"cusip"`
+	Valid        bool   `json:"valid"`
+	Reason       string `json:"reason,omitempty"`
+	IssuerNumber string `json:"issuerNumber,omitempty"`
+	IssueNumber  string `json:"issueNumber,omitempty"`
+	CheckDigit   string `json:"checkDigit,omitempty"`
+	// Issuer is the account the issuer number is allocated to, if any
+	Issuer string `json:"issuer,omitempty"`
+	// Issued reports whether paper with this CUSIP is on the ledger
+	Issued bool `json:"issued"`
+}
+
+// validateCUSIP checks the form and check digit of a CUSIP and looks up
+// its issuer and paper.
+func validateCUSIP(stub StateStub, cusip string) (CUSIPValidation, error) {
+	cusip = strings.ToUpper(strings.TrimSpace(cusip))
+	result := CUSIPValidation{CUSIP: cusip}
+	if len(cusip) != cusipLength {
+		result.Reason = "A CUSIP must be 9 characters"
+		return result, nil
+	}
+	result.IssuerNumber = cusip[:issuerNumberLen]
+	result.IssueNumber = cusip[issuerNumberLen : cusipLength-1]
+	result.CheckDigit = cusip[cusipLength-1:]
+
+	check, err := cusipCheckDigit(cusip[:cusipLength-1])
+	if err != nil {
+		result.Reason = err.Error()
+		return result, nil
+	}
+	if check != cusip[cusipLength-1] {
+		result.Reason = "Check digit should be " + string(check)
+		return result, nil
+	}
+	result.Valid = true
+
+	result.Issuer, err = issuerOf(stub, result.IssuerNumber)
+	if err != nil {
+		return result, err
+	}
+	cpBytes, err := stub.GetState(cpPrefix + cusip)
+	if err != nil {
+		fmt.Println("Error retrieving cp " + cusip)
+		return result, errors.New("Error retrieving cp " + cusip)
+	}
+	result.Issued = cpBytes != nil
+	return result, nil
+}
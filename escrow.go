@@ -0,0 +1,262 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var escrowPrefix = "escrow:"
+
+// Settlement states.
+const (
+	SettlementLocked   = "LOCKED"
+	SettlementReleased = "RELEASED"
+	SettlementRefunded = "REFUNDED"
+)
+
+// Settlement holds both legs of a trade in escrow under one ID: the buyer's
+// cash and, when paper is traded, the seller's paper. Releasing the
+// settlement delivers the paper to the buyer and pays the seller in one
+// write batch; refunding it returns each leg to where it came from.
+type Settlement struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Payer's Cash is held; Payee is owed Payment, which is the same amount
+	// unless the payer pays in another currency
+	Payer   string `json:"payer"`
+	Payee   string `json:"payee"`
+	Cash    Money  `json:"cash"`
+	Payment Money  `json:"payment"`
+	// Quantity units of the paper are held from the payee for the payer
+	CUSIP    string `json:"cusip,omitempty"`
+	Quantity int    `json:"quantity,omitempty"`
+	// Timeout is when an unconfirmed settlement may be refunded by anyone
+	Timeout time.Time `json:"timeout"`
+	// LockedBy is the fingerprint of the certificate that locked the
+	// settlement
+	LockedBy string    `json:"lockedBy"`
+	LockedAt time.Time `json:"lockedAt"`
+	SettleTx string    `json:"settleTx,omitempty"`
+}
+
+func getSettlement(stub StateStub, id string) (Settlement, error) {
+	var settlement Settlement
+	settlementBytes, err := stub.GetState(escrowPrefix + id)
+	if err != nil || settlementBytes == nil {
+		return settlement, errors.New("Settlement not found " + id)
+	}
+	err = json.Unmarshal(settlementBytes, &settlement)
+	if err != nil {
+		fmt.Println("Error unmarshalling settlement " + id)
+		return settlement, errors.New("Error unmarshalling settlement " + id)
+	}
+	return settlement, nil
+}
+
+// lockEscrow stages a settlement for cash already taken from the payer
+// and, for a trade in paper, takes the payee's paper into escrow.
+func lockEscrow(stub StateStub, batch *writeBatch, settlement *Settlement, timeout time.Time, cp *CP) error {
+	if settlement.ID == "" {
+		return errors.New("A settlement needs an ID")
+	}
+	existing, err := stub.GetState(escrowPrefix + settlement.ID)
+	if err != nil || existing != nil {
+		fmt.Println("Settlement " + settlement.ID + " already exists")
+		return errors.New("Settlement " + settlement.ID + " already exists")
+	}
+	settlement.LockedAt, err = stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	if !timeout.After(settlement.LockedAt) {
+		return errors.New("A settlement needs a future timeout")
+	}
+	settlement.Timeout = timeout.UTC()
+	settlement.LockedBy, err = callerFingerprint(stub)
+	if err != nil {
+		return err
+	}
+	settlement.Status = SettlementLocked
+
+	if cp != nil {
+		if cp.holding(settlement.Payee) < settlement.Quantity {
+			return errors.New("The company " + settlement.Payee + " doesn't own enough of this paper")
+		}
+		cp.addHolding(settlement.Payee, -settlement.Quantity)
+		cp.Escrowed += settlement.Quantity
+	}
+	return batch.put(escrowPrefix+settlement.ID, settlement, "", "", "")
+}
+
+// settleEscrow releases a locked settlement to its counterparties, or
+// refunds it, and writes the changes together.
+func settleEscrow(stub StateStub, settlement *Settlement, release bool) error {
+	if settlement.Status != SettlementLocked {
+		return errors.New("Settlement " + settlement.ID + " is already " + settlement.Status)
+	}
+	action := "refundSettlement"
+	if release {
+		action = "releaseSettlement"
+	}
+
+	batch := newWriteBatch(stub)
+	if release {
+		payee, err := GetCompany(settlement.Payee, stub)
+		if err != nil {
+			return err
+		}
+		err = payee.credit(settlement.Payment)
+		if err != nil {
+			return err
+		}
+		err = batch.put(accountPrefix+payee.ID, &payee, HistoryAccount, payee.ID, action)
+		if err != nil {
+			return err
+		}
+	} else {
+		payer, err := GetCompany(settlement.Payer, stub)
+		if err != nil {
+			return err
+		}
+		err = payer.credit(settlement.Cash)
+		if err != nil {
+			return err
+		}
+		err = batch.put(accountPrefix+payer.ID, &payer, HistoryAccount, payer.ID, action)
+		if err != nil {
+			return err
+		}
+	}
+
+	if settlement.CUSIP != "" {
+		cp, err := GetCP(cpPrefix+settlement.CUSIP, stub)
+		if err != nil {
+			return err
+		}
+		cp.Escrowed -= settlement.Quantity
+		if release {
+			cp.addHolding(settlement.Payer, settlement.Quantity)
+			payer, err := GetCompany(settlement.Payer, stub)
+			if err != nil {
+				return err
+			}
+			payer.addAsset(cp.CUSIP)
+			err = batch.put(accountPrefix+payer.ID, &payer, HistoryAccount, payer.ID, action)
+			if err != nil {
+				return err
+			}
+		} else {
+			cp.addHolding(settlement.Payee, settlement.Quantity)
+		}
+		err = batch.put(cpPrefix+cp.CUSIP, &cp, HistoryCUSIP, cp.CUSIP, action)
+		if err != nil {
+			return err
+		}
+	}
+
+	if release {
+		settlement.Status = SettlementReleased
+	} else {
+		settlement.Status = SettlementRefunded
+	}
+	settlement.SettleTx = stub.GetTxID()
+	err := batch.put(escrowPrefix+settlement.ID, settlement, "", "", "")
+	if err != nil {
+		return err
+	}
+	err = batch.commit()
+	if err != nil {
+		return err
+	}
+
+	event := Event{Type: EventSettlementRefunded, CUSIP: settlement.CUSIP, SettlementID: settlement.ID,
+		From: settlement.Payee, To: settlement.Payer, Quantity: settlement.Quantity}
+	if release {
+		event.Type = EventSettlementReleased
+	}
+	return setEvent(stub, event)
+}
+
+// confirmSettlement releases a locked settlement to the payee. Only the
+// payer confirms the delivery it pays for, and only before the timeout.
+func (t *SimpleChaincode) confirmSettlement(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			settlement ID
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting settlement ID")
+	}
+	settlement, err := getSettlement(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, err = requireCompany(stub, settlement.Payer)
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(settlement.Timeout) {
+		return nil, errors.New("Settlement " + settlement.ID + " has timed out and can only be refunded")
+	}
+	err = settleEscrow(stub, &settlement, true)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Released settlement " + settlement.ID)
+	return nil, nil
+}
+
+// cancelSettlement refunds a locked settlement to the payer. Before the
+// timeout only the payee may give up the payment; after it anyone may
+// reclaim it for the payer.
+func (t *SimpleChaincode) cancelSettlement(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			settlement ID
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting settlement ID")
+	}
+	settlement, err := getSettlement(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(settlement.Timeout) {
+		_, err = requireCompany(stub, settlement.Payee)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = settleEscrow(stub, &settlement, false)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Refunded settlement " + settlement.ID)
+	return nil, nil
+}
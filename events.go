@@ -0,0 +1,80 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// eventSchemaVersion is written into every event payload. Fields are only
+// ever added to Event; a change to existing fields bumps the version.
+const eventSchemaVersion = 1
+
+// Chaincode event names. The peer keeps one event per transaction.
+const (
+	EventPaperIssued        = "PaperIssued"
+	EventPaperRedeemed      = "PaperRedeemed"
+	EventOrderPlaced        = "OrderPlaced"
+	EventOrderCancelled     = "OrderCancelled"
+	EventOrdersMatched      = "OrdersMatched"
+	EventSettlementReleased = "SettlementReleased"
+	EventSettlementRefunded = "SettlementRefunded"
+)
+
+// Event is the JSON payload of every chaincode event.
+type Event struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Type          string    `json:"type"`
+	TxID          string    `json:"txId"`
+	Timestamp     time.Time `json:"timestamp"`
+
+	CUSIP        string `json:"cusip"`
+	Issuer       string `json:"issuer,omitempty"`
+	From         string `json:"from,omitempty"`
+	To           string `json:"to,omitempty"`
+	Quantity     int    `json:"quantity"`
+	OrderID      string `json:"orderId,omitempty"`
+	SettlementID string `json:"settlementId,omitempty"`
+}
+
+// setEvent stamps the event with the transaction and raises it.
+func setEvent(stub StateStub, event Event) error {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	event.SchemaVersion = eventSchemaVersion
+	event.TxID = stub.GetTxID()
+	event.Timestamp = timestamp
+
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		fmt.Println("Error marshalling event " + event.Type)
+		return errors.New("Error marshalling event " + event.Type)
+	}
+	err = stub.SetEvent(event.Type, payload)
+	if err != nil {
+		fmt.Println("Error setting event " + event.Type)
+		return errors.New("Error setting event " + event.Type)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Every change to a paper or account appends a HistoryEntry under
+// historyPrefix + subject + ":" + ID + ":", ordered by transaction time.
+// Nothing in the chaincode rewrites or deletes them.
+var historyPrefix = "hist:"
+
+// History subjects.
+const (
+	HistoryCUSIP   = "cusip"
+	HistoryAccount = "account"
+)
+
+// FieldChange is one top-level JSON field that differs between the value
+// before and after a change. Before is empty for new fields, After for
+// removed ones.
+type FieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// HistoryEntry records one change to a subject. Caller is the SHA-256 of
+// the submitter's certificate.
+type HistoryEntry struct {
+	Subject   string        `json:"subject"`
+	ID        string        `json:"id"`
+	Action    string        `json:"action"`
+	TxID      string        `json:"txId"`
+	Timestamp time.Time     `json:"timestamp"`
+	Caller    string        `json:"caller"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+// callerFingerprint identifies the caller by the SHA-256 of its certificate.
+func callerFingerprint(stub StateStub) (string, error) {
+	cert, err := stub.GetCallerCertificate()
+	if err != nil || len(cert) == 0 {
+		return "", errors.New("Caller certificate not available")
+	}
+	sum := sha256.Sum256(cert)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func historyKeyPrefix(subject string, id string) string {
+	return historyPrefix + subject + ":" + id + ":"
+}
+
+// putWithHistory writes value under key and appends the change to the
+// subject's history.
+func putWithHistory(stub StateStub, key string, value []byte, subject string, id string, action string) error {
+	before, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	return recordHistory(stub, subject, id, action, before, value)
+}
+
+func recordHistory(stub StateStub, subject string, id string, action string, before []byte, after []byte) error {
+	changes, err := diffJSON(before, after)
+	if err != nil {
+		fmt.Println("Error comparing " + subject + " " + id)
+		return errors.New("Error recording history of " + subject + " " + id)
+	}
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	entry := HistoryEntry{
+		Subject:   subject,
+		ID:        id,
+		Action:    action,
+		TxID:      stub.GetTxID(),
+		Timestamp: timestamp,
+		Changes:   changes,
+	}
+	entry.Caller, _ = callerFingerprint(stub)
+
+	entryBytes, err := json.Marshal(&entry)
+	if err != nil {
+		fmt.Println("Error marshalling history of " + subject + " " + id)
+		return errors.New("Error recording history of " + subject + " " + id)
+	}
+
+	// The zero-padded timestamp orders entries; a counter separates
+	// changes to the same subject within one transaction.
+	txKey := historyKeyPrefix(subject, id) + fmt.Sprintf("%020d", timestamp.UnixNano()) + "-" + entry.TxID
+	for n := 0; ; n++ {
+		key := txKey + "-" + strconv.Itoa(n)
+		existing, err := stub.GetState(key)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		err = stub.PutState(key, entryBytes)
+		if err != nil {
+			fmt.Println("Error writing history of " + subject + " " + id)
+			return errors.New("Error recording history of " + subject + " " + id)
+		}
+		return nil
+	}
+}
+
+// diffJSON lists the top-level fields that differ between two JSON objects.
+// Either may be empty.
+func diffJSON(before []byte, after []byte) ([]FieldChange, error) {
+	var beforeFields, afterFields map[string]json.RawMessage
+	if len(before) > 0 {
+		err := json.Unmarshal(before, &beforeFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(after) > 0 {
+		err := json.Unmarshal(after, &afterFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var fields []string
+	for field := range beforeFields {
+		fields = append(fields, field)
+	}
+	for field := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []FieldChange{}
+	for _, field := range fields {
+		if !bytes.Equal(compactJSON(beforeFields[field]), compactJSON(afterFields[field])) {
+			changes = append(changes, FieldChange{Field: field, Before: beforeFields[field], After: afterFields[field]})
+		}
+	}
+	return changes, nil
+}
+
+func compactJSON(raw json.RawMessage) []byte {
+	var buf bytes.Buffer
+	if len(raw) == 0 || json.Compact(&buf, raw) != nil {
+		return raw
+	}
+	return buf.Bytes()
+}
+
+// getHistory returns the history of a subject, oldest first.
+func getHistory(stub StateStub, subject string, id string) ([]HistoryEntry, error) {
+	switch subject {
+	case HistoryCUSIP, HistoryAccount:
+	default:
+		return nil, errors.New("Unknown history subject " + subject)
+	}
+
+	history := []HistoryEntry{}
+	err := forEachInRange(stub, historyKeyPrefix(subject, id), func(key string, value []byte) error {
+		var entry HistoryEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			fmt.Println("Error unmarshalling history entry")
+			return errors.New("Error retrieving history of " + subject + " " + id)
+		}
+		history = append(history, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Roles carried in the "role" attribute of a caller's transaction certificate.
+const (
+	RoleAdmin     = "admin"
+	RoleBankAdmin = "bank_admin"
+	RoleValidator = "validator"
+	RoleCustomer  = "customer"
+	RoleFXOracle  = "fx_oracle"
+)
+
+// Caller is the identity submitting a transaction, read from the attributes
+// certified in its transaction certificate: "id" is the account ID the
+// caller acts as, "role" one of the roles above and "bank" the bank ID a
+// bank administrator works for.
+type Caller struct {
+	ID   string
+	Role string
+	Bank string
+}
+
+func getCaller(stub StateStub) (Caller, error) {
+	var caller Caller
+	id, err := stub.ReadCertAttribute("id")
+	if err != nil || len(id) == 0 {
+		fmt.Println("Caller identity not available")
+		return caller, errors.New("Caller identity not available")
+	}
+	caller.ID = string(id)
+
+	// role and bank are optional
+	role, err := stub.ReadCertAttribute("role")
+	if err == nil {
+		caller.Role = string(role)
+	}
+	bank, err := stub.ReadCertAttribute("bank")
+	if err == nil {
+		caller.Bank = string(bank)
+	}
+	return caller, nil
+}
+
+func (c Caller) isBankAdmin(bankID string) bool {
+	return c.Role == RoleBankAdmin && c.Bank == bankID
+}
+
+// requireCaller fails unless the caller acts as id, or is a bank
+// administrator for one of the given banks.
+func requireCaller(stub StateStub, id string, banks ...string) (Caller, error) {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return caller, err
+	}
+	if caller.ID == id {
+		return caller, nil
+	}
+	for _, bank := range banks {
+		if caller.isBankAdmin(bank) {
+			return caller, nil
+		}
+	}
+	fmt.Println("Caller " + caller.ID + " is not authorised to act for " + id)
+	return caller, errors.New("Caller " + caller.ID + " is not authorised to act for " + id)
+}
+
+// requireRole fails unless the caller acts as id and holds role.
+func requireRole(stub StateStub, id string, role string) (Caller, error) {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return caller, err
+	}
+	if caller.ID != id || caller.Role != role {
+		fmt.Println("Caller " + caller.ID + " is not authorised to act as " + role + " " + id)
+		return caller, errors.New("Caller " + caller.ID + " is not authorised to act as " + role + " " + id)
+	}
+	return caller, nil
+}
+
+// requireOracle fails unless the caller is an FX oracle, returning its ID.
+func requireOracle(stub StateStub) (string, error) {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return "", err
+	}
+	if caller.Role != RoleFXOracle {
+		fmt.Println("Caller " + caller.ID + " is not an FX oracle")
+		return "", errors.New("Caller " + caller.ID + " is not an FX oracle")
+	}
+	return caller.ID, nil
+}
+
+// canReadPII reports whether the caller may see a customer's personal data:
+// the customer, administrators of the contract's bank or of the bank now
+// holding the record, and the validators reviewing it for that bank.
+func (c Caller) canReadPII(record KYCRecord, bankcontract BANKCONTRACT) bool {
+	switch {
+	case c.ID == record.Issuer:
+		return true
+	case c.isBankAdmin(bankcontract.BANKID), c.isBankAdmin(record.Owner):
+		return true
+	case c.Role == RoleValidator && indexOf(bankcontract.validatorList(), c.ID) >= 0:
+		return true
+	}
+	return false
+}
+
+// redactPII blanks the customer's personal data, leaving the fields needed
+// to follow the record through the workflow.
+func redactPII(record KYCRecord) KYCRecord {
+	return KYCRecord{
+		SchemaVersion:   record.SchemaVersion,
+		CUSIP:           record.CUSIP,
+		Contract:        record.Contract,
+		ContractVersion: record.ContractVersion,
+		Owner:           record.Owner,
+		Issuer:          record.Issuer,
+		IssueDate:       record.IssueDate,
+		Status:          record.Status,
+		StatusReason:    record.StatusReason,
+		SignOffs:        record.SignOffs,
+		Round:           record.Round,
+		VerifiedAt:      record.VerifiedAt,
+		ValidUntil:      record.ValidUntil,
+	}
+}
+
+// kycReader decides how much of each record the caller may see, caching
+// the contracts it looks up. It does not consult consents: a bank holding
+// one reads the shared parts of a record only through GetSharedKYC.
+type kycReader struct {
+	stub      StateStub
+	caller    Caller
+	contracts map[string]BANKCONTRACT
+}
+
+func newKYCReader(stub StateStub) *kycReader {
+	caller, err := getCaller(stub)
+	if err != nil {
+		caller = Caller{}
+	}
+	return &kycReader{stub: stub, caller: caller, contracts: make(map[string]BANKCONTRACT)}
+}
+
+func (r *kycReader) canReadPII(record KYCRecord) bool {
+	if r.caller.ID == "" {
+		return false
+	}
+	// Records follow the version of the contract they were onboarded under
+	key := record.Contract + "@" + strconv.Itoa(record.ContractVersion)
+	bankcontract, ok := r.contracts[key]
+	if !ok {
+		var err error
+		bankcontract, err = contractForRecord(r.stub, record)
+		if err != nil {
+			bankcontract = BANKCONTRACT{}
+		}
+		r.contracts[key] = bankcontract
+	}
+	return r.caller.canReadPII(record, bankcontract)
+}
+
+// readable returns the record, redacted unless the caller may read it in full.
+func (r *kycReader) readable(record KYCRecord) KYCRecord {
+	if !r.canReadPII(record) {
+		return redactPII(record)
+	}
+	return record
+}
+
+// readableKYCRecords redacts the records the caller may not read in full.
+func readableKYCRecords(stub StateStub, records []KYCRecord) []KYCRecord {
+	reader := newKYCReader(stub)
+	readable := make([]KYCRecord, 0, len(records))
+	for _, record := range records {
+		readable = append(readable, reader.readable(record))
+	}
+	return readable
+}
+
+// readableDoc blanks the content and storage location of a document unless
+// the caller may read the customer record it belongs to.
+func (r *kycReader) readableDoc(doc DOCUMENT) DOCUMENT {
+	recordBytes, err := r.stub.GetState(cpPrefix + doc.CUSIP)
+	if err == nil && recordBytes != nil {
+		record, err := decodeKYCRecord(recordBytes)
+		if err == nil && r.canReadPII(record) {
+			return doc
+		}
+	}
+	doc.FILE = ""
+	doc.URI = ""
+	return doc
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Balances holds an account's cash, keyed by currency code.
+type Balances map[string]Money
+
+// UnmarshalJSON reads accounts written before balances were kept per
+// currency, whose single cashBalance becomes their only balance.
+func (a *Account) UnmarshalJSON(data []byte) error {
+	type account Account
+	var decoded struct {
+		account
+		CashBalance *Money `json:"cashBalance"`
+	}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*a = Account(decoded.account)
+	if decoded.CashBalance != nil && len(a.Balances) == 0 {
+		a.Balances = Balances{decoded.CashBalance.Currency: *decoded.CashBalance}
+	}
+	return nil
+}
+
+// balance returns the account's cash in currency, zero if it holds none.
+func (a Account) balance(currency string) Money {
+	if amount, ok := a.Balances[currency]; ok {
+		return amount
+	}
+	return Money{Currency: currency}
+}
+
+func (a *Account) credit(amount Money) error {
+	balance, err := a.balance(amount.Currency).Add(amount)
+	if err != nil {
+		return err
+	}
+	if a.Balances == nil {
+		a.Balances = make(Balances)
+	}
+	a.Balances[amount.Currency] = balance
+	return nil
+}
+
+// debit fails, leaving the account unchanged, if it holds less than amount
+// in the amount's currency.
+func (a *Account) debit(amount Money) error {
+	balance := a.balance(amount.Currency)
+	short, err := balance.LessThan(amount)
+	if err != nil {
+		return err
+	}
+	if short {
+		return errors.New("The company " + a.ID + " doesn't have enough " + amount.Currency + " to pay " + amount.String())
+	}
+	balance, err = balance.Sub(amount)
+	if err != nil {
+		return err
+	}
+	if a.Balances == nil {
+		a.Balances = make(Balances)
+	}
+	a.Balances[amount.Currency] = balance
+	return nil
+}
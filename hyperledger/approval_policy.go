@@ -0,0 +1,150 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ApprovalPolicy says how many of a contract's validators must approve a KYC
+// record before it is delivered to the bank, and whether they must approve in
+// the order they are listed. A zero Threshold means every validator.
+//
+//	{"threshold": 2}                  any 2 of the validators
+//	{"threshold": 0, "inOrder": true} all of them, one after another
+type ApprovalPolicy struct {
+	Threshold int  `json:"threshold"`
+	InOrder   bool `json:"inOrder"`
+}
+
+// validatorList returns the contract's validators. Contracts issued before
+// VALIDATORS existed only carry the comma separated BANKVALIDATORS string.
+func (c BANKCONTRACT) validatorList() []string {
+	if len(c.VALIDATORS) > 0 {
+		return c.VALIDATORS
+	}
+	return splitValidators(c.BANKVALIDATORS)
+}
+
+// approvalPolicy returns the contract's policy with defaults filled in.
+// Contracts without a policy need every validator to approve in order.
+func (c BANKCONTRACT) approvalPolicy() ApprovalPolicy {
+	policy := ApprovalPolicy{InOrder: true}
+	if c.APPROVALPOLICY != nil {
+		policy = *c.APPROVALPOLICY
+	}
+	if policy.Threshold == 0 {
+		policy.Threshold = len(c.validatorList())
+	}
+	return policy
+}
+
+// validate checks the policy can be satisfied by the given validators.
+func (p ApprovalPolicy) validate(validators []string) error {
+	if len(validators) == 0 {
+		return errors.New("A bank contract needs at least one validator")
+	}
+	seen := make(map[string]bool)
+	for _, validator := range validators {
+		if seen[validator] {
+			return errors.New("Validator " + validator + " is listed more than once")
+		}
+		seen[validator] = true
+	}
+	if p.Threshold < 1 || p.Threshold > len(validators) {
+		return errors.New("Approval threshold must be between 1 and " + strconv.Itoa(len(validators)))
+	}
+	return nil
+}
+
+// firstOwner is who holds a newly submitted record: the first validator when
+// approvals are in order, otherwise the contract's validator panel.
+func (p ApprovalPolicy) firstOwner(bankcontract BANKCONTRACT) string {
+	if p.InOrder {
+		return bankcontract.validatorList()[0]
+	}
+	return bankcontract.CONTRACTID
+}
+
+// checkReviewer returns an error unless validator may approve or reject the
+// record now.
+func (p ApprovalPolicy) checkReviewer(record KYCRecord, validators []string, validator string) error {
+	if indexOf(validators, validator) < 0 {
+		return errors.New(validator + " is not a validator on contract " + record.Contract)
+	}
+	if p.InOrder {
+		if record.Owner != validator {
+			return errors.New("KYC record " + record.CUSIP + " is waiting for " + record.Owner + ", not " + validator)
+		}
+		return nil
+	}
+	for _, signOff := range record.currentSignOffs() {
+		if signOff.Validator == validator && signOff.Decision == DecisionApproved {
+			return errors.New(validator + " has already approved KYC record " + record.CUSIP)
+		}
+	}
+	return nil
+}
+
+// satisfied reports whether the record has enough approvals once approver's
+// sign-off has been recorded.
+func (p ApprovalPolicy) satisfied(record KYCRecord, validators []string, approver string) bool {
+	if p.InOrder {
+		return indexOf(validators, approver)+1 >= p.Threshold
+	}
+	approved := make(map[string]bool)
+	for _, signOff := range record.currentSignOffs() {
+		if signOff.Decision == DecisionApproved && indexOf(validators, signOff.Validator) >= 0 {
+			approved[signOff.Validator] = true
+		}
+	}
+	return len(approved) >= p.Threshold
+}
+
+// nextOwner is who holds the record after approver signs off without the
+// policy being satisfied.
+func (p ApprovalPolicy) nextOwner(bankcontract BANKCONTRACT, approver string) string {
+	if p.InOrder {
+		validators := bankcontract.validatorList()
+		return validators[indexOf(validators, approver)+1]
+	}
+	return bankcontract.CONTRACTID
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// normaliseValidators fills in VALIDATORS from BANKVALIDATORS, or the other
+// way round, so both forms of the contract agree.
+func (c *BANKCONTRACT) normaliseValidators() {
+	var validators []string
+	for _, validator := range c.validatorList() {
+		validators = append(validators, strings.TrimSpace(validator))
+	}
+	c.VALIDATORS = validators
+	c.BANKVALIDATORS = strings.Join(validators, ",")
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// writeBatch stages the state changes of an invoke so every precondition is
+// checked, and every value marshalled, before anything is written. Nothing
+// reaches the ledger until commit, so a failed invoke leaves no partial
+// changes behind.
+type writeBatch struct {
+	stub   StateStub
+	writes []stagedWrite
+}
+
+type stagedWrite struct {
+	key     string
+	value   []byte
+	subject string
+	id      string
+	action  string
+}
+
+func newWriteBatch(stub StateStub) *writeBatch {
+	return &writeBatch{stub: stub}
+}
+
+// put stages value, recorded in the history of subject id under action;
+// values staged with no subject are written without history.
+// Staging the same key again replaces the earlier value.
+func (b *writeBatch) put(key string, value interface{}, subject string, id string, action string) error {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		fmt.Println("Error marshalling " + key)
+		return errors.New("Error marshalling " + key)
+	}
+	write := stagedWrite{key: key, value: valueBytes, subject: subject, id: id, action: action}
+	for i := range b.writes {
+		if b.writes[i].key == key {
+			b.writes[i] = write
+			return nil
+		}
+	}
+	b.writes = append(b.writes, write)
+	return nil
+}
+
+// commit writes the staged values in the order they were first staged.
+func (b *writeBatch) commit() error {
+	for _, write := range b.writes {
+		var err error
+		if write.subject == "" {
+			err = b.stub.PutState(write.key, write.value)
+		} else {
+			err = putWithHistory(b.stub, write.key, write.value, write.subject, write.id, write.action)
+		}
+		if err != nil {
+			fmt.Println("Error writing " + write.key)
+			return errors.New("Error writing " + write.key)
+		}
+	}
+	b.writes = nil
+	return nil
+}
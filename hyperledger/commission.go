@@ -0,0 +1,398 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commission schedule types.
+const (
+	CommissionFlat       = "FLAT"
+	CommissionPercentage = "PERCENTAGE"
+	CommissionTiered     = "TIERED"
+)
+
+// CommissionSchedule says what a bank pays for each KYC record delivered to
+// it under a contract:
+//
+//	{"type": "FLAT", "amount": "50.00 INR"}
+//	{"type": "PERCENTAGE", "rate": "0.25", "minimum": "20.00 INR", "maximum": "500.00 INR"}
+//	{"type": "TIERED", "tiers": [{"upTo": 100, "amount": "50.00 INR"}, {"amount": "40.00 INR"}]}
+//
+// A percentage is of the onboarding value submitted with the record. Tiers
+// price the nth record delivered under the contract in a calendar month
+// (UTC) by the first tier whose upTo is at least n; the last tier has no
+// upper bound.
+//
+// Split shares the commission among the contract's validators in percent,
+// each share rounded to the minor unit; what the shares leave goes to the
+// record's issuer. Shares adding up to 100 leave the rounding difference
+// with the last validator listed.
+type CommissionSchedule struct {
+	Type    string            `json:"type"`
+	Amount  *Money            `json:"amount,omitempty"`
+	Rate    *Rate             `json:"rate,omitempty"`
+	Minimum *Money            `json:"minimum,omitempty"`
+	Maximum *Money            `json:"maximum,omitempty"`
+	Tiers   []CommissionTier  `json:"tiers,omitempty"`
+	Split   []CommissionShare `json:"split,omitempty"`
+}
+
+type CommissionTier struct {
+	UpTo   int   `json:"upTo,omitempty"`
+	Amount Money `json:"amount"`
+}
+
+type CommissionShare struct {
+	Validator string `json:"validator"`
+	Share     Rate   `json:"share"`
+}
+
+// CommissionPayout is what one party is paid for a delivery.
+type CommissionPayout struct {
+	Payee  string
+	Amount Money
+}
+
+// Each validator's earnings are kept under commissionObject, keyed by
+// validator, delivery time and CUSIP, and the number of records delivered
+// under each contract every month under commissionVolumeObject.
+const (
+	commissionObject       = "commission~validator~time~cusip"
+	commissionVolumeObject = "commissionvolume~contract~month"
+)
+
+// CommissionEntry records a validator's share of the commission on one
+// delivery.
+type CommissionEntry struct {
+	Validator       string    `json:"validator"`
+	CUSIP           string    `json:"cusip"`
+	Contract        string    `json:"contract"`
+	ContractVersion int       `json:"contractVersion"`
+	Bank            string    `json:"bank"`
+	Amount          Money     `json:"amount"`
+	DeliveredAt     time.Time `json:"deliveredAt"`
+	TxID            string    `json:"txId"`
+}
+
+// CommissionStatement totals what a validator accrued over a period.
+type CommissionStatement struct {
+	Validator string            `json:"validator"`
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Accrued   Balances          `json:"accrued"`
+	Entries   []CommissionEntry `json:"entries"`
+}
+
+// commissionSchedule returns the contract's schedule. Contracts without one
+// pay their flat COMMISSION to the issuer.
+func (c BANKCONTRACT) commissionSchedule() CommissionSchedule {
+	if c.COMMISSIONSCHEDULE != nil {
+		return *c.COMMISSIONSCHEDULE
+	}
+	amount := c.COMMISSION
+	return CommissionSchedule{Type: CommissionFlat, Amount: &amount}
+}
+
+func percentRat(r Rate) *big.Rat {
+	return new(big.Rat).Quo(r.Rat(), big.NewRat(100, 1))
+}
+
+// validate checks and normalises a schedule for a contract with the given
+// validators.
+func (s *CommissionSchedule) validate(validators []string) error {
+	s.Type = strings.ToUpper(s.Type)
+	switch s.Type {
+	case CommissionFlat:
+		if s.Amount == nil || s.Amount.Sign() < 0 {
+			return errors.New("A flat commission needs an amount that is not negative")
+		}
+	case CommissionPercentage:
+		if s.Rate == nil || s.Rate.Rat().Sign() < 0 || s.Rate.Rat().Cmp(big.NewRat(100, 1)) > 0 {
+			return errors.New("A percentage commission needs a rate between 0 and 100")
+		}
+		for _, limit := range []*Money{s.Minimum, s.Maximum} {
+			if limit != nil && limit.Sign() < 0 {
+				return errors.New("Commission limits must not be negative")
+			}
+		}
+		if s.Minimum != nil && s.Maximum != nil {
+			less, err := s.Maximum.LessThan(*s.Minimum)
+			if err != nil {
+				return err
+			}
+			if less {
+				return errors.New("Commission maximum is less than its minimum")
+			}
+		}
+	case CommissionTiered:
+		if len(s.Tiers) == 0 {
+			return errors.New("A tiered commission needs at least one tier")
+		}
+		for i, tier := range s.Tiers {
+			if tier.Amount.Sign() < 0 {
+				return errors.New("Commission tiers must not be negative")
+			}
+			if err := tier.Amount.sameCurrency(s.Tiers[0].Amount); err != nil {
+				return err
+			}
+			last := i == len(s.Tiers)-1
+			if (!last && tier.UpTo < 1) || tier.UpTo < 0 || (i > 0 && tier.UpTo != 0 && tier.UpTo <= s.Tiers[i-1].UpTo) {
+				return errors.New("Commission tiers must rise in monthly volume, only the last having no upper bound")
+			}
+		}
+	default:
+		return errors.New("Unknown commission schedule type " + s.Type)
+	}
+
+	total := new(big.Rat)
+	seen := make(map[string]bool)
+	for _, share := range s.Split {
+		if indexOf(validators, share.Validator) < 0 {
+			return errors.New(share.Validator + " is not a validator on the contract and cannot share its commission")
+		}
+		if seen[share.Validator] {
+			return errors.New("Validator " + share.Validator + " is listed more than once in the commission split")
+		}
+		seen[share.Validator] = true
+		if share.Share.Rat().Sign() <= 0 {
+			return errors.New("Commission shares must be positive")
+		}
+		total.Add(total, share.Share.Rat())
+	}
+	if total.Cmp(big.NewRat(100, 1)) > 0 {
+		return errors.New("Commission shares add up to more than 100 percent")
+	}
+	return nil
+}
+
+// checkRecord fails if the commission on a record could not be worked out
+// when it is delivered.
+func (s CommissionSchedule) checkRecord(record KYCRecord) error {
+	if record.OnboardingValue != nil && record.OnboardingValue.Sign() < 0 {
+		return errors.New("Onboarding value must not be negative")
+	}
+	if s.Type == CommissionPercentage && record.OnboardingValue == nil {
+		return errors.New("Bank Contract " + record.Contract + " charges a percentage commission and needs the onboarding value")
+	}
+	return nil
+}
+
+// commissionDue works out the commission for delivering a record under the
+// contract, staging the contract's monthly volume in batch.
+func commissionDue(stub StateStub, batch *writeBatch, bankcontract BANKCONTRACT, record KYCRecord, now time.Time) (Money, error) {
+	volumeKey, err := createCompositeKey(commissionVolumeObject, bankcontract.CONTRACTID, now.UTC().Format("2006-01"))
+	if err != nil {
+		return Money{}, err
+	}
+	volumeBytes, err := stub.GetState(volumeKey)
+	if err != nil {
+		return Money{}, errors.New("Error retrieving commission volume of " + bankcontract.CONTRACTID)
+	}
+	volume := 0
+	if volumeBytes != nil {
+		volume, err = strconv.Atoi(string(volumeBytes))
+		if err != nil {
+			return Money{}, errors.New("Invalid commission volume of " + bankcontract.CONTRACTID)
+		}
+	}
+	volume++
+	err = batch.put(volumeKey, volume, "", "", "")
+	if err != nil {
+		return Money{}, err
+	}
+
+	schedule := bankcontract.commissionSchedule()
+	var due Money
+	switch schedule.Type {
+	case CommissionFlat:
+		due = *schedule.Amount
+	case CommissionPercentage:
+		err = schedule.checkRecord(record)
+		if err != nil {
+			return Money{}, err
+		}
+		amount := new(big.Rat).Mul(record.OnboardingValue.Rat(), percentRat(*schedule.Rate))
+		due, err = moneyFromRat(amount, record.OnboardingValue.Currency)
+		if err != nil {
+			return Money{}, err
+		}
+		if schedule.Minimum != nil {
+			less, err := due.LessThan(*schedule.Minimum)
+			if err != nil {
+				return Money{}, err
+			}
+			if less {
+				due = *schedule.Minimum
+			}
+		}
+		if schedule.Maximum != nil {
+			less, err := schedule.Maximum.LessThan(due)
+			if err != nil {
+				return Money{}, err
+			}
+			if less {
+				due = *schedule.Maximum
+			}
+		}
+	case CommissionTiered:
+		tier := schedule.Tiers[len(schedule.Tiers)-1]
+		for _, t := range schedule.Tiers {
+			if t.UpTo >= volume {
+				tier = t
+				break
+			}
+		}
+		due = tier.Amount
+	default:
+		return Money{}, errors.New("Unknown commission schedule type " + schedule.Type)
+	}
+	if due.Sign() < 0 {
+		fmt.Println("Negative Bank Commission")
+		return Money{}, errors.New("Invalid commission " + due.String() + " on Bank Contract " + bankcontract.CONTRACTID)
+	}
+	return due, nil
+}
+
+// payouts splits a commission between the validators sharing it and the
+// issuer, leaving out anyone paid nothing.
+func (s CommissionSchedule) payouts(due Money, issuer string) ([]CommissionPayout, error) {
+	var payouts []CommissionPayout
+	left := due
+	total := new(big.Rat)
+	for i, share := range s.Split {
+		total.Add(total, share.Share.Rat())
+		amount, err := moneyFromRat(new(big.Rat).Mul(due.Rat(), percentRat(share.Share)), due.Currency)
+		if err != nil {
+			return nil, err
+		}
+		if i == len(s.Split)-1 && total.Cmp(big.NewRat(100, 1)) == 0 {
+			amount = left
+		}
+		left, err = left.Sub(amount)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, CommissionPayout{Payee: share.Validator, Amount: amount})
+	}
+	if left.Sign() != 0 {
+		payouts = append(payouts, CommissionPayout{Payee: issuer, Amount: left})
+	}
+	return payouts, nil
+}
+
+// accrueCommission stages the entry recording a validator's share of a
+// delivery.
+func accrueCommission(stub StateStub, batch *writeBatch, entry CommissionEntry) error {
+	key, err := createCompositeKey(commissionObject, entry.Validator, fmt.Sprintf("%020d", entry.DeliveredAt.UnixNano()), entry.CUSIP)
+	if err != nil {
+		return err
+	}
+	return batch.put(key, &entry, "", "", "")
+}
+
+// getCommissionStatement returns what each validator accrued from from up
+// to to, or just the given validator. Validators see their own earnings,
+// bank administrators what their bank's contracts paid and the network
+// admin everything.
+func getCommissionStatement(stub StateStub, args []string) ([]CommissionStatement, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting the start and end of the period in milliseconds and an optional validator")
+	}
+	from, err := msToTime(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid start of period " + args[0])
+	}
+	to, err := msToTime(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid end of period " + args[1])
+	}
+	validator := ""
+	if len(args) > 2 {
+		validator = args[2]
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	bank := ""
+	switch caller.Role {
+	case RoleAdmin:
+	case RoleBankAdmin:
+		bank = caller.Bank
+	case RoleValidator:
+		if validator != "" && validator != caller.ID {
+			fmt.Println("Caller " + caller.ID + " may not see the commission of " + validator)
+			return nil, errors.New("Caller " + caller.ID + " may not see the commission of " + validator)
+		}
+		validator = caller.ID
+	default:
+		fmt.Println("Caller " + caller.ID + " may not see commission statements")
+		return nil, errors.New("Caller " + caller.ID + " may not see commission statements")
+	}
+
+	var attributes []string
+	if validator != "" {
+		attributes = []string{validator}
+	}
+	prefix, err := createCompositeKey(commissionObject, attributes...)
+	if err != nil {
+		return nil, err
+	}
+	statements := []CommissionStatement{}
+	err = forEachInRange(stub, prefix, func(key string, value []byte) error {
+		var entry CommissionEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			fmt.Println("Error unmarshalling commission entry")
+			return errors.New("Error retrieving commission entry")
+		}
+		if entry.DeliveredAt.Before(from) || !entry.DeliveredAt.Before(to) || (bank != "" && entry.Bank != bank) {
+			return nil
+		}
+		// Entries come in validator order
+		if len(statements) == 0 || statements[len(statements)-1].Validator != entry.Validator {
+			statements = append(statements, CommissionStatement{Validator: entry.Validator, From: from, To: to, Accrued: Balances{}})
+		}
+		statement := &statements[len(statements)-1]
+		accrued, ok := statement.Accrued[entry.Amount.Currency]
+		if !ok {
+			accrued = Money{Currency: entry.Amount.Currency}
+		}
+		accrued, err = accrued.Add(entry.Amount)
+		if err != nil {
+			return err
+		}
+		statement.Accrued[entry.Amount.Currency] = accrued
+		statement.Entries = append(statement.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
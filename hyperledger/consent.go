@@ -0,0 +1,510 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Consents let a customer share a verified KYC record with a bank other
+// than the one that onboarded them. Each is kept under consentPrefix + ID
+// and indexed by customer and CUSIP; every use of a consent is logged under
+// consentAccessObject, keyed by consent and time.
+var consentPrefix = "consent:"
+
+const (
+	consentIndex        = "consent~customer~cusip~id"
+	consentAccessObject = "consentaccess~consent~time"
+)
+
+// Consent states. An active consent past its expiry reads as expired.
+const (
+	ConsentActive  = "ACTIVE"
+	ConsentRevoked = "REVOKED"
+	ConsentExpired = "EXPIRED"
+)
+
+// Consent scopes name the parts of a record a bank may read.
+const (
+	ScopeIdentity  = "identity"  // name, gender and date of birth
+	ScopeAddress   = "address"   // house, street, city, state and pin
+	ScopeContact   = "contact"   // phone, mobile and email
+	ScopeDocuments = "documents" // the documents uploaded for the record
+)
+
+var consentScopes = map[string]bool{ScopeIdentity: true, ScopeAddress: true, ScopeContact: true, ScopeDocuments: true}
+
+// Consent grants Bank access to the Scope of a customer's record for
+// Purpose until Expiry (milliseconds).
+type Consent struct {
+	ID             string     `json:"id"`
+	CUSIP          string     `json:"cusip"`
+	Customer       string     `json:"customer"`
+	OnboardingBank string     `json:"onboardingBank"`
+	Bank           string     `json:"bank"`
+	Purpose        string     `json:"purpose"`
+	Scope          []string   `json:"scope"`
+	Expiry         string     `json:"expiry"`
+	Status         string     `json:"status"`
+	GrantedBy      string     `json:"grantedBy"`
+	GrantedAt      time.Time  `json:"grantedAt"`
+	RevokedBy      string     `json:"revokedBy,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	RevokeReason   string     `json:"revokeReason,omitempty"`
+	// Accesses is filled in by GetConsents and not stored with the consent
+	Accesses []ConsentAccess `json:"accesses,omitempty"`
+}
+
+// ConsentAccess logs one read of a record under a consent.
+type ConsentAccess struct {
+	Consent    string    `json:"consent"`
+	CUSIP      string    `json:"cusip"`
+	Bank       string    `json:"bank"`
+	AccessedBy string    `json:"accessedBy"`
+	Purpose    string    `json:"purpose"`
+	AccessedAt time.Time `json:"accessedAt"`
+	TxID       string    `json:"txId"`
+}
+
+// status is the consent's state at a time.
+func (c Consent) status(at time.Time) string {
+	if c.Status != ConsentActive {
+		return c.Status
+	}
+	expiry, err := msToTime(c.Expiry)
+	if err != nil || !at.Before(expiry) {
+		return ConsentExpired
+	}
+	return ConsentActive
+}
+
+func (c Consent) covers(scope string) bool {
+	return indexOf(c.Scope, scope) >= 0
+}
+
+func getConsent(stub StateStub, id string) (Consent, error) {
+	var consent Consent
+	consentBytes, err := stub.GetState(consentPrefix + id)
+	if err != nil || consentBytes == nil {
+		return consent, errors.New("Consent not found " + id)
+	}
+	err = json.Unmarshal(consentBytes, &consent)
+	if err != nil {
+		fmt.Println("Error unmarshalling consent " + id)
+		return consent, errors.New("Error unmarshalling consent " + id)
+	}
+	return consent, nil
+}
+
+func putConsent(stub StateStub, consent Consent) error {
+	consent.Accesses = nil
+	consentBytes, err := json.Marshal(&consent)
+	if err != nil {
+		fmt.Println("Error marshalling consent " + consent.ID)
+		return errors.New("Error saving consent " + consent.ID)
+	}
+	err = stub.PutState(consentPrefix+consent.ID, consentBytes)
+	if err != nil {
+		fmt.Println("Error saving consent " + consent.ID)
+		return errors.New("Error saving consent " + consent.ID)
+	}
+	return nil
+}
+
+// grantConsent lets another bank read a verified record. The customer
+// grants it, or the bank that onboarded them on their behalf.
+func (t *SimpleChaincode) grantConsent(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			json
+			{
+				"cusip": "company1000ADM",
+				"bank": "bank2",
+				"purpose": "string",
+				"scope": ["identity", "address", "contact", "documents"],
+				"expiry": "1467331200000" (milliseconds)
+			}
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting consent")
+	}
+	var consent Consent
+	err := json.Unmarshal([]byte(args[0]), &consent)
+	if err != nil {
+		fmt.Println("Error unmarshalling consent")
+		return nil, errors.New("Invalid consent")
+	}
+	record, err := getCustomerRecord(stub, consent.CUSIP)
+	if err != nil {
+		return nil, err
+	}
+	bankcontract, err := contractForRecord(stub, record)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := requireCaller(stub, record.Issuer, bankcontract.BANKID)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(consent.Purpose) == "" {
+		return nil, errors.New("A consent needs a purpose")
+	}
+	if len(consent.Scope) == 0 {
+		return nil, errors.New("A consent needs a scope")
+	}
+	for _, scope := range consent.Scope {
+		if !consentScopes[scope] {
+			return nil, errors.New("Unknown consent scope " + scope)
+		}
+	}
+	if consent.Bank == bankcontract.BANKID {
+		return nil, errors.New("Bank " + consent.Bank + " onboarded " + record.CUSIP + " and needs no consent")
+	}
+	_, err = GetCompany(consent.Bank, stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if !record.validAt(now) {
+		fmt.Println("KYC record " + record.CUSIP + " is not verified")
+		return nil, errors.New("Only verified KYC records can be shared; " + record.CUSIP + " is " + string(record.Status))
+	}
+	expiry, err := msToTime(consent.Expiry)
+	if err != nil {
+		return nil, errors.New("Invalid consent expiry " + consent.Expiry + ", expecting milliseconds since the epoch")
+	}
+	if !expiry.After(now) {
+		return nil, errors.New("Consent expiry " + consent.Expiry + " has already passed")
+	}
+
+	// One live consent per bank and purpose; revoke it to change the terms
+	consents, err := getConsents(stub, record.Issuer, record.CUSIP)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range consents {
+		if existing.Bank == consent.Bank && existing.Purpose == consent.Purpose && existing.status(now) == ConsentActive {
+			return nil, errors.New("Consent " + existing.ID + " already grants " + consent.Bank + " access for " + consent.Purpose)
+		}
+	}
+
+	consent.ID = stub.GetTxID()
+	consent.Customer = record.Issuer
+	consent.OnboardingBank = bankcontract.BANKID
+	consent.Status = ConsentActive
+	consent.GrantedBy = caller.ID
+	consent.GrantedAt = now
+	consent.RevokedBy = ""
+	consent.RevokedAt = nil
+	consent.RevokeReason = ""
+	if existing, _ := stub.GetState(consentPrefix + consent.ID); existing != nil {
+		return nil, errors.New("Consent " + consent.ID + " already exists")
+	}
+	err = putConsent(stub, consent)
+	if err != nil {
+		return nil, err
+	}
+	err = putIndexEntry(stub, consentIndex, consent.Customer, consent.CUSIP, consentPrefix+consent.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = setEvent(stub, Event{Type: EventConsentGranted, CUSIP: consent.CUSIP, Bank: consent.Bank, Issuer: consent.Customer, ConsentID: consent.ID})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Granted consent " + consent.ID + " to " + consent.Bank)
+	return []byte(consent.ID), nil
+}
+
+// revokeConsent ends a consent early. The customer, the onboarding bank or
+// the bank holding the consent may revoke it.
+func (t *SimpleChaincode) revokeConsent(stub StateStub, args []string) ([]byte, error) {
+	/*		0		1
+			consent ID	reason (optional)
+	*/
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting consent ID and an optional reason")
+	}
+	consent, err := getConsent(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	caller, err := requireCaller(stub, consent.Customer, consent.OnboardingBank, consent.Bank)
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if status := consent.status(now); status != ConsentActive {
+		return nil, errors.New("Consent " + consent.ID + " is " + strings.ToLower(status))
+	}
+	consent.Status = ConsentRevoked
+	consent.RevokedBy = caller.ID
+	consent.RevokedAt = &now
+	if len(args) > 1 {
+		consent.RevokeReason = args[1]
+	}
+	err = putConsent(stub, consent)
+	if err != nil {
+		return nil, err
+	}
+	err = setEvent(stub, Event{Type: EventConsentRevoked, CUSIP: consent.CUSIP, Bank: consent.Bank, Issuer: consent.Customer, ConsentID: consent.ID})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Revoked consent " + consent.ID)
+	return nil, nil
+}
+
+// heldConsent returns an active consent held by the caller's bank and the
+// still verified record it shares.
+func heldConsent(stub StateStub, caller Caller, id string, now time.Time) (Consent, KYCRecord, error) {
+	var record KYCRecord
+	consent, err := getConsent(stub, id)
+	if err != nil {
+		return consent, record, err
+	}
+	if !caller.isBankAdmin(consent.Bank) {
+		fmt.Println("Caller " + caller.ID + " does not hold consent " + consent.ID)
+		return consent, record, errors.New("Caller " + caller.ID + " does not hold consent " + consent.ID)
+	}
+	if status := consent.status(now); status != ConsentActive {
+		return consent, record, errors.New("Consent " + consent.ID + " is " + strings.ToLower(status))
+	}
+	record, err = getCustomerRecord(stub, consent.CUSIP)
+	if err != nil {
+		return consent, record, err
+	}
+	if !record.validAt(now) {
+		return consent, record, errors.New("KYC record " + record.CUSIP + " is no longer verified")
+	}
+	return consent, record, nil
+}
+
+// sharedRecord returns the parts of the record the consent covers.
+func (c Consent) sharedRecord(record KYCRecord) KYCRecord {
+	shared := redactPII(record)
+	if c.covers(ScopeIdentity) {
+		shared.Name, shared.Gender, shared.DateOfBirth, shared.LegacyAge = record.Name, record.Gender, record.DateOfBirth, record.LegacyAge
+	}
+	if c.covers(ScopeAddress) {
+		shared.House, shared.Street, shared.City, shared.State, shared.Pin = record.House, record.Street, record.City, record.State, record.Pin
+	}
+	if c.covers(ScopeContact) {
+		shared.Phone, shared.Mobile, shared.Email = record.Phone, record.Mobile, record.Email
+	}
+	if c.covers(ScopeDocuments) {
+		shared.Documents = record.Documents
+	}
+	// Encrypted fields stay encrypted; the bank gets the key from the
+	// onboarding bank
+	shared.Encryption = record.Encryption
+	return shared
+}
+
+// accessKYC logs a use of a consent by the bank holding it. Invoke results
+// do not reach the client, so the bank then reads the shared record with
+// the GetSharedKYC query.
+func (t *SimpleChaincode) accessKYC(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			consent ID
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting consent ID")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	consent, record, err := heldConsent(stub, caller, args[0], now)
+	if err != nil {
+		return nil, err
+	}
+
+	access := ConsentAccess{Consent: consent.ID, CUSIP: consent.CUSIP, Bank: consent.Bank, AccessedBy: caller.ID,
+		Purpose: consent.Purpose, AccessedAt: now, TxID: stub.GetTxID()}
+	key, err := createCompositeKey(consentAccessObject, consent.ID, fmt.Sprintf("%020d", now.UnixNano())+"-"+access.TxID)
+	if err != nil {
+		return nil, err
+	}
+	accessBytes, err := json.Marshal(&access)
+	if err != nil {
+		fmt.Println("Error marshalling consent access")
+		return nil, errors.New("Error logging access to " + record.CUSIP)
+	}
+	err = stub.PutState(key, accessBytes)
+	if err != nil {
+		fmt.Println("Error logging consent access")
+		return nil, errors.New("Error logging access to " + record.CUSIP)
+	}
+	err = setEvent(stub, Event{Type: EventKYCAccessed, CUSIP: consent.CUSIP, Bank: consent.Bank, Issuer: consent.Customer, ConsentID: consent.ID})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Logged access to " + record.CUSIP + " under consent " + consent.ID)
+	return nil, nil
+}
+
+// readSharedKYC returns the parts of a record shared under a consent to an
+// administrator of the bank holding it, while the consent is active and
+// once the caller has logged an access to it with accessKYC.
+func readSharedKYC(stub StateStub, args []string) (KYCRecord, error) {
+	if len(args) != 1 {
+		return KYCRecord{}, errors.New("Incorrect number of arguments. Expecting consent ID")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return KYCRecord{}, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return KYCRecord{}, err
+	}
+	consent, record, err := heldConsent(stub, caller, args[0], now)
+	if err != nil {
+		return KYCRecord{}, err
+	}
+	logged, err := accessLogged(stub, consent, caller)
+	if err != nil {
+		return KYCRecord{}, err
+	}
+	if !logged {
+		fmt.Println("Caller " + caller.ID + " has not logged an access under consent " + consent.ID)
+		return KYCRecord{}, errors.New("Log an access with accessKYC before reading consent " + consent.ID)
+	}
+	return consent.sharedRecord(record), nil
+}
+
+// accessLogged reports whether the caller has logged an access under the
+// consent.
+func accessLogged(stub StateStub, consent Consent, caller Caller) (bool, error) {
+	prefix, err := createCompositeKey(consentAccessObject, consent.ID)
+	if err != nil {
+		return false, err
+	}
+	logged := false
+	err = forEachInRange(stub, prefix, func(key string, value []byte) error {
+		var access ConsentAccess
+		err := json.Unmarshal(value, &access)
+		if err != nil {
+			fmt.Println("Error unmarshalling consent access")
+			return errors.New("Error retrieving access log of consent " + consent.ID)
+		}
+		if access.AccessedBy == caller.ID {
+			logged = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return logged, nil
+}
+
+// getConsents returns the consents a customer granted, optionally for one
+// record.
+func getConsents(stub StateStub, customer string, cusip string) ([]Consent, error) {
+	attributes := []string{customer}
+	if cusip != "" {
+		attributes = append(attributes, cusip)
+	}
+	consents := []Consent{}
+	err := forEachIndexed(stub, consentIndex, attributes, func(key string) error {
+		consent, err := getConsent(stub, strings.TrimPrefix(key, consentPrefix))
+		if err != nil {
+			return err
+		}
+		consents = append(consents, consent)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+// readConsents lists a customer's consents with their access logs. The
+// customer and the network admin see them all, and a bank's administrators
+// those their bank granted or holds.
+func readConsents(stub StateStub, args []string) ([]Consent, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting the customer and an optional CUSIP")
+	}
+	cusip := ""
+	if len(args) > 1 {
+		cusip = args[1]
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller.ID != args[0] && caller.Role != RoleAdmin && caller.Role != RoleBankAdmin {
+		fmt.Println("Caller " + caller.ID + " may not see the consents of " + args[0])
+		return nil, errors.New("Caller " + caller.ID + " may not see the consents of " + args[0])
+	}
+	consents, err := getConsents(stub, args[0], cusip)
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+
+	readable := []Consent{}
+	for _, consent := range consents {
+		if caller.ID != consent.Customer && caller.Role != RoleAdmin &&
+			!caller.isBankAdmin(consent.Bank) && !caller.isBankAdmin(consent.OnboardingBank) {
+			continue
+		}
+		consent.Status = consent.status(now)
+		prefix, err := createCompositeKey(consentAccessObject, consent.ID)
+		if err != nil {
+			return nil, err
+		}
+		err = forEachInRange(stub, prefix, func(key string, value []byte) error {
+			var access ConsentAccess
+			err := json.Unmarshal(value, &access)
+			if err != nil {
+				fmt.Println("Error unmarshalling consent access")
+				return errors.New("Error retrieving access log of consent " + consent.ID)
+			}
+			consent.Accesses = append(consent.Accesses, access)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		readable = append(readable, consent)
+	}
+	return readable, nil
+}
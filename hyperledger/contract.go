@@ -0,0 +1,478 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bank contract states.
+const (
+	ContractActive     = "ACTIVE"
+	ContractSuspended  = "SUSPENDED"
+	ContractTerminated = "TERMINATED"
+)
+
+// Every version of a contract is kept under contractVersionObject, keyed by
+// contract ID and zero-padded version. The contract's own key holds the
+// latest version, which may not be in effect yet.
+const contractVersionObject = "contractversion~id~version"
+
+func contractVersionKey(contractID string, version int) (string, error) {
+	return createCompositeKey(contractVersionObject, contractID, fmt.Sprintf("%06d", version))
+}
+
+// Contract IDs are the bank ID, a three digit sequence number and "C"; a
+// bank's first contract is BANKID + "000C". The next sequence number of each
+// bank is kept under contractSequencePrefix + BANKID.
+var contractSequencePrefix = "contractseq:"
+
+const maxContractsPerBank = 1000
+
+// nextContractID allocates the next unused contract ID of a bank.
+func nextContractID(stub StateStub, bankID string) (string, error) {
+	sequenceBytes, err := stub.GetState(contractSequencePrefix + bankID)
+	if err != nil {
+		fmt.Println("Error retrieving contract sequence of " + bankID)
+		return "", errors.New("Error retrieving contract sequence of " + bankID)
+	}
+	sequence := 0
+	if sequenceBytes != nil {
+		sequence, err = strconv.Atoi(string(sequenceBytes))
+		if err != nil {
+			return "", errors.New("Invalid contract sequence of " + bankID)
+		}
+	}
+
+	// Contracts issued before IDs were allocated hold the first IDs
+	for ; sequence < maxContractsPerBank; sequence++ {
+		contractID := bankID + fmt.Sprintf("%03d", sequence) + "C"
+		existing, err := stub.GetState(contractID)
+		if err != nil {
+			return "", errors.New("Error retrieving contract " + contractID)
+		}
+		if existing != nil {
+			continue
+		}
+		err = stub.PutState(contractSequencePrefix+bankID, []byte(strconv.Itoa(sequence+1)))
+		if err != nil {
+			fmt.Println("Error saving contract sequence of " + bankID)
+			return "", errors.New("Error saving contract sequence of " + bankID)
+		}
+		return contractID, nil
+	}
+	return "", errors.New("Bank " + bankID + " has issued the most contracts allowed")
+}
+
+// checkDuplicateContract fails if the bank already holds a contract that
+// has not been terminated under the same name, which is most likely the
+// same submission made twice. Unnamed contracts are never duplicates: a
+// bank may hold several contracts on the same terms.
+func checkDuplicateContract(stub StateStub, bankcontract BANKCONTRACT) error {
+	name := strings.TrimSpace(bankcontract.CONTRACTNAME)
+	if name == "" {
+		return nil
+	}
+	contracts, err := getContractsForBank(stub, bankcontract.BANKID)
+	if err != nil {
+		return err
+	}
+	for _, existing := range contracts {
+		if existing.status() == ContractTerminated {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(existing.CONTRACTNAME), name) {
+			fmt.Println("Duplicate of Bank Contract " + existing.CONTRACTID)
+			return errors.New("Bank Contract " + existing.CONTRACTID + " is already named " + name + "; amend it rather than issuing it again")
+		}
+	}
+	return nil
+}
+
+// getContractsForBank returns the latest version of each of a bank's
+// contracts.
+func getContractsForBank(stub StateStub, bankID string) ([]BANKCONTRACT, error) {
+	contracts := []BANKCONTRACT{}
+	err := forEachIndexed(stub, contractIndex, []string{bankID}, func(contractID string) error {
+		bankcontract, err := getBankContract(stub, contractID)
+		if err != nil {
+			return err
+		}
+		contracts = append(contracts, bankcontract)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return contracts, nil
+}
+
+// version is the contract's version number; contracts written before
+// versions were kept are version 1.
+func (c BANKCONTRACT) version() int {
+	if c.VERSION == 0 {
+		return 1
+	}
+	return c.VERSION
+}
+
+func (c BANKCONTRACT) status() string {
+	if c.STATUS == "" {
+		return ContractActive
+	}
+	return c.STATUS
+}
+
+// effectiveFrom is when the version takes effect; versions without an
+// effective date have always been in effect.
+func (c BANKCONTRACT) effectiveFrom() time.Time {
+	if c.EFFECTIVEDATE == "" {
+		return time.Time{}
+	}
+	effective, err := msToTime(c.EFFECTIVEDATE)
+	if err != nil {
+		return time.Time{}
+	}
+	return effective
+}
+
+// validate checks and normalises the terms of a contract.
+func (c *BANKCONTRACT) validate() error {
+	c.normaliseValidators()
+	err := c.approvalPolicy().validate(c.VALIDATORS)
+	if err != nil {
+		return err
+	}
+	if c.COMMISSION.Sign() < 0 {
+		return errors.New("Commission must not be negative")
+	}
+	if c.COMMISSION.Currency == "" {
+		// contracts priced by a schedule alone
+		c.COMMISSION.Currency = defaultCurrency
+	}
+	if c.SETTLEMENTCURRENCY != "" {
+		c.SETTLEMENTCURRENCY = strings.ToUpper(c.SETTLEMENTCURRENCY)
+		_, err = currencyExponent(c.SETTLEMENTCURRENCY)
+		if err != nil {
+			return err
+		}
+	}
+	if c.ESCROWHOURS < 0 {
+		return errors.New("Escrow period must not be negative")
+	}
+	if c.VALIDITYPOLICY != nil {
+		err = c.VALIDITYPOLICY.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.COMMISSIONSCHEDULE != nil {
+		err = c.COMMISSIONSCHEDULE.validate(c.validatorList())
+		if err != nil {
+			return err
+		}
+		// Escrow holds the commission for the issuer alone
+		if len(c.COMMISSIONSCHEDULE.Split) > 0 && c.ESCROWHOURS > 0 {
+			return errors.New("A commission shared with validators cannot be held in escrow")
+		}
+	}
+	if c.PIIKEYFINGERPRINT != "" && !validKeyFingerprint(c.PIIKEYFINGERPRINT) {
+		return errors.New("PII key fingerprint must be a hex SHA-256 digest")
+	}
+	return nil
+}
+
+// saveContractVersion writes a new version of a contract, both as the
+// contract's latest version and under its version number.
+func saveContractVersion(stub StateStub, bankcontract BANKCONTRACT, action string) error {
+	contractBytes, err := json.Marshal(&bankcontract)
+	if err != nil {
+		fmt.Println("Error marshalling Bank Contract")
+		return errors.New("Error saving Bank Contract " + bankcontract.CONTRACTID)
+	}
+	key, err := contractVersionKey(bankcontract.CONTRACTID, bankcontract.version())
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, contractBytes)
+	if err != nil {
+		fmt.Println("Error saving Bank Contract version")
+		return errors.New("Error saving Bank Contract " + bankcontract.CONTRACTID)
+	}
+	err = putWithHistory(stub, bankcontract.CONTRACTID, contractBytes, HistoryContract, bankcontract.CONTRACTID, action)
+	if err != nil {
+		fmt.Println("Error saving Bank Contract")
+		return errors.New("Error saving Bank Contract " + bankcontract.CONTRACTID)
+	}
+	return nil
+}
+
+// getBankContractVersion returns one version of a contract.
+func getBankContractVersion(stub StateStub, contractID string, version int) (BANKCONTRACT, error) {
+	var bankcontract BANKCONTRACT
+	key, err := contractVersionKey(contractID, version)
+	if err != nil {
+		return bankcontract, err
+	}
+	contractBytes, err := stub.GetState(key)
+	if err != nil {
+		return bankcontract, errors.New("Error retrieving contract " + contractID)
+	}
+	if contractBytes == nil {
+		// contracts written before versions were kept only have their
+		// latest version
+		bankcontract, err = getBankContract(stub, contractID)
+		if err == nil && bankcontract.version() == version {
+			return bankcontract, nil
+		}
+		return BANKCONTRACT{}, errors.New("Bank Contract " + contractID + " has no version " + strconv.Itoa(version))
+	}
+	err = json.Unmarshal(contractBytes, &bankcontract)
+	if err != nil {
+		return bankcontract, errors.New("Error retrieving Bank Contract " + contractID)
+	}
+	return bankcontract, nil
+}
+
+// contractInEffect returns the version of a contract in effect at a time.
+func contractInEffect(stub StateStub, contractID string, at time.Time) (BANKCONTRACT, error) {
+	bankcontract, err := getBankContract(stub, contractID)
+	if err != nil {
+		return bankcontract, err
+	}
+	for version := bankcontract.version(); version >= 1; version-- {
+		if version != bankcontract.version() {
+			bankcontract, err = getBankContractVersion(stub, contractID, version)
+			if err != nil {
+				return bankcontract, err
+			}
+		}
+		if !bankcontract.effectiveFrom().After(at) {
+			return bankcontract, nil
+		}
+	}
+	return BANKCONTRACT{}, errors.New("Bank Contract " + contractID + " is not in effect yet")
+}
+
+// contractForRecord returns the version of the contract a KYC record was
+// onboarded under. Records onboarded before versions were kept follow the
+// latest version.
+func contractForRecord(stub StateStub, record KYCRecord) (BANKCONTRACT, error) {
+	if record.ContractVersion == 0 {
+		return getBankContract(stub, record.Contract)
+	}
+	return getBankContractVersion(stub, record.Contract, record.ContractVersion)
+}
+
+// effectiveDate checks a requested effective date in milliseconds, which
+// defaults to the transaction time and may not be earlier than it or than
+// notBefore.
+func effectiveDate(stub StateStub, requested string, notBefore time.Time) (string, error) {
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+	if requested == "" {
+		requested = strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	}
+	effective, err := msToTime(requested)
+	if err != nil {
+		return "", errors.New("Invalid effective date " + requested + ", expecting milliseconds since the epoch")
+	}
+	if effective.Before(now.Truncate(time.Millisecond)) || effective.Before(notBefore) {
+		return "", errors.New("Effective date " + requested + " is earlier than the contract allows")
+	}
+	return requested, nil
+}
+
+// ContractChange names the contract a lifecycle invoke applies to and when
+// the change takes effect, in milliseconds. Amendments carry the new terms
+// alongside, in the layout of BANKCONTRACT.
+type ContractChange struct {
+	Contract      string `json:"contract"`
+	EffectiveDate string `json:"effectiveDate"`
+	Reason        string `json:"reason"`
+}
+
+// nextContractVersion starts the next version of a contract, which only an
+// administrator of its bank may change and which can no longer change once
+// terminated.
+func nextContractVersion(stub StateStub, change ContractChange) (BANKCONTRACT, error) {
+	latest, err := getBankContract(stub, change.Contract)
+	if err != nil {
+		return latest, err
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return latest, err
+	}
+	if !caller.isBankAdmin(latest.BANKID) {
+		fmt.Println("Caller " + caller.ID + " is not an admin of " + latest.BANKID)
+		return latest, errors.New("Caller " + caller.ID + " is not an admin of " + latest.BANKID)
+	}
+	if latest.status() == ContractTerminated {
+		return latest, errors.New("Bank Contract " + latest.CONTRACTID + " has been terminated")
+	}
+
+	next := latest
+	next.VERSION = latest.version() + 1
+	next.STATUS = latest.status()
+	next.EFFECTIVEDATE, err = effectiveDate(stub, change.EffectiveDate, latest.effectiveFrom())
+	if err != nil {
+		return latest, err
+	}
+	return next, nil
+}
+
+// amendBankContract issues a new version of a contract with changed terms.
+// Records already onboarded keep the terms of the version they were
+// onboarded under.
+func (t *SimpleChaincode) amendBankContract(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			json
+			{
+				"contract": "bank1000C",
+				"effectiveDate": "1456161763790", (optional, milliseconds; now if omitted)
+				"bCommission": "75.00 INR", (and any other terms to change)
+				"bValidators": "validator1,validator3"
+			}
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting contract amendment")
+	}
+	var change ContractChange
+	var terms map[string]json.RawMessage
+	err := json.Unmarshal([]byte(args[0]), &change)
+	if err == nil {
+		err = json.Unmarshal([]byte(args[0]), &terms)
+	}
+	if err != nil {
+		fmt.Println("Error unmarshalling contract amendment")
+		return nil, errors.New("Invalid contract amendment")
+	}
+	next, err := nextContractVersion(stub, change)
+	if err != nil {
+		return nil, err
+	}
+
+	// Overlay the new terms; the identity and state of the contract are
+	// not terms and stay as they were
+	amended := next
+	if _, ok := terms["bValidators"]; ok {
+		if _, ok := terms["validators"]; !ok {
+			amended.VALIDATORS = nil
+		}
+	}
+	if _, ok := terms["commissionSchedule"]; ok {
+		amended.COMMISSIONSCHEDULE = nil
+	}
+	if _, ok := terms["validityPolicy"]; ok {
+		amended.VALIDITYPOLICY = nil
+	}
+	err = json.Unmarshal([]byte(args[0]), &amended)
+	if err != nil {
+		return nil, errors.New("Invalid contract amendment")
+	}
+	amended.CONTRACTID = next.CONTRACTID
+	amended.CONTRACTNAME = next.CONTRACTNAME
+	amended.BANKID = next.BANKID
+	amended.VERSION = next.VERSION
+	amended.STATUS = next.STATUS
+	amended.STATUSREASON = next.STATUSREASON
+	amended.EFFECTIVEDATE = next.EFFECTIVEDATE
+	err = amended.validate()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	err = saveContractVersion(stub, amended, "amendBankContract")
+	if err != nil {
+		return nil, err
+	}
+	err = setEvent(stub, Event{Type: EventContractAmended, Contract: amended.CONTRACTID, Bank: amended.BANKID, Version: amended.VERSION})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Amended Bank Contract " + amended.CONTRACTID + " to version " + strconv.Itoa(amended.VERSION))
+	return nil, nil
+}
+
+// setContractStatus issues a new version of a contract in a new state.
+func setContractStatus(stub StateStub, args []string, status string, allowedFrom string, action string, eventType string) ([]byte, error) {
+	/*		0
+			json
+			{
+				"contract": "bank1000C",
+				"reason": "string",
+				"effectiveDate": "1456161763790" (optional, milliseconds; now if omitted)
+			}
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting contract change")
+	}
+	var change ContractChange
+	err := json.Unmarshal([]byte(args[0]), &change)
+	if err != nil {
+		fmt.Println("Error unmarshalling contract change")
+		return nil, errors.New("Invalid contract change")
+	}
+	next, err := nextContractVersion(stub, change)
+	if err != nil {
+		return nil, err
+	}
+	if allowedFrom != "" && next.STATUS != allowedFrom {
+		return nil, errors.New("Bank Contract " + next.CONTRACTID + " is " + strings.ToLower(next.STATUS))
+	}
+	next.STATUS = status
+	next.STATUSREASON = change.Reason
+
+	err = saveContractVersion(stub, next, action)
+	if err != nil {
+		return nil, err
+	}
+	err = setEvent(stub, Event{Type: eventType, Contract: next.CONTRACTID, Bank: next.BANKID, Version: next.VERSION})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Bank Contract " + next.CONTRACTID + " is " + strings.ToLower(status) + " from version " + strconv.Itoa(next.VERSION))
+	return nil, nil
+}
+
+// suspendBankContract stops new KYC records being onboarded under an active
+// contract; records already onboarded carry on.
+func (t *SimpleChaincode) suspendBankContract(stub StateStub, args []string) ([]byte, error) {
+	return setContractStatus(stub, args, ContractSuspended, ContractActive, "suspendBankContract", EventContractSuspended)
+}
+
+// reinstateBankContract lifts a suspension.
+func (t *SimpleChaincode) reinstateBankContract(stub StateStub, args []string) ([]byte, error) {
+	return setContractStatus(stub, args, ContractActive, ContractSuspended, "reinstateBankContract", EventContractReinstated)
+}
+
+// terminateBankContract ends a contract for good: no records can be
+// onboarded under it and it can no longer be changed.
+func (t *SimpleChaincode) terminateBankContract(stub StateStub, args []string) ([]byte, error) {
+	return setContractStatus(stub, args, ContractTerminated, "", "terminateBankContract", EventContractTerminated)
+}
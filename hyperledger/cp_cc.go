@@ -31,35 +31,16 @@
 
 	var cpPrefix = "cp:"
 	var accountPrefix = "acct:"
+	var docPrefix = "doc:"
 	var accountsKey = "accounts"
 
 	var recentLeapYear = 2016
 
 	// SimpleChaincode example simple Chaincode implementation
 	type SimpleChaincode struct {
-	}
-
-	func generateCUSIPSuffix(issueDate string, age string) (string, error) {
-
-		t, err := msToTime(issueDate)
-		if err != nil {
-			return "", err
-		}
-		
-		days, err := strconv.Atoi(age)
-		if err != nil {
-			// handle error
-			fmt.Println(err)
-			return "", err
-		}
-
-		maturityDate := t.AddDate(0, 0, days)
-		month := int(maturityDate.Month())
-		day := maturityDate.Day()
-
-		suffix := seventhDigit[month] + eigthDigit[day]
-		return suffix, nil
-
+		// docStore receives document content uploaded inline; nil means
+		// clients store content off-chain themselves and submit its anchor
+		docStore DocumentStore
 	}
 
 	const (
@@ -77,34 +58,36 @@
 			(msInt%millisPerSecond)*nanosPerMillisecond), nil
 	}
 
-	type CP struct {
-		CUSIP     string  `json:"cusip"`
-		Contract  string  `json:"contract"`
-		Name      string  `json:"ticker"`
-		Gender    string  `json:"par"`
-		Age       string  `json:"qty"`
-		City  	  string  `json:"discount"`
-		State	  string  `json:"maturity"`
-		Phone	  string  `json:"phone"`
-		House	  string  `json:"house"`
-		Street	  string  `json:"street"`
-		Pin	  string  `json:"pin"`
-		Email	  string  `json:"email"`
-		Mobile	  string  `json:"mobile"`
-		Fmrdata	  string  `json:"fmrdata"`
-		Owner     string  `json:"owner"`
-		Filename  string  `json:"filename"`
-		Issuer    string  `json:"issuer"`
-		IssueDate string  `json:"issueDate"`
-		DOCUMENTS	[]DOCUMENT
-	}
-	
 	type BANKCONTRACT struct {
 		CONTRACTID		 string  `json:"conractid"`
+		// CONTRACTNAME tells a bank's contracts apart, such as "retail"
+		// and "corporate"; it cannot be amended
+		CONTRACTNAME     string  `json:"contractName,omitempty"`
 		BANKID   		 string  `json:"bID"`
 		BANKNAME     	 string  `json:"bName"`
 		BANKVALIDATORS   string  `json:"bValidators"`
-		COMMISSION       string  `json:"bCommission"`
+		COMMISSION       Money   `json:"bCommission"`
+		// COMMISSIONSCHEDULE, when set, replaces the flat COMMISSION
+		COMMISSIONSCHEDULE *CommissionSchedule `json:"commissionSchedule,omitempty"`
+		// VALIDITYPOLICY, when set, limits how long verified records stay valid
+		VALIDITYPOLICY   *ValidityPolicy  `json:"validityPolicy,omitempty"`
+		// SETTLEMENTCURRENCY is what the bank pays commission in when it
+		// differs from the commission's currency
+		SETTLEMENTCURRENCY string        `json:"settlementCurrency,omitempty"`
+		VALIDATORS       []string         `json:"validators"`
+		APPROVALPOLICY   *ApprovalPolicy  `json:"approvalPolicy,omitempty"`
+		// PIIKEYFINGERPRINT is the SHA-256 of the bank's PII key; when set,
+		// customer PII onboarded under the contract is stored encrypted
+		PIIKEYFINGERPRINT string          `json:"piiKeyFingerprint,omitempty"`
+		// VERSION counts the contract's amendments and changes of STATUS,
+		// each taking effect from EFFECTIVEDATE (milliseconds)
+		VERSION          int              `json:"version,omitempty"`
+		STATUS           string           `json:"status,omitempty"`
+		STATUSREASON     string           `json:"statusReason,omitempty"`
+		EFFECTIVEDATE    string           `json:"effectiveDate,omitempty"`
+		// ESCROWHOURS, when set, holds the commission on each delivery in
+		// escrow until the bank confirms it, refunding it after that many hours
+		ESCROWHOURS      int              `json:"escrowHours,omitempty"`
 	}
 	
 	type DOCUMENT struct {
@@ -112,13 +95,18 @@
 		CUSIP     string  `json:"cusip"`
 		DOCUMENTID	string	`json:"dID"`
 		DOCUMENTTYPE	string	`json:"documents"`
-		FILE		string	`json:"myFile"`
+		HASH		string	`json:"hash"`
+		SIZE		int64	`json:"size"`
+		MIMETYPE	string	`json:"mimeType"`
+		URI		string	`json:"uri"`
+		// FILE is only set on legacy documents and on uploads to be moved off-chain
+		FILE		string	`json:"myFile,omitempty"`
 	}
 
 	type Account struct {
 		ID          string  `json:"id"`
 		Prefix      string  `json:"prefix"`
-		CashBalance float64 `json:"cashBalance"`
+		Balances    Balances `json:"balances"`
 		AssetsIds   []string `json:"assetIds"`
 	}
 
@@ -128,35 +116,48 @@
 		ToCompany   string   `json:"toCompany"`
 		Quantity    int      `json:"quantity"`
 		Discount    string   `json:"discount"`
+		// RiskTier, when set, is the validator's decision on the record's
+		// risk tier
+		RiskTier    string   `json:"riskTier,omitempty"`
 	}
 
 	func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-		// Initialize the collection of commercial paper keys
-		fmt.Println("Initializing paper keys collection")
-		var blank []string
-		blankBytes, _ := json.Marshal(&blank)
-		err := stub.PutState("PaperKeys", blankBytes)
+		return t.init(newShimStub(stub), args)
+	}
+
+	func (t *SimpleChaincode) init(stub StateStub, args []string) ([]byte, error) {
+		// Records are listed by range queries, so there are no key
+		// collections to set up. Move any left by earlier versions over.
+		err := migrateKeyCollections(stub)
 		if err != nil {
-			fmt.Println("Failed to initialize paper key collection")
-		}
-		error := stub.PutState("BankKeys", blankBytes)
-		if error != nil {
-			fmt.Println("Failed to initialize bank key collection")
+			fmt.Println("Failed to migrate key collections")
+			return nil, err
 		}
-		errs := stub.PutState("DocKeys", blankBytes)
-		if errs != nil {
-			fmt.Println("Failed to initialize document key collection")
+		// Documents are kept under docPrefix so they are only read through
+		// the document queries
+		err = migrateDocumentKeys(stub)
+		if err != nil {
+			fmt.Println("Failed to migrate document keys")
+			return nil, err
 		}
 
 		fmt.Println("Initialization complete")
 		return nil, nil
 	}
 
-	func (t *SimpleChaincode) createAccounts(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	func (t *SimpleChaincode) createAccounts(stub StateStub, args []string) ([]byte, error) {
 
 		//  				0
 		// "number of accounts to create"
 		var err error
+		caller, err := getCaller(stub)
+		if err != nil {
+			return nil, err
+		}
+		if caller.Role != RoleAdmin {
+			fmt.Println("Caller " + caller.ID + " is not an admin")
+			return nil, errors.New("Only an admin can create accounts in bulk")
+		}
 		numAccounts, err := strconv.Atoi(args[0])
 		if err != nil {
 			fmt.Println("error creating accounts with input")
@@ -174,13 +175,13 @@
 				prefix = strconv.Itoa(counter) + suffix
 			}
 			var assetIds []string
-			account = Account{ID: "company" + strconv.Itoa(counter), Prefix: prefix, CashBalance: 10000000.0, AssetsIds: assetIds}
+			account = Account{ID: "company" + strconv.Itoa(counter), Prefix: prefix, Balances: Balances{defaultCurrency: wholeMoney(10000000, defaultCurrency)}, AssetsIds: assetIds}
 			accountBytes, err := json.Marshal(&account)
 			if err != nil {
 				fmt.Println("error creating account" + account.ID)
 				return nil, errors.New("Error creating account " + account.ID)
 			}
-			err = stub.PutState(accountPrefix+account.ID, accountBytes)
+			err = putWithHistory(stub, accountPrefix+account.ID, accountBytes, HistoryAccount, account.ID, "createAccount")
 			counter++
 			fmt.Println("created account" + accountPrefix + account.ID)
 		}
@@ -190,23 +191,45 @@
 
 	}
 
-	func (t *SimpleChaincode) createAccount(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	func (t *SimpleChaincode) createAccount(stub StateStub, args []string) ([]byte, error) {
 		// Obtain the username to associate with the account
 		var account Account
-	 if len(args) != 1 {
+	 if len(args) < 1 || len(args) > 2 {
 			fmt.Println("Error obtaining username")
-			return nil, errors.New("createAccount accepts a single username argument")
+			return nil, errors.New("createAccount accepts a username and an optional currency argument")
 		}
 		username := args[0]
 		
+		// The opening balance is in the given currency
+		currency := defaultCurrency
+		if len(args) > 1 {
+			currency = strings.ToUpper(args[1])
+			_, err := currencyExponent(currency)
+			if err != nil {
+				return nil, err
+			}
+		}
+		
+		// Users open their own accounts; a bank's admin opens the bank's
+		caller, err := getCaller(stub)
+		if err != nil {
+			return nil, err
+		}
+		if caller.Role != RoleAdmin {
+			_, err = requireCaller(stub, username, username)
+			if err != nil {
+				return nil, err
+			}
+		}
+		
 		// Build an account object for the user
 		var assetIds []string
 		suffix := "000A"
 		prefix := username + suffix
 		if strings.Contains(username, "bank") {
-					account = Account{ID: username, Prefix: prefix, CashBalance: 1000000.0, AssetsIds: assetIds}
+					account = Account{ID: username, Prefix: prefix, Balances: Balances{currency: wholeMoney(1000000, currency)}, AssetsIds: assetIds}
 			} else {
-					account = Account{ID: username, Prefix: prefix, CashBalance: 100.0, AssetsIds: assetIds}
+					account = Account{ID: username, Prefix: prefix, Balances: Balances{currency: wholeMoney(100, currency)}, AssetsIds: assetIds}
 			}
 		accountBytes, err := json.Marshal(&account)
 		if err != nil {
@@ -225,7 +248,7 @@
 				
 				if strings.Contains(err.Error(), "unexpected end") {
 					fmt.Println("No data means existing account found for " + account.ID + ", initializing account.")
-					err = stub.PutState(accountPrefix+account.ID, accountBytes)
+					err = putWithHistory(stub, accountPrefix+account.ID, accountBytes, HistoryAccount, account.ID, "createAccount")
 					
 					if err == nil {
 						fmt.Println("created account" + accountPrefix + account.ID)
@@ -244,7 +267,7 @@
 		} else {
 			
 			fmt.Println("No existing account found for " + account.ID + ", initializing account.")
-			err = stub.PutState(accountPrefix+account.ID, accountBytes)
+			err = putWithHistory(stub, accountPrefix+account.ID, accountBytes, HistoryAccount, account.ID, "createAccount")
 			
 			if err == nil {
 				fmt.Println("created account" + accountPrefix + account.ID)
@@ -258,68 +281,91 @@
 
 	}
 
-	func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	func (t *SimpleChaincode) issueCommercialPaper(stub StateStub, args []string) ([]byte, error) {
 
 		/*		0
 			json
 			{
-				"ticker":  "string",
-				"par": 0.00,
-				"qty": 10,
-				"discount": 7.5,
-				"maturity": 30,
-				"owners": [ // This one is not required
-					{
-						"company": "company1",
-						"quantity": 5
-					},
-					{
-						"company": "company3",
-						"quantity": 3
-					},
-					{
-						"company": "company4",
-						"quantity": 2
-					}
-				],				
+				"contract": "bank1000C",
+				"name": "string",
+				"gender": "F",
+				"dateOfBirth": "1990-04-21",
+				"city": "string",
+				"state": "string",
+				"phone": "string",
+				"house": "string",
+				"street": "string",
+				"pin": "string",
+				"email": "string",
+				"mobile": "string",
+				"fmrdata": "string",
+				"filename": "string",
 				"issuer":"company2",
 				"issueDate":"1456161763790"  (current time in milliseconds as a string)
 
 			}
+				1
+			base64 PII key of the contract's bank, required when the contract
+			has a piiKeyFingerprint
 		*/
-		//need one arg
-		if len(args) != 1 {
+		//need one or two args
+		if len(args) < 1 || len(args) > 2 {
 			fmt.Println("error invalid arguments")
 			return nil, errors.New("Incorrect number of arguments. Expecting commercial paper record")
 		}
 
-		var cp CP
+		var cp KYCRecord
 		var err error
 		var account Account
 		var bankcontract BANKCONTRACT
 		
-		fmt.Println("Unmarshalling CP")
+		fmt.Println("Unmarshalling KYC record")
 		err = json.Unmarshal([]byte(args[0]), &cp)
 		if err != nil {
 			fmt.Println("error invalid paper issue")
 			return nil, errors.New("Invalid commercial paper issue")
 		}
+		cp.SchemaVersion = kycSchemaVersion
 		
-		fmt.Println("Getting state of Bank Contract- " + cp.Contract)
-		contractBytes, err := stub.GetState(cp.Contract)
+		// Onboard under the version of the contract in effect now
+		now, err := stub.GetTxTimestamp()
 		if err != nil {
-			fmt.Println("Error Getting state of Contract - " + cp.Contract)
-			return nil, errors.New("Error retrieving contract " + cp.Contract)
+			return nil, err
 		}
-		err = json.Unmarshal(contractBytes, &bankcontract)
+		bankcontract, err = contractInEffect(stub, cp.Contract, now)
 		if err != nil {
-			fmt.Println("Error Unmarshalling Bank Contract")
-			return nil, errors.New("Error retrieving Bank Contract " + cp.Contract)
+			return nil, err
 		}
+		if bankcontract.status() != ContractActive {
+			fmt.Println("Bank Contract " + cp.Contract + " is " + bankcontract.status())
+			return nil, errors.New("Bank Contract " + cp.Contract + " is " + strings.ToLower(bankcontract.status()) + " and not onboarding customers")
+		}
+		cp.ContractVersion = bankcontract.version()
 		fmt.Println("-----------------Everything goes fine-------------")
 		
-		//Split Bank Validators
-		validators := strings.Split(bankcontract.BANKVALIDATORS, ",")
+		// The customer submits their own KYC, or the bank does on their behalf
+		caller, err := requireCaller(stub, cp.Issuer, bankcontract.BANKID)
+		if err != nil {
+			return nil, err
+		}
+		
+		validators := bankcontract.validatorList()
+		if len(validators) == 0 {
+			fmt.Println("Bank Contract has no validators " + cp.Contract)
+			return nil, errors.New("Bank Contract " + cp.Contract + " has no validators")
+		}
+		err = bankcontract.commissionSchedule().checkRecord(cp)
+		if err != nil {
+			fmt.Println(err)
+			return nil, err
+		}
+		requested := cp.RiskTier
+		cp.RiskTier = ""
+		err = cp.setRiskTier(bankcontract, caller, requested)
+		if err != nil {
+			fmt.Println(err)
+			return nil, err
+		}
 		
 		//generate the CUSIP
 		//get account prefix
@@ -336,137 +382,135 @@
 		}
 		fmt.Println("-----------------Everything goes fine-------------")
 		
-		account.AssetsIds = append(account.AssetsIds, cp.CUSIP)
-
-		// Set the issuer to be the owner of all quantity
-		cp.Owner = validators[0]
-
-		suffix, err := generateCUSIPSuffix(cp.IssueDate, cp.Age)
+		// Hand the record to the validators for review
+		cp.Owner = bankcontract.approvalPolicy().firstOwner(bankcontract)
+		cp.Status = StatusSubmitted
+		cp.StatusReason = ""
+		cp.SignOffs = nil
+		cp.Round = 0
+		cp.VerifiedAt = nil
+		cp.ValidUntil = nil
+
+		if cp.DateOfBirth.IsZero() {
+			fmt.Println("Date of birth is required")
+			return nil, errors.New("Date of birth is required")
+		}
+		var sequence int
+		cp.CUSIP, sequence, err = nextCUSIP(stub, account)
 		if err != nil {
 			fmt.Println("Error generating cusip")
-			return nil, errors.New("Error generating CUSIP")
+			return nil, err
 		}
+		account.AssetsIds = append(account.AssetsIds, cp.CUSIP)
 		fmt.Println("Marshalling CP bytes")
-		cp.CUSIP = account.Prefix + suffix
-		fmt.Println("-----------------Everything goes fine-------------")
-		fmt.Println("Getting State on CP " + cp.CUSIP)
-		cpRxBytes, err := stub.GetState(cpPrefix+cp.CUSIP)
-		if cpRxBytes == nil {
-			fmt.Println("CUSIP does not exist, creating it")
-			cpBytes, err := json.Marshal(&cp)
-			if err != nil {
-				fmt.Println("Error marshalling cp")
-				return nil, errors.New("Error issuing commercial paper")
-			}
-			err = stub.PutState(cpPrefix+cp.CUSIP, cpBytes)
-			if err != nil {
-				fmt.Println("Error issuing paper")
-				return nil, errors.New("Error issuing commercial paper")
-			}
-
-			fmt.Println("Marshalling account bytes to write")
-			accountBytesToWrite, err := json.Marshal(&account)
-			if err != nil {
-				fmt.Println("Error marshalling account")
-				return nil, errors.New("Error issuing commercial paper")
+		
+		// Encrypt the PII for the contract's bank
+		cp.Encryption = nil
+		if bankcontract.PIIKEYFINGERPRINT != "" {
+			if len(args) < 2 {
+				fmt.Println("PII key required for " + cp.Contract)
+				return nil, errors.New("Bank Contract " + cp.Contract + " requires the bank's PII key")
 			}
-			err = stub.PutState(accountPrefix + cp.Issuer, accountBytesToWrite)
+			bankKey, err := decodeBankKey(args[1], bankcontract.PIIKEYFINGERPRINT)
 			if err != nil {
-				fmt.Println("Error putting state on accountBytesToWrite")
-				return nil, errors.New("Error issuing commercial paper")
-			}
-			
-			
-			// Update the paper keys by adding the new key
-			fmt.Println("Getting Paper Keys")
-			keysBytes, err := stub.GetState("PaperKeys")
-			if err != nil {
-				fmt.Println("Error retrieving paper keys")
-				return nil, errors.New("Error retrieving paper keys")
+				fmt.Println(err)
+				return nil, err
 			}
-			var keys []string
-			err = json.Unmarshal(keysBytes, &keys)
+			err = encryptPII(&cp, bankcontract.BANKID, bankKey, stub.GetTxID())
 			if err != nil {
-				fmt.Println("Error unmarshel keys")
-				return nil, errors.New("Error unmarshalling paper keys ")
-			}
-			
-			fmt.Println("Appending the new key to Paper Keys")
-			foundKey := false
-			for _, key := range keys {
-				if key == cpPrefix+cp.CUSIP {
-					foundKey = true
-				}
-			}
-			if foundKey == false {
-				keys = append(keys, cpPrefix+cp.CUSIP)
-				keysBytesToWrite, err := json.Marshal(&keys)
-				if err != nil {
-					fmt.Println("Error marshalling keys")
-					return nil, errors.New("Error marshalling the keys")
-				}
-				fmt.Println("Put state on PaperKeys")
-				err = stub.PutState("PaperKeys", keysBytesToWrite)
-				if err != nil {
-					fmt.Println("Error writting keys back")
-					return nil, errors.New("Error writing the keys back")
-				}
+				fmt.Println("Error encrypting PII")
+				return nil, errors.New("Error encrypting PII")
 			}
-			fmt.Println("--------------------------------------------------------Everything goes fine--------------------------------------------")
-			fmt.Println("Issue commercial paper %+v\n", cp)
-			return nil, nil
-		} 
-		return nil, nil
-	}
-
+		} else if len(args) > 1 {
+			fmt.Println("No PII key registered for " + cp.Contract)
+			return nil, errors.New("Bank Contract " + cp.Contract + " has no PII key registered")
+		}
+		fmt.Println("-----------------Everything goes fine-------------")
+		fmt.Println("Getting State on CP " + cp.CUSIP)
+		cpRxBytes, err := stub.GetState(cpPrefix+cp.CUSIP)
+		if err != nil {
+			fmt.Println("Error Getting state of - " + cpPrefix + cp.CUSIP)
+			return nil, errors.New("Error issuing commercial paper")
+		}
+		if cpRxBytes != nil {
+			fmt.Println("CUSIP " + cp.CUSIP + " already exists")
+			return nil, errors.New("KYC record " + cp.CUSIP + " already exists")
+		}
+		fmt.Println("CUSIP does not exist, creating it")
+		cpBytes, err := json.Marshal(&cp)
+		if err != nil {
+			fmt.Println("Error marshalling cp")
+			return nil, errors.New("Error issuing commercial paper")
+		}
+		err = saveCUSIPSequence(stub, account, sequence)
+		if err != nil {
+			return nil, err
+		}
+		err = putWithHistory(stub, cpPrefix+cp.CUSIP, cpBytes, HistoryCUSIP, cp.CUSIP, "issueCommercialPaper")
+		if err != nil {
+			fmt.Println("Error issuing paper")
+			return nil, errors.New("Error issuing commercial paper")
+		}
 
-	func GetAllCPs(stub *shim.ChaincodeStub) ([]CP, error){
-	fmt.Println("--------------In GetAllCPs-------------")	
-		var allCPs []CP
-		
-		// Get list of all the keys
-		keysBytes, err := stub.GetState("PaperKeys")
+		fmt.Println("Marshalling account bytes to write")
+		accountBytesToWrite, err := json.Marshal(&account)
 		if err != nil {
-			fmt.Println("Error retrieving paper keys")
-			return nil, errors.New("Error retrieving paper keys")
+			fmt.Println("Error marshalling account")
+			return nil, errors.New("Error issuing commercial paper")
 		}
-		var keys []string
-		err = json.Unmarshal(keysBytes, &keys) 	
+		err = putWithHistory(stub, accountPrefix+cp.Issuer, accountBytesToWrite, HistoryAccount, cp.Issuer, "issueCommercialPaper")
 		if err != nil {
-			fmt.Println("Error unmarshalling paper keys----------")
-			fmt.Println(err)
-			return nil, errors.New("Error unmarshalling paper keys")
+			fmt.Println("Error putting state on accountBytesToWrite")
+			return nil, errors.New("Error issuing commercial paper")
+		}
+		
+		err = setEvent(stub, Event{Type: EventKYCSubmitted, CUSIP: cp.CUSIP, Contract: cp.Contract, Bank: bankcontract.BANKID,
+			Issuer: cp.Issuer, To: cp.Owner, Status: cp.Status})
+		if err != nil {
+			return nil, err
 		}
+		
+		
+		fmt.Println("--------------------------------------------------------Everything goes fine--------------------------------------------")
+		fmt.Printf("Issue commercial paper %+v\n", cp)
+		return nil, nil
+	}
 
-		// Get all the cps
-		for _, value := range keys {
-			fmt.Println("------------------------Keys-----------------"+value)
-			cpBytes, err := stub.GetState(value)
-			
-			var cp CP
-			err = json.Unmarshal(cpBytes, &cp)
+
+	func GetAllCPs(stub StateStub) ([]KYCRecord, error){
+	fmt.Println("--------------In GetAllCPs-------------")	
+		var allCPs []KYCRecord
+		
+		// Records are keyed by cpPrefix, so a range over it yields them all
+		err := forEachInRange(stub, cpPrefix, func(key string, cpBytes []byte) error {
+			fmt.Println("------------------------Keys-----------------"+key)
+			cp, err := decodeKYCRecord(cpBytes)
 			if err != nil {
-				fmt.Println("Error retrieving cp " + value)
-				return nil, errors.New("Error retrieving cp " + value)
+				fmt.Println("Error retrieving cp " + key)
+				return errors.New("Error retrieving cp " + key)
 			}
 			
-			fmt.Println("Appending CP" + value)
+			fmt.Println("Appending CP" + key)
 			allCPs = append(allCPs, cp)
-		}	
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 		fmt.Println("-----------------------Everything goes fine in GetAllCPs------------------")
 		return allCPs, nil 
 	}
 
-	func GetCP(cpid string, stub *shim.ChaincodeStub) (CP, error){
+	func GetCP(cpid string, stub StateStub) (KYCRecord, error){
 	fmt.Println("--------------In GetCP-------------")
-		var cp CP
+		var cp KYCRecord
 		cpBytes, err := stub.GetState(cpid)
 		if err != nil {
 			fmt.Println("Error retrieving cp " + cpid)
 			return cp, errors.New("Error retrieving cp " + cpid)
 		}
 			
-		err = json.Unmarshal(cpBytes, &cp)
+		cp, err = decodeKYCRecord(cpBytes)
 		if err != nil {
 			fmt.Println("Error unmarshalling cp " + cpid)
 			return cp, errors.New("Error unmarshalling cp " + cpid)
@@ -474,54 +518,65 @@
 		return cp, nil 
 	}
 
-//====================Get All Document=============================
-	func getAllDocs(stub *shim.ChaincodeStub) ([]DOCUMENT, error){
-	fmt.Println("--------------In getAllDocs-------------")	
-		var allDocs []DOCUMENT
-		
-		// Get list of all the keys
-		keysBytes, err := stub.GetState("DocKeys")
+	// getCustomerRecord loads the KYC record for a CUSIP, failing if the
+	// customer has not been onboarded.
+	func getCustomerRecord(stub StateStub, cusip string) (KYCRecord, error) {
+		var record KYCRecord
+		recordBytes, err := stub.GetState(cpPrefix + cusip)
 		if err != nil {
-			fmt.Println("Error retrieving doc keys")
-			return nil, errors.New("Error retrieving doc keys")
+			fmt.Println("Error retrieving cp " + cusip)
+			return record, errors.New("Error retrieving cp " + cusip)
+		}
+		if recordBytes == nil {
+			fmt.Println("Customer not found " + cusip)
+			return record, errors.New("Customer not found " + cusip)
 		}
-		var keys []string
-		err = json.Unmarshal(keysBytes, &keys) 	
+		record, err = decodeKYCRecord(recordBytes)
 		if err != nil {
-			fmt.Println("Error unmarshalling doc keys----------")
-			fmt.Println(err)
-			return nil, errors.New("Error unmarshalling doc keys")
+			fmt.Println("Error unmarshalling cp " + cusip)
+			return record, errors.New("Error unmarshalling cp " + cusip)
 		}
+		return record, nil
+	}
 
-		// Get all the docs
-		for _, count := range keys {
-			fmt.Println("------------------------Document Keys-----------------"+count)
-			docBytes, err := stub.GetState(count)
-			
-			var doc DOCUMENT
-			err = json.Unmarshal(docBytes, &doc)
+//====================Get All Document=============================
+	func getAllDocs(stub StateStub) ([]DOCUMENT, error){
+	fmt.Println("--------------In getAllDocs-------------")	
+		var allDocs []DOCUMENT
+		
+		// Get all the docs through the document index
+		err := forEachIndexed(stub, docIndex, nil, func(docKey string) error {
+			fmt.Println("------------------------Document Keys-----------------"+docKey)
+			doc, err := getDoc(docKey, stub)
 			if err != nil {
-				fmt.Println("Error retrieving doc " + count)
-				return nil, errors.New("Error retrieving doc " + count)
+				return err
 			}
 			
-			fmt.Println("Appending Document" + count)
+			fmt.Println("Appending Document" + docKey)
 			allDocs = append(allDocs, doc)
-		}	
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 		fmt.Println("-----------------------Everything goes fine in getAllDocs------------------")
 		return allDocs, nil 
 	}
 	
 	
 	//====================Get Documents===============================
-	func getDoc(docid string, stub *shim.ChaincodeStub) (DOCUMENT, error){
+	func getDoc(docid string, stub StateStub) (DOCUMENT, error){
 	fmt.Println("--------------In getDoc-------------")
 		var doc DOCUMENT
-		docBytes, err := stub.GetState(docid)
+		docBytes, err := stub.GetState(docPrefix + docid)
 		if err != nil {
 			fmt.Println("Error retrieving doc " + docid)
 			return doc, errors.New("Error retrieving doc " + docid)
 		}
+		if docBytes == nil {
+			fmt.Println("Document not found " + docid)
+			return doc, errors.New("Document not found " + docid)
+		}
 			
 		err = json.Unmarshal(docBytes, &doc)
 		if err != nil {
@@ -530,13 +585,35 @@
 		}
 		return doc, nil 
 	}	
+
+	//====================Get Documents For Customer===============================
+	func getDocsForCustomer(cusip string, stub StateStub) ([]DOCUMENT, error){
+	fmt.Println("--------------In getDocsForCustomer-------------")
+		_, err := getCustomerRecord(stub, cusip)
+		if err != nil {
+			return nil, err
+		}
+		docs := []DOCUMENT{}
+		err = forEachIndexed(stub, docIndex, []string{cusip}, func(docKey string) error {
+			doc, err := getDoc(docKey, stub)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return docs, nil
+	}
 	
 //==================================Get Company================================
-	func GetCompany(companyID string, stub *shim.ChaincodeStub) (Account, error){
+	func GetCompany(companyID string, stub StateStub) (Account, error){
 	fmt.Println("--------------In GetCompany-------------")
 		var company Account
 		companyBytes, err := stub.GetState(accountPrefix+companyID)
-		if err != nil {
+		if err != nil || companyBytes == nil {
 			fmt.Println("Account not found " + companyID)
 			return company, errors.New("Account not found " + companyID)
 		}
@@ -554,7 +631,7 @@
 	// Still working on this one
 	
 	
-	func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	func (t *SimpleChaincode) transferPaper(stub StateStub, args []string) ([]byte, error) {
 	fmt.Println("--------------In transferPaper-------------")
 		/*		0
 			json
@@ -562,7 +639,8 @@
 				  "CUSIP": "",
 				  "fromCompany":"",
 				  "toCompany":"",
-				  "quantity": 1
+				  "quantity": 1,
+				  "riskTier": "" (optional)
 			}
 		*/
 		//need one arg
@@ -578,183 +656,197 @@
 			fmt.Println("Error Unmarshalling Transaction")
 			return nil, errors.New("Invalid commercial paper issue")
 		}
+		// Only the validator themselves can approve
+		caller, err := requireRole(stub, tr.FromCompany, RoleValidator)
+		if err != nil {
+			return nil, err
+		}
 		// Get state of CUSIP given by User
 		fmt.Println("Getting State on CP " + tr.CUSIP)
 		cpBytes, err := stub.GetState(cpPrefix+tr.CUSIP)
-		if err != nil {
+		if err != nil || cpBytes == nil {
 			fmt.Println("CUSIP not found")
 			return nil, errors.New("CUSIP not found " + tr.CUSIP)
 		}
 		fmt.Println("---------------------transferPaper--------------part1---------success---")
 		// Get Data of CUSIP from Blockchain
-		var cp CP
 		fmt.Println("Unmarshalling CP " + tr.CUSIP)
-		err = json.Unmarshal(cpBytes, &cp)
+		cp, err := decodeKYCRecord(cpBytes)
 		if err != nil {
 			fmt.Println("Error unmarshalling cp " + tr.CUSIP)
 			return nil, errors.New("Error unmarshalling cp " + tr.CUSIP)
 		}
 		
 		
-		var bankcontract BANKCONTRACT
-		fmt.Println("Getting state of Bank Contract- " + cp.Contract)
-		contractBytes, err := stub.GetState(cp.Contract)
-		if err != nil {
-			fmt.Println("Error Getting state of Contract - " + cp.Contract)
-			return nil, errors.New("Error retrieving contract " + cp.Contract)
-		}
-		err = json.Unmarshal(contractBytes, &bankcontract)
+		bankcontract, err := contractForRecord(stub, cp)
 		if err != nil {
-			fmt.Println("Error Unmarshalling Bank Contract")
-			return nil, errors.New("Error retrieving Bank Contract " + cp.Contract)
+			return nil, err
 		}
 		fmt.Println("-----------------Everything goes fine-------------")
 		
-		//Split Bank Validators
-		validators := strings.Split(bankcontract.BANKVALIDATORS, ",")
-		if tr.FromCompany == validators[0] {
-			tr.ToCompany = validators[1]
-		} else {
-			tr.ToCompany = bankcontract.BANKID
+		validators := bankcontract.validatorList()
+		policy := bankcontract.approvalPolicy()
+		
+		// Only records still with the validators can move on
+		if cp.Status == "" {
+			cp.Status = legacyKYCStatus(cp, bankcontract, validators)
+		}
+		if !cp.Status.awaitingValidation() {
+			fmt.Println("KYC record " + tr.CUSIP + " is not awaiting validation, status " + string(cp.Status))
+			return nil, errors.New("KYC record " + tr.CUSIP + " is not awaiting validation, status " + string(cp.Status))
 		}
 		
-		// Get State for Account of from company
-		var fromCompany Account
-		fmt.Println("Getting State on fromCompany " + cp.Issuer)	
-		fromCompanyBytes, err := stub.GetState(accountPrefix+cp.Issuer)
+		// The approving validator must be entitled to act under the contract's policy
+		err = policy.checkReviewer(cp, validators, tr.FromCompany)
 		if err != nil {
-			fmt.Println("Account not found " + cp.Issuer)
-			return nil, errors.New("Account not found " + cp.Issuer)
+			fmt.Println(err)
+			return nil, err
 		}
-		fmt.Println("---------------------transferPaper--------------part2---------success---")
-		// Get account infromation of from company
-		fmt.Println("Unmarshalling FromCompany ")
-		err = json.Unmarshal(fromCompanyBytes, &fromCompany)
+		err = cp.signOff(stub, tr.FromCompany, DecisionApproved, "")
 		if err != nil {
-			fmt.Println("Error unmarshalling account " + tr.FromCompany)
-			return nil, errors.New("Error unmarshalling account " + tr.FromCompany)
+			return nil, err
+		}
+		if tr.RiskTier != "" {
+			err = cp.setRiskTier(bankcontract, caller, tr.RiskTier)
+			if err != nil {
+				fmt.Println(err)
+				return nil, err
+			}
 		}
 		
-			
-		
-	
-		
-		
-		
-		
-		// Payment Transfer Start
+		// Deliver to the bank once the policy is satisfied
+		if policy.satisfied(cp, validators, tr.FromCompany) {
+			tr.ToCompany = bankcontract.BANKID
+		} else {
+			tr.ToCompany = policy.nextOwner(bankcontract, tr.FromCompany)
+		}
 		
-	if tr.ToCompany	== bankcontract.BANKID {
-			
-		// Get state for Account of to company
-		var toCompany Account
-		fmt.Println("Getting State on ToCompany " + tr.ToCompany)
-		toCompanyBytes, err := stub.GetState(accountPrefix+tr.ToCompany)
+		// Deliveries pay the contract's commission from the bank to the
+		// issuer and the validators sharing it. Check the accounts, the
+		// commission and the bank's funds before anything is written.
+		now, err := stub.GetTxTimestamp()
 		if err != nil {
-			fmt.Println("Account not found " + tr.ToCompany)
-			return nil, errors.New("Account not found " + tr.ToCompany)
-		}
-		fmt.Println("---------------------transferPaper--------------part3---------success---")
-		// Get Account infomation of to company
-		fmt.Println("Unmarshalling tocompany")
-		err = json.Unmarshal(toCompanyBytes, &toCompany)
-		fmt.Println(err)
-		fmt.Println(toCompanyBytes)
-		fmt.Println("---------------------transferPaper--------------part4---------success---")
-		if err != nil {
-			fmt.Println("Error unmarshalling account " + tr.ToCompany)
-			return nil, errors.New("Error unmarshalling account " + tr.ToCompany)
-		}	
-			
-			commissionToBeTransferred, err := strconv.ParseFloat(bankcontract.COMMISSION, 64)
+			return nil, err
+		}
+		batch := newWriteBatch(stub)
+		settlementID := ""
+		if tr.ToCompany == bankcontract.BANKID {
+			toCompany, err := GetCompany(tr.ToCompany, stub)
 			if err != nil {
-				fmt.Println("Error while parsing Bank Commission")
-			}
-			amountToBeTransferred := commissionToBeTransferred
-			
-			// If toCompany doesn't have enough cash to buy the papers
-			if toCompany.CashBalance < amountToBeTransferred {
-				fmt.Println("The company " + tr.ToCompany + "doesn't have enough cash to purchase the papers")		
-				return nil, errors.New("The company " + tr.ToCompany + "doesn't have enough cash to purchase the papers")	
-			} else {
-				fmt.Println("The ToCompany has enough money to be transferred for this paper")
+				return nil, err
 			}
 			
-			toCompany.CashBalance -= amountToBeTransferred
-			fromCompany.CashBalance += amountToBeTransferred
-
-			toCompanyBytesToWrite, err := json.Marshal(&toCompany)
-			fmt.Println("******************toCompanyData**********")
-			fmt.Println(toCompanyBytesToWrite)
-			fmt.Println("*****************************************")
+			amountToBeTransferred, err := commissionDue(stub, batch, bankcontract, cp, now)
 			if err != nil {
 				fmt.Println(err)
-				fmt.Println("Error marshalling the toCompany")
-				return nil, errors.New("Error marshalling the toCompany")
+				return nil, err
 			}
-			fmt.Println("Put state on toCompany")
-			err = stub.PutState(accountPrefix+tr.ToCompany, toCompanyBytesToWrite)
-			if err != nil {
-				fmt.Println("Error writing the toCompany back")
-				return nil, errors.New("Error writing the toCompany back")
+			
+			// The payees are paid in the commission's currency; the bank may
+			// settle in another at the oracle's rate
+			payment := amountToBeTransferred
+			if bankcontract.SETTLEMENTCURRENCY != "" {
+				payment, err = convert(stub, amountToBeTransferred, bankcontract.SETTLEMENTCURRENCY)
+				if err != nil {
+					fmt.Println(err)
+					return nil, err
+				}
 			}
+			
+			if bankcontract.ESCROWHOURS > 0 {
+				// Hold the commission until the bank confirms the delivery
+				settlementID = stub.GetTxID()
+				settlement := Settlement{ID: settlementID, Payer: tr.ToCompany, Payee: cp.Issuer, Cash: payment,
+					Payment: amountToBeTransferred, CUSIP: cp.CUSIP, Contract: cp.Contract}
+				err = lockEscrow(stub, batch, &settlement, time.Duration(bankcontract.ESCROWHOURS)*time.Hour, &toCompany)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				// If toCompany doesn't have enough cash to pay the commission
+				err = toCompany.debit(payment)
+				if err != nil {
+					fmt.Println("The company " + tr.ToCompany + " doesn't have enough cash to pay the commission")
+					return nil, err
+				}
+				err = batch.put(accountPrefix+tr.ToCompany, &toCompany, HistoryAccount, tr.ToCompany, "transferPaper")
+				if err != nil {
+					return nil, err
+				}
 				
-			// From company
-			fromCompanyBytesToWrite, err := json.Marshal(&fromCompany)
-			fmt.Println("******************toCompanyData**********")
-			fmt.Println(fromCompany)
-			fmt.Println("*****************************************")
-			if err != nil {
-				fmt.Println(err)
-				fmt.Println("Error marshalling the fromCompany")
-				return nil, errors.New("Error marshalling the fromCompany")
-			}
-			fmt.Println("Put state on fromCompany")
-			err = stub.PutState(accountPrefix+cp.Issuer, fromCompanyBytesToWrite)
-			if err != nil {
-				fmt.Println("Error writing the fromCompany back")
-				return nil, errors.New("Error writing the fromCompany back")
+				payouts, err := bankcontract.commissionSchedule().payouts(amountToBeTransferred, cp.Issuer)
+				if err != nil {
+					return nil, err
+				}
+				accounts := map[string]*Account{tr.ToCompany: &toCompany}
+				for _, payout := range payouts {
+					payee, ok := accounts[payout.Payee]
+					if !ok {
+						account, err := GetCompany(payout.Payee, stub)
+						if err != nil {
+							return nil, err
+						}
+						payee = &account
+						accounts[payout.Payee] = payee
+					}
+					err = payee.credit(payout.Amount)
+					if err != nil {
+						return nil, err
+					}
+					err = batch.put(accountPrefix+payee.ID, payee, HistoryAccount, payee.ID, "transferPaper")
+					if err != nil {
+						return nil, err
+					}
+					if payout.Payee == cp.Issuer {
+						continue
+					}
+					err = accrueCommission(stub, batch, CommissionEntry{Validator: payout.Payee, CUSIP: cp.CUSIP, Contract: cp.Contract,
+						ContractVersion: bankcontract.version(), Bank: bankcontract.BANKID, Amount: payout.Amount, DeliveredAt: now, TxID: stub.GetTxID()})
+					if err != nil {
+						return nil, err
+					}
+				}
 			}
-	}		
-		
-		//Payment Transfer End
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
+		}
 
-		// Check for all the possible errors
-		ownerFound := false 
-			if cp.Owner == tr.FromCompany {
-				ownerFound = true
-				cp.Owner = tr.ToCompany		//Transfer KYC to ToCompany
-			}
+		cp.Owner = tr.ToCompany		//Transfer KYC to ToCompany
 		
-		// If fromCompany doesn't own this paper
-		if ownerFound == false {
-			fmt.Println("The company " + tr.FromCompany + "doesn't own any of this paper")
-			return nil, errors.New("The company " + tr.FromCompany + "doesn't own any of this paper")	
+		// Advance the workflow
+		if tr.ToCompany == bankcontract.BANKID {
+			err = cp.transition(StatusValidatorApproved, "")
+			if err == nil {
+				err = cp.transition(StatusVerified, "")
+			}
+			if err == nil {
+				// The record is valid for its risk tier from now
+				err = cp.startValidity(bankcontract, now)
+			}
 		} else {
-			fmt.Println("The FromCompany does own this paper")
+			err = cp.transition(StatusUnderReview, "")
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = batch.put(cpPrefix+tr.CUSIP, &cp, HistoryCUSIP, tr.CUSIP, "transferPaper")
+		if err != nil {
+			return nil, err
 		}
-		fmt.Println("---------------------transferPaper--------------part4---------success---")
-		// cp
-		cpBytesToWrite, err := json.Marshal(&cp)
+		
+		fmt.Println("Put state on accounts and CP")
+		err = batch.commit()
 		if err != nil {
-			fmt.Println("Error marshalling the cp")
-			return nil, errors.New("Error marshalling the cp")
+			return nil, err
+		}
+		
+		// Tell listeners where the record went
+		event := Event{Type: EventValidatorApproved, CUSIP: cp.CUSIP, Contract: cp.Contract, Bank: bankcontract.BANKID,
+			Validator: tr.FromCompany, From: tr.FromCompany, To: tr.ToCompany, Status: cp.Status, SettlementID: settlementID}
+		if tr.ToCompany == bankcontract.BANKID {
+			event.Type = EventKYCTransferred
 		}
-		fmt.Println("Put state on CP")
-		err = stub.PutState(cpPrefix+tr.CUSIP, cpBytesToWrite)
+		err = setEvent(stub, event)
 		if err != nil {
-			fmt.Println("Error writing the cp back")
-			return nil, errors.New("Error writing the cp back")
+			return nil, err
 		}
 		
 		fmt.Println("Successfully completed Invoke") 
@@ -762,7 +854,7 @@
 	}
 
 
-	func (t *SimpleChaincode) issueBankContract(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	func (t *SimpleChaincode) issueBankContract(stub StateStub, args []string) ([]byte, error) {
 
 		//need one arg
 		if len(args) != 1 {
@@ -772,81 +864,93 @@
 
 		var bankcontract BANKCONTRACT
 		var err error
-		suffix := "000C"
 		fmt.Println("Unmarshalling Bank Contract")
 		err = json.Unmarshal([]byte(args[0]), &bankcontract)
 		if err != nil {
 			fmt.Println("error invalid bank contract")
 			return nil, errors.New("Invalid bank contract")
 		}
+		
+		// Only the bank's admin can issue its contracts
+		caller, err := getCaller(stub)
+		if err != nil {
+			return nil, err
+		}
+		if !caller.isBankAdmin(bankcontract.BANKID) {
+			fmt.Println("Caller " + caller.ID + " is not an admin of " + bankcontract.BANKID)
+			return nil, errors.New("Caller " + caller.ID + " is not an admin of " + bankcontract.BANKID)
+		}
 
 	
-		fmt.Println("Marshalling CP bytes")
-		bankcontract.CONTRACTID = bankcontract.BANKID + suffix
-		fmt.Println("Getting State on BANK CONTRACT " + bankcontract.CONTRACTID)
-		bankcontractRxBytes, err := stub.GetState(bankcontract.CONTRACTID)
-		if bankcontractRxBytes == nil {
-			fmt.Println("Bank contract does not exist, creating it")
-			bankcontractBytes, err := json.Marshal(&bankcontract)
-			if err != nil {
-				fmt.Println("Error marshalling Bank Contract")
-				return nil, errors.New("Error issuing Bank Contract")
-			}
-			err = stub.PutState(bankcontract.CONTRACTID, bankcontractBytes)
-			if err != nil {
-				fmt.Println("Error issuing Bank Contract")
-				return nil, errors.New("Error issuing Bank Contract")
-			}
-			
-			// Update the bank keys by adding the new key
-			fmt.Println("Getting Bank Keys")
-			keysBytes, err := stub.GetState("BankKeys")
-			if err != nil {
-				fmt.Println("Error retrieving bank keys")
-				return nil, errors.New("Error retrieving bank keys")
-			}
-			var keys []string
-			err = json.Unmarshal(keysBytes, &keys)
+		err = bankcontract.validate()
+		if err != nil {
+			fmt.Println(err)
+			return nil, err
+		}
+		
+		// A new contract starts at version 1, in effect from its effective
+		// date
+		bankcontract.VERSION = 1
+		bankcontract.STATUS = ContractActive
+		bankcontract.STATUSREASON = ""
+		bankcontract.EFFECTIVEDATE, err = effectiveDate(stub, bankcontract.EFFECTIVEDATE, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+	
+		// Resubmitting a contract is refused rather than ignored; a bank
+		// changes its contracts with amendBankContract. New contracts are
+		// given their IDs, so any other ID is refused too
+		if bankcontract.CONTRACTID != "" {
+			existingBytes, err := stub.GetState(bankcontract.CONTRACTID)
 			if err != nil {
-				fmt.Println("Error unmarshel keys")
-				return nil, errors.New("Error unmarshalling bank keys ")
+				return nil, errors.New("Error retrieving contract " + bankcontract.CONTRACTID)
 			}
-			
-			fmt.Println("Appending the new key to Paper Keys")
-			foundKey := false
-			for _, key := range keys {
-				if key == bankcontract.CONTRACTID {
-					foundKey = true
-				}
-			}
-			if foundKey == false {
-				keys = append(keys, bankcontract.CONTRACTID)
-				keysBytesToWrite, err := json.Marshal(&keys)
-				if err != nil {
-					fmt.Println("Error marshalling keys")
-					return nil, errors.New("Error marshalling the keys")
-				}
-				fmt.Println("Put state on BankKeys")
-				err = stub.PutState("BankKeys", keysBytesToWrite)
-				if err != nil {
-					fmt.Println("Error writting Bank keys back")
-					return nil, errors.New("Error writing the Bank keys back")
-				}
+			if existingBytes != nil {
+				fmt.Println("Bank Contract " + bankcontract.CONTRACTID + " already exists")
+				return nil, errors.New("Bank Contract " + bankcontract.CONTRACTID + " already exists; amend it rather than issuing it again")
 			}
-			fmt.Println("--------------------------------------------------------Everything goes fine--------------------------------------------")
-			fmt.Println("Issue Bank Contract %+v\n", bankcontract)
-			return nil, nil
-		} 
-		return nil, nil
+			fmt.Println("Bank Contract " + bankcontract.CONTRACTID + " not found")
+			return nil, errors.New("Bank Contract " + bankcontract.CONTRACTID + " not found; leave the ID out to issue a new contract")
+		}
+		err = checkDuplicateContract(stub, bankcontract)
+		if err != nil {
+			return nil, err
+		}
+	
+		fmt.Println("Marshalling CP bytes")
+		bankcontract.CONTRACTID, err = nextContractID(stub, bankcontract.BANKID)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Bank contract does not exist, creating it " + bankcontract.CONTRACTID)
+		err = saveContractVersion(stub, bankcontract, "issueBankContract")
+		if err != nil {
+			fmt.Println("Error issuing Bank Contract")
+			return nil, errors.New("Error issuing Bank Contract")
+		}
+		
+		// Index the contract under its bank
+		err = putIndexEntry(stub, contractIndex, bankcontract.BANKID, bankcontract.CONTRACTID)
+		if err != nil {
+			return nil, err
+		}
+		err = setEvent(stub, Event{Type: EventContractIssued, Contract: bankcontract.CONTRACTID, Bank: bankcontract.BANKID, Version: bankcontract.VERSION})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("--------------------------------------------------------Everything goes fine--------------------------------------------")
+		fmt.Printf("Issue Bank Contract %+v\n", bankcontract)
+		return []byte(bankcontract.CONTRACTID), nil
 	}
 	
 //=============================================Upload====================================	
-	func (t *SimpleChaincode) getUploadedDocuments(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	func (t *SimpleChaincode) getUploadedDocuments(stub StateStub, args []string) ([]byte, error) {
 	if len(args) != 1 {
 		fmt.Println("error invalid arguments")
 		return nil, errors.New("Incorrect number of arguments. Expecting commercial paper record")
 	}
-	var cp CP
+	var cp KYCRecord
 	var doc DOCUMENT
 	var err error
 	suffix := "000C"
@@ -857,81 +961,76 @@
 		return nil, errors.New("Invalid commercial paper issue")
 	}
 	
+	// Documents are attached to an existing customer, by the customer or their bank
+	_, err = getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	cp, err = getCustomerRecord(stub, doc.CUSIP)
+	if err != nil {
+		return nil, err
+	}
+	bankcontract, err := contractForRecord(stub, cp)
+	if err != nil {
+		return nil, err
+	}
+	_, err = requireCaller(stub, cp.Issuer, bankcontract.BANKID, cp.Owner)
+	if err != nil {
+		return nil, err
+	}
+	
 	fmt.Println("Marshalling Doc bytes")
 		doc.DID = doc.DID + suffix
 		fmt.Println("Getting State on Documents " + doc.DID)
-		docBytes, err := stub.GetState(doc.DID)
-		if docBytes == nil {
-			fmt.Println("Document does not exist, creating it")
-			docBytes, err := json.Marshal(&doc)
-			if err != nil {
-				fmt.Println("Error marshalling Documents")
-				return nil, errors.New("Error issuing Documents")
-			}
-			err = stub.PutState(doc.DID, docBytes)
-			if err != nil {
-				fmt.Println("Error issuing Documents")
-				return nil, errors.New("Error issuing Documents")
-			}
-			
+		docBytes, err := stub.GetState(docPrefix + doc.DID)
+		if err != nil {
+			fmt.Println("Error retrieving doc " + doc.DID)
+			return nil, errors.New("Error retrieving doc " + doc.DID)
 		}
-//=================================================================	
-// Update the Doc keys by adding the new key
-	fmt.Println("Getting Bank Keys")
-			keysBytes, err := stub.GetState("DocKeys")
-			if err != nil {
-				fmt.Println("Error retrieving doc keys")
-				return nil, errors.New("Error retrieving doc keys")
-			}
-			var keys []string
-			err = json.Unmarshal(keysBytes, &keys)
-			if err != nil {
-				fmt.Println("Error unmarshel keys")
-				return nil, errors.New("Error unmarshalling doc keys ")
-			}
-			
-			fmt.Println("Appending the new key to Paper Keys")
-			foundKey := false
-			for _, key := range keys {
-				if key == doc.DID {
-					foundKey = true
-				}
-			}
-			if foundKey == false {
-				keys = append(keys, doc.DID)
-				keysBytesToWrite, err := json.Marshal(&keys)
-				if err != nil {
-					fmt.Println("Error marshalling keys")
-					return nil, errors.New("Error marshalling the keys")
-				}
-				fmt.Println("Put state on DocKeys")
-				err = stub.PutState("DocKeys", keysBytesToWrite)
-				if err != nil {
-					fmt.Println("Error writting doc keys back")
-					return nil, errors.New("Error writing the doc keys back")
-				}
-			}
-				
-	cp.DOCUMENTS = append(cp.DOCUMENTS, doc)	
-//===============================================================================
-
-fmt.Println("Marshalling CP bytes")
-
-	fmt.Println("Getting State on CP " + cp.CUSIP)
-	uploadBytes1, err := stub.GetState(cp.CUSIP)
-	if uploadBytes1 == nil {
-		fmt.Println("CUSIP does not exist, creating it")
-		uploadBytes1, err := json.Marshal(&cp)
+		if docBytes != nil {
+			fmt.Println("Document already exists " + doc.DID)
+			return nil, errors.New("Document already exists " + doc.DID)
+		}
+		// Only the anchor of the content is written to the ledger
+		err = anchorDocument(t.docStore, &doc)
 		if err != nil {
-			fmt.Println("Error marshalling cp")
-			return nil, errors.New("Error issuing documents record")
+			return nil, err
 		}
-		err = stub.PutState(cp.CUSIP, uploadBytes1)
+		docBytes, err = json.Marshal(&doc)
 		if err != nil {
-			fmt.Println("Error issuing paper")
-			return nil, errors.New("Error issuing documents record")
+			fmt.Println("Error marshalling Documents")
+			return nil, errors.New("Error issuing Documents")
 		}
+		err = stub.PutState(docPrefix+doc.DID, docBytes)
+		if err != nil {
+			fmt.Println("Error issuing Documents")
+			return nil, errors.New("Error issuing Documents")
+		}
+//=================================================================	
+// Index the document under its customer
+	err = putIndexEntry(stub, docIndex, doc.CUSIP, doc.DID)
+	if err != nil {
+		return nil, err
+	}
+				
+	cp.Documents = append(cp.Documents, doc)	
+//===============================================================================
 
+fmt.Println("Marshalling CP bytes")
+	uploadBytes, err := json.Marshal(&cp)
+	if err != nil {
+		fmt.Println("Error marshalling cp")
+		return nil, errors.New("Error issuing documents record")
+	}
+	err = putWithHistory(stub, cpPrefix+cp.CUSIP, uploadBytes, HistoryCUSIP, cp.CUSIP, "getUploadedDocuments")
+	if err != nil {
+		fmt.Println("Error issuing documents record")
+		return nil, errors.New("Error issuing documents record")
+	}
+	
+	err = setEvent(stub, Event{Type: EventDocumentUploaded, CUSIP: cp.CUSIP, Contract: cp.Contract, DocumentID: doc.DID, Hash: doc.HASH})
+	if err != nil {
+		return nil, err
 	}
 	return nil, nil
 }	
@@ -962,6 +1061,10 @@ fmt.Println("Marshalling CP bytes")
 	
 	
 	func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+		return t.query(newShimStub(stub), function, args)
+	}
+
+	func (t *SimpleChaincode) query(stub StateStub, function string, args []string) ([]byte, error) {
 	fmt.Println("----------------in Query------------")
 		//need one arg
 		if len(args) < 1 {
@@ -975,6 +1078,7 @@ fmt.Println("Marshalling CP bytes")
 				fmt.Println("Error from getallcps")
 				return nil, err
 			} else {
+				allCPs = readableKYCRecords(stub, allCPs)
 				allCPsBytes, err1 := json.Marshal(&allCPs)
 				if err1 != nil {
 					fmt.Println("Error marshalling allcps")
@@ -1005,6 +1109,15 @@ fmt.Println("Marshalling CP bytes")
 				fmt.Println("Error Getting particular cp")
 				return nil, err
 			} else {
+				cp = readableKYCRecords(stub, []KYCRecord{cp})[0]
+				// Holders of the bank's key may also decrypt the PII
+				if len(args) > 2 && cp.Encryption != nil {
+					err = decryptPII(&cp, args[2])
+					if err != nil {
+						fmt.Println("Error decrypting the cp")
+						return nil, err
+					}
+				}
 				cpBytes, err1 := json.Marshal(&cp)
 				if err1 != nil {
 					fmt.Println("Error marshalling the cp")
@@ -1013,6 +1126,217 @@ fmt.Println("Marshalling CP bytes")
 				fmt.Println("All success, returning the cp")
 				return cpBytes, nil		 
 			}
+		} else if args[0] == "GetAllDocs" || args[0] == "GetDocsForCustomer" || args[0] == "GetDoc" {
+			fmt.Println("Getting documents for " + args[0])
+			var docs []DOCUMENT
+			var err error
+			switch args[0] {
+			case "GetAllDocs":
+				docs, err = getAllDocs(stub)
+			default:
+				if len(args) < 2 {
+					return nil, errors.New("Incorrect number of arguments. Expecting " + args[0] + " and an ID")
+				}
+				if args[0] == "GetDocsForCustomer" {
+					docs, err = getDocsForCustomer(args[1], stub)
+				} else {
+					var doc DOCUMENT
+					doc, err = getDoc(args[1], stub)
+					docs = []DOCUMENT{doc}
+				}
+			}
+			if err != nil {
+				fmt.Println("Error from " + args[0])
+				return nil, err
+			}
+			// Only those who may read the customer's record see the file
+			reader := newKYCReader(stub)
+			readable := make([]DOCUMENT, 0, len(docs))
+			for _, doc := range docs {
+				readable = append(readable, reader.readableDoc(doc))
+			}
+			var docsBytes []byte
+			if args[0] == "GetDoc" {
+				docsBytes, err = json.Marshal(&readable[0])
+			} else {
+				docsBytes, err = json.Marshal(&readable)
+			}
+			if err != nil {
+				fmt.Println("Error marshalling documents")
+				return nil, err
+			}
+			fmt.Println("All success, returning documents")
+			return docsBytes, nil
+		} else if args[0] == "GetFXRate" {
+			fmt.Println("Getting FX rate")
+			if len(args) != 3 {
+				return nil, errors.New("Incorrect number of arguments. Expecting GetFXRate, base and quote currency")
+			}
+			rate, err := getFXRate(stub, strings.ToUpper(args[1]), strings.ToUpper(args[2]))
+			if err != nil {
+				return nil, err
+			}
+			rateBytes, err := json.Marshal(&rate)
+			if err != nil {
+				fmt.Println("Error marshalling FX rate")
+				return nil, err
+			}
+			return rateBytes, nil
+		} else if args[0] == "GetSettlement" {
+			fmt.Println("Getting settlement")
+			if len(args) != 2 {
+				return nil, errors.New("Incorrect number of arguments. Expecting GetSettlement and the settlement ID")
+			}
+			settlement, err := readSettlement(stub, args[1])
+			if err != nil {
+				return nil, err
+			}
+			settlementBytes, err := json.Marshal(&settlement)
+			if err != nil {
+				fmt.Println("Error marshalling settlement")
+				return nil, err
+			}
+			return settlementBytes, nil
+		} else if args[0] == "GetExpiringKYC" {
+			fmt.Println("Getting expiring KYC records")
+			records, err := getExpiringKYC(stub, args[1:])
+			if err != nil {
+				return nil, err
+			}
+			recordsBytes, err := json.Marshal(&records)
+			if err != nil {
+				fmt.Println("Error marshalling expiring records")
+				return nil, err
+			}
+			return recordsBytes, nil
+		} else if args[0] == "GetConsents" {
+			fmt.Println("Getting consents")
+			consents, err := readConsents(stub, args[1:])
+			if err != nil {
+				return nil, err
+			}
+			consentsBytes, err := json.Marshal(&consents)
+			if err != nil {
+				fmt.Println("Error marshalling consents")
+				return nil, err
+			}
+			return consentsBytes, nil
+		} else if args[0] == "GetSharedKYC" {
+			fmt.Println("Getting shared KYC record")
+			shared, err := readSharedKYC(stub, args[1:])
+			if err != nil {
+				return nil, err
+			}
+			sharedBytes, err := json.Marshal(&shared)
+			if err != nil {
+				fmt.Println("Error marshalling shared record")
+				return nil, err
+			}
+			return sharedBytes, nil
+		} else if args[0] == "GetCommissionStatement" {
+			fmt.Println("Getting commission statement")
+			statements, err := getCommissionStatement(stub, args[1:])
+			if err != nil {
+				return nil, err
+			}
+			statementsBytes, err := json.Marshal(&statements)
+			if err != nil {
+				fmt.Println("Error marshalling commission statement")
+				return nil, err
+			}
+			return statementsBytes, nil
+		} else if args[0] == "GetContractsForBank" {
+			fmt.Println("Getting contracts for bank")
+			if len(args) != 2 {
+				return nil, errors.New("Incorrect number of arguments. Expecting GetContractsForBank and the bank ID")
+			}
+			contracts, err := getContractsForBank(stub, args[1])
+			if err != nil {
+				return nil, err
+			}
+			contractsBytes, err := json.Marshal(&contracts)
+			if err != nil {
+				fmt.Println("Error marshalling contracts for bank")
+				return nil, err
+			}
+			return contractsBytes, nil
+		} else if args[0] == "GetContractVersion" {
+			fmt.Println("Getting contract version")
+			if len(args) != 3 {
+				return nil, errors.New("Incorrect number of arguments. Expecting GetContractVersion, contract ID and version")
+			}
+			version, err := strconv.Atoi(args[2])
+			if err != nil {
+				return nil, errors.New("Invalid contract version " + args[2])
+			}
+			bankcontract, err := getBankContractVersion(stub, args[1], version)
+			if err != nil {
+				return nil, err
+			}
+			contractBytes, err := json.Marshal(&bankcontract)
+			if err != nil {
+				fmt.Println("Error marshalling contract version")
+				return nil, err
+			}
+			return contractBytes, nil
+		} else if args[0] == "GetHistory" {
+			fmt.Println("Getting history")
+			if len(args) != 3 {
+				return nil, errors.New("Incorrect number of arguments. Expecting GetHistory, subject (cusip, account or contract) and ID")
+			}
+			history, err := getHistory(stub, args[1], args[2])
+			if err != nil {
+				fmt.Println("Error from getHistory")
+				return nil, err
+			}
+			history = readableHistory(stub, args[1], args[2], history)
+			historyBytes, err := json.Marshal(&history)
+			if err != nil {
+				fmt.Println("Error marshalling history")
+				return nil, err
+			}
+			fmt.Println("All success, returning history")
+			return historyBytes, nil
+		} else if args[0] == "verifyDocument" {
+			fmt.Println("Verifying document content")
+			if len(args) != 3 {
+				return nil, errors.New("Incorrect number of arguments. Expecting verifyDocument, document ID and base64 content")
+			}
+			verification, err := verifyDocument(stub, args[1], args[2])
+			if err != nil {
+				fmt.Println("Error verifying document")
+				return nil, err
+			}
+			verificationBytes, err := json.Marshal(&verification)
+			if err != nil {
+				fmt.Println("Error marshalling verification")
+				return nil, err
+			}
+			fmt.Println("All success, returning verification")
+			return verificationBytes, nil
+		} else if args[0] == "QueryCPs" || args[0] == "QueryContracts" || args[0] == "QueryDocs" {
+			fmt.Println("Getting a page of results for " + args[0])
+			var page Page
+			var err error
+			switch args[0] {
+			case "QueryCPs":
+				page, err = queryCPs(stub, args[1:])
+			case "QueryContracts":
+				page, err = queryContracts(stub, args[1:])
+			default:
+				page, err = queryDocs(stub, args[1:])
+			}
+			if err != nil {
+				fmt.Println("Error from " + args[0])
+				return nil, err
+			}
+			pageBytes, err := json.Marshal(&page)
+			if err != nil {
+				fmt.Println("Error marshalling page")
+				return nil, err
+			}
+			fmt.Println("All success, returning page")
+			return pageBytes, nil
 		}	else if args[0] == "GetCompany" {
 			fmt.Println("Getting the company")
 			company, err := GetCompany(args[1], stub)
@@ -1030,6 +1354,31 @@ fmt.Println("Marshalling CP bytes")
 			}
 		} else {
 			fmt.Println("Generic Query call")
+			// KYC records hold personal data and are only read through GetCP
+			if strings.HasPrefix(args[0], cpPrefix) {
+				fmt.Println("Use GetCP to read " + args[0])
+				return nil, errors.New("Use GetCP to read " + args[0])
+			}
+			if strings.HasPrefix(args[0], docPrefix) {
+				fmt.Println("Use GetDoc to read " + args[0])
+				return nil, errors.New("Use GetDoc to read " + args[0])
+			}
+			if strings.HasPrefix(args[0], escrowPrefix) {
+				fmt.Println("Use GetSettlement to read " + args[0])
+				return nil, errors.New("Use GetSettlement to read " + args[0])
+			}
+			if strings.HasPrefix(args[0], consentPrefix) || strings.HasPrefix(args[0], compositeKeyNamespace+consentAccessObject) {
+				fmt.Println("Use GetConsents to read " + args[0])
+				return nil, errors.New("Use GetConsents to read " + args[0])
+			}
+			if strings.HasPrefix(args[0], compositeKeyNamespace+commissionObject) || strings.HasPrefix(args[0], compositeKeyNamespace+commissionVolumeObject) {
+				fmt.Println("Use GetCommissionStatement to read " + args[0])
+				return nil, errors.New("Use GetCommissionStatement to read " + args[0])
+			}
+			if strings.HasPrefix(args[0], compositeKeyNamespace+historyObject) {
+				fmt.Println("Use GetHistory to read " + args[0])
+				return nil, errors.New("Use GetHistory to read " + args[0])
+			}
 			bytes, err := stub.GetState(args[0])
 
 			if err != nil {
@@ -1044,39 +1393,57 @@ fmt.Println("Marshalling CP bytes")
 		return nil, nil		//Added by ankit
 	}
 
-		func GetAllContracts(stub *shim.ChaincodeStub) ([]BANKCONTRACT, error){
-	fmt.Println("--------------In GetAllCPs-------------")	
-		var allContracts []BANKCONTRACT
-		
-		// Get list of all the keys
-		keysBytes, err := stub.GetState("BankKeys")
+	func getBankContract(stub StateStub, contractID string) (BANKCONTRACT, error) {
+		var bankcontract BANKCONTRACT
+		fmt.Println("Getting state of Bank Contract- " + contractID)
+		contractBytes, err := stub.GetState(contractID)
 		if err != nil {
-			fmt.Println("Error retrieving Bank keys in GetAllContracts")
-			return nil, errors.New("Error retrieving Bank keys in GetAllContracts")
+			fmt.Println("Error Getting state of Contract - " + contractID)
+			return bankcontract, errors.New("Error retrieving contract " + contractID)
 		}
-		var keys []string
-		err = json.Unmarshal(keysBytes, &keys) 	
+		err = json.Unmarshal(contractBytes, &bankcontract)
 		if err != nil {
-			fmt.Println("Error unmarshalling Bank keys in GetAllContracts")
-			fmt.Println(err)
-			return nil, errors.New("Error unmarshalling Bank keys in GetAllContracts")
+			fmt.Println("Error Unmarshalling Bank Contract")
+			return bankcontract, errors.New("Error retrieving Bank Contract " + contractID)
 		}
+		return bankcontract, nil
+	}
+	
+	// splitValidators splits BANKVALIDATORS into validator IDs, ignoring blanks.
+	func splitValidators(bankValidators string) []string {
+		var validators []string
+		for _, validator := range strings.Split(bankValidators, ",") {
+			validator = strings.TrimSpace(validator)
+			if validator != "" {
+				validators = append(validators, validator)
+			}
+		}
+		return validators
+	}
 
-		// Get all the cps
-		for _, value := range keys {
-			fmt.Println("------------------------Keys-----------------"+value)
-			bankcontractBytes, err := stub.GetState(value)
+		func GetAllContracts(stub StateStub) ([]BANKCONTRACT, error){
+	fmt.Println("--------------In GetAllCPs-------------")	
+		var allContracts []BANKCONTRACT
+		
+		// Get all the contracts through the contract index
+		err := forEachIndexed(stub, contractIndex, nil, func(contractID string) error {
+			fmt.Println("------------------------Keys-----------------"+contractID)
+			bankcontractBytes, err := stub.GetState(contractID)
 			
 			var bankcontract BANKCONTRACT
 			err = json.Unmarshal(bankcontractBytes, &bankcontract)
 			if err != nil {
-				fmt.Println("Error retrieving BANK CONTRACT " + value)
-				return nil, errors.New("Error retrieving BANK CONTRACT " + value)
+				fmt.Println("Error retrieving BANK CONTRACT " + contractID)
+				return errors.New("Error retrieving BANK CONTRACT " + contractID)
 			}
 			
-			fmt.Println("Appending BANK CONTRACT" + value)
+			fmt.Println("Appending BANK CONTRACT" + contractID)
 			allContracts = append(allContracts, bankcontract)
-		}	
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 		fmt.Println("-----------------------Everything goes fine in GetAllContracts------------------")
 		return allContracts, nil 
 	}
@@ -1084,10 +1451,14 @@ fmt.Println("Marshalling CP bytes")
 	
 	func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
 		fmt.Println("run is running " + function)
-		return t.Invoke(stub, function, args)
+		return t.invoke(newShimStub(stub), function, args)
 	}
 
 	func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+		return t.invoke(newShimStub(stub), function, args)
+	}
+
+	func (t *SimpleChaincode) invoke(stub StateStub, function string, args []string) ([]byte, error) {
 		fmt.Println("invoke is running " + function)
 		
 		if function == "issueCommercialPaper" {
@@ -1102,9 +1473,48 @@ fmt.Println("Marshalling CP bytes")
 			fmt.Println("Firing issueBankContract")
 			//Create an asset with some value
 			return t.issueBankContract(stub, args)
+		} else if function == "amendBankContract" {
+			fmt.Println("Firing amendBankContract")
+			return t.amendBankContract(stub, args)
+		} else if function == "suspendBankContract" {
+			fmt.Println("Firing suspendBankContract")
+			return t.suspendBankContract(stub, args)
+		} else if function == "reinstateBankContract" {
+			fmt.Println("Firing reinstateBankContract")
+			return t.reinstateBankContract(stub, args)
+		} else if function == "terminateBankContract" {
+			fmt.Println("Firing terminateBankContract")
+			return t.terminateBankContract(stub, args)
+		} else if function == "renewKYC" {
+			fmt.Println("Firing renewKYC")
+			return t.renewKYC(stub, args)
+		} else if function == "expireKYC" {
+			fmt.Println("Firing expireKYC")
+			return t.expireKYC(stub, args)
+		} else if function == "grantConsent" {
+			fmt.Println("Firing grantConsent")
+			return t.grantConsent(stub, args)
+		} else if function == "revokeConsent" {
+			fmt.Println("Firing revokeConsent")
+			return t.revokeConsent(stub, args)
+		} else if function == "accessKYC" {
+			fmt.Println("Firing accessKYC")
+			return t.accessKYC(stub, args)
 		} else if function == "transferPaper" {
 			fmt.Println("Firing cretransferPaperateAccounts")
 			return t.transferPaper(stub, args)
+		} else if function == "rejectKYC" {
+			fmt.Println("Firing rejectKYC")
+			return t.rejectKYC(stub, args)
+		} else if function == "confirmSettlement" {
+			fmt.Println("Firing confirmSettlement")
+			return t.confirmSettlement(stub, args)
+		} else if function == "cancelSettlement" {
+			fmt.Println("Firing cancelSettlement")
+			return t.cancelSettlement(stub, args)
+		} else if function == "setFXRate" {
+			fmt.Println("Firing setFXRate")
+			return t.setFXRate(stub, args)
 		} else if function == "createAccounts" {
 			fmt.Println("Firing createAccounts")
 			return t.createAccounts(stub, args)
@@ -1113,7 +1523,14 @@ fmt.Println("Marshalling CP bytes")
 			return t.createAccount(stub, args)
 		} else if function == "init" {
 			fmt.Println("Firing init")
-			return t.Init(stub, "init", args)
+			caller, err := getCaller(stub)
+			if err != nil {
+				return nil, err
+			}
+			if caller.Role != RoleAdmin {
+				return nil, errors.New("Only an admin can reinitialize the chaincode")
+			}
+			return t.init(stub, args)
 		} 
 
 		return nil, errors.New("Received unknown function invocation")
@@ -1122,68 +1539,6 @@ fmt.Println("Marshalling CP bytes")
 	func main() {
 		err := shim.Start(new(SimpleChaincode))
 		if err != nil {
-			fmt.Println("Error starting Simple chaincode: %s", err)
+			fmt.Printf("Error starting Simple chaincode: %s\n", err)
 		}
 	}
-
-	//lookup tables for last two digits of CUSIP
-	var seventhDigit = map[int]string{
-		1:  "A",
-		2:  "B",
-		3:  "C",
-		4:  "D",
-		5:  "E",
-		6:  "F",
-		7:  "G",
-		8:  "H",
-		9:  "J",
-		10: "K",
-		11: "L",
-		12: "M",
-		13: "N",
-		14: "P",
-		15: "Q",
-		16: "R",
-		17: "S",
-		18: "T",
-		19: "U",
-		20: "V",
-		21: "W",
-		22: "X",
-		23: "Y",
-		24: "Z",
-	}
-
-	var eigthDigit = map[int]string{
-		1:  "1",
-		2:  "2",
-		3:  "3",
-		4:  "4",
-		5:  "5",
-		6:  "6",
-		7:  "7",
-		8:  "8",
-		9:  "9",
-		10: "A",
-		11: "B",
-		12: "C",
-		13: "D",
-		14: "E",
-		15: "F",
-		16: "G",
-		17: "H",
-		18: "J",
-		19: "K",
-		20: "L",
-		21: "M",
-		22: "N",
-		23: "P",
-		24: "Q",
-		25: "R",
-		26: "S",
-		27: "T",
-		28: "U",
-		29: "V",
-		30: "W",
-		31: "X",
-}
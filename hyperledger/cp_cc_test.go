@@ -0,0 +1,1205 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func invoke(t *testing.T, cc *SimpleChaincode, stub *MockStub, function string, args ...string) {
+	t.Helper()
+	if _, err := cc.invoke(stub, function, args); err != nil {
+		t.Fatalf("%s: %v", function, err)
+	}
+}
+
+func query(t *testing.T, cc *SimpleChaincode, stub *MockStub, v interface{}, args ...string) {
+	t.Helper()
+	out, err := cc.query(stub, "query", args)
+	if err != nil {
+		t.Fatalf("%s: %v", args[0], err)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		t.Fatalf("%s: decoding %s: %v", args[0], out, err)
+	}
+}
+
+// as makes the following transactions come from the given account: "admin"
+// is the network admin, bankN acts as its own bank admin, validatorN as a
+// validator and anything else as a customer.
+func as(stub *MockStub, id string) *MockStub {
+	switch {
+	case id == "admin":
+		return stub.As(id, RoleAdmin, "")
+	case strings.HasPrefix(id, "bank"):
+		return stub.As(id+"-admin", RoleBankAdmin, id)
+	case strings.HasPrefix(id, "validator"):
+		return stub.As(id, RoleValidator, "")
+	}
+	return stub.As(id, RoleCustomer, "")
+}
+
+// newKYCLedger returns a ledger holding one bank contract with two validators
+// and the accounts taking part in it.
+func newKYCLedger(t *testing.T) (*SimpleChaincode, *MockStub) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	invoke(t, cc, as(stub, "admin"), "init")
+	for _, id := range []string{"bank1", "company1", "validator1", "validator2"} {
+		invoke(t, cc, as(stub, id), "createAccount", id)
+	}
+	invoke(t, cc, as(stub, "bank1"), "issueBankContract", `{"bID":"bank1","bName":"First Bank","bValidators":"validator1,validator2","bCommission":"50"}`)
+	return cc, stub
+}
+
+const testKYC = `{"contract":"bank1000C","name":"Asha Rao","gender":"F","dateOfBirth":"1990-04-21","city":"Pune","state":"MH","issuer":"company1","issueDate":"1456161763790"}`
+
+func TestCreateAccount(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	invoke(t, cc, as(stub, "bank1"), "createAccount", "bank1")
+	invoke(t, cc, as(stub, "company1"), "createAccount", "company1")
+
+	var bank, company Account
+	query(t, cc, stub, &bank, "GetCompany", "bank1")
+	query(t, cc, stub, &company, "GetCompany", "company1")
+	if bank.Prefix != "bank1000A" || bank.balance(defaultCurrency).String() != "1000000.00 INR" {
+		t.Errorf("unexpected bank account %+v", bank)
+	}
+	if company.balance(defaultCurrency).String() != "100.00 INR" {
+		t.Errorf("unexpected company account %+v", company)
+	}
+
+	if _, err := cc.invoke(as(stub, "bank1"), "createAccount", []string{"bank1"}); err == nil {
+		t.Error("expected an error re-creating an existing account")
+	}
+}
+
+func TestIssueBankContract(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+
+	var contracts []BANKCONTRACT
+	query(t, cc, stub, &contracts, "GetAllContracts")
+	if len(contracts) != 1 || contracts[0].CONTRACTID != "bank1000C" || contracts[0].BANKVALIDATORS != "validator1,validator2" {
+		t.Fatalf("unexpected contracts %+v", contracts)
+	}
+}
+
+func TestMultipleContractsPerBank(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "bank2"), "createAccount", "bank2")
+	const corporate = `{"contractName":"corporate","bID":"bank1","bName":"First Bank","bValidators":"validator2","bCommission":"80"}`
+	out, err := cc.invoke(as(stub, "bank1"), "issueBankContract", []string{corporate})
+	if err != nil || string(out) != "bank1001C" {
+		t.Fatalf("expected the second contract to be bank1001C, got %q, %v", out, err)
+	}
+	// Contracts are told apart by name, not terms
+	treasury := strings.Replace(corporate, `"corporate"`, `"treasury"`, 1)
+	out, err = cc.invoke(as(stub, "bank1"), "issueBankContract", []string{treasury})
+	if err != nil || string(out) != "bank1002C" {
+		t.Fatalf("expected a contract on the same terms to be bank1002C, got %q, %v", out, err)
+	}
+
+	for name, contract := range map[string]string{
+		"same name":   `{"contractName":"Corporate","bID":"bank1","bValidators":"validator1","bCommission":"90"}`,
+		"existing ID": `{"conractid":"bank1000C","bID":"bank1","bValidators":"validator1","bCommission":"90"}`,
+		"unknown ID":  `{"conractid":"bank1099C","bID":"bank1","bValidators":"validator1","bCommission":"90"}`,
+	} {
+		before := stub.Snapshot()
+		if _, err := cc.invoke(as(stub, "bank1"), "issueBankContract", []string{contract}); err == nil {
+			t.Errorf("%s: expected the submission to be refused", name)
+		}
+		if !reflect.DeepEqual(before, stub.Snapshot()) {
+			t.Errorf("%s: refused submission changed the ledger", name)
+		}
+	}
+
+	var contracts []BANKCONTRACT
+	query(t, cc, stub, &contracts, "GetContractsForBank", "bank1")
+	if len(contracts) != 3 || contracts[0].CONTRACTID != "bank1000C" || contracts[1].CONTRACTID != "bank1001C" ||
+		contracts[1].BANKVALIDATORS != "validator2" || contracts[2].CONTRACTNAME != "treasury" {
+		t.Errorf("unexpected contracts %+v", contracts)
+	}
+	query(t, cc, stub, &contracts, "GetContractsForBank", "bank2")
+	if len(contracts) != 0 {
+		t.Errorf("expected bank2 to hold no contracts, got %+v", contracts)
+	}
+
+	// Customers onboarded under the second contract follow its terms
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", strings.Replace(testKYC, "bank1000C", "bank1001C", 1))
+	invoke(t, cc, as(stub, "validator2"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator2"}`)
+	var issuer Account
+	query(t, cc, stub, &issuer, "GetCompany", "company1")
+	if issuer.balance(defaultCurrency).String() != "180.00 INR" {
+		t.Errorf("expected the corporate commission, issuer has %v", issuer.Balances)
+	}
+}
+
+func TestIssueCommercialPaper(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+
+	var records []KYCRecord
+	query(t, cc, stub, &records, "GetAllCPs")
+	if len(records) != 1 {
+		t.Fatalf("expected one record, got %d", len(records))
+	}
+	record := records[0]
+	if record.CUSIP != "company1000A00" {
+		t.Errorf("unexpected CUSIP %q", record.CUSIP)
+	}
+	if record.Owner != "validator1" || record.Status != StatusSubmitted {
+		t.Errorf("expected a submitted record with the first validator, got %q %q", record.Owner, record.Status)
+	}
+	if record.Name != "Asha Rao" || record.Gender != "F" || record.DateOfBirth.Format(dateLayout) != "1990-04-21" {
+		t.Errorf("customer fields not stored: %+v", record)
+	}
+
+	// CUSIPs are numbered in sequence, so they carry no customer data and a
+	// second record for the same customer gets its own
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+	query(t, cc, stub, &records, "GetAllCPs")
+	if len(records) != 2 || records[1].CUSIP != "company1000A01" {
+		t.Fatalf("expected a second record company1000A01, got %+v", records)
+	}
+	var issuer Account
+	query(t, cc, stub, &issuer, "GetCompany", "company1")
+	if len(issuer.AssetsIds) != 2 || issuer.AssetsIds[0] != "company1000A00" || issuer.AssetsIds[1] != "company1000A01" {
+		t.Errorf("unexpected issuer records %v", issuer.AssetsIds)
+	}
+
+	// Numbers taken by records issued before numbers were allocated are skipped
+	stub.State[cpPrefix+"company1000A02"] = stub.State[cpPrefix+"company1000A00"]
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+	if stub.State[cpPrefix+"company1000A03"] == nil {
+		t.Error("expected the next record to skip company1000A02")
+	}
+}
+
+func TestTransferPaper(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+
+	invoke(t, cc, as(stub, "validator1"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator1"}`)
+	var record KYCRecord
+	query(t, cc, stub, &record, "GetCP", cpPrefix+"company1000A00")
+	if record.Owner != "validator2" || record.Status != StatusUnderReview {
+		t.Fatalf("expected record under review with validator2, got %q %q", record.Owner, record.Status)
+	}
+
+	invoke(t, cc, as(stub, "validator2"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator2"}`)
+	query(t, cc, stub, &record, "GetCP", cpPrefix+"company1000A00")
+	if record.Owner != "bank1" || record.Status != StatusVerified {
+		t.Fatalf("expected verified record with bank1, got %q %q", record.Owner, record.Status)
+	}
+	if len(record.SignOffs) != 2 || record.SignOffs[0].Validator != "validator1" || record.SignOffs[1].Validator != "validator2" {
+		t.Errorf("unexpected sign-offs %+v", record.SignOffs)
+	}
+
+	var bank, issuer Account
+	query(t, cc, stub, &bank, "GetCompany", "bank1")
+	query(t, cc, stub, &issuer, "GetCompany", "company1")
+	if bank.balance(defaultCurrency).String() != "999950.00 INR" || issuer.balance(defaultCurrency).String() != "150.00 INR" {
+		t.Errorf("commission not paid: bank %v, issuer %v", bank.Balances, issuer.Balances)
+	}
+}
+
+func TestTransferPaperFailuresLeaveNoTrace(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+	const approve = `{"cusip":"company1000A00","fromCompany":"%s"}`
+
+	expectNoTrace := func(name string, validator string) {
+		t.Helper()
+		before := stub.Snapshot()
+		if _, err := cc.invoke(as(stub, validator), "transferPaper", []string{fmt.Sprintf(approve, validator)}); err == nil {
+			t.Errorf("%s: expected the transfer to fail", name)
+		}
+		if !reflect.DeepEqual(before, stub.Snapshot()) {
+			t.Errorf("%s: failed transfer changed the ledger", name)
+		}
+	}
+
+	expectNoTrace("not the owner", "validator2")
+	invoke(t, cc, as(stub, "validator1"), "transferPaper", fmt.Sprintf(approve, "validator1"))
+
+	// the record follows the contract version it was onboarded under
+	version, err := contractVersionKey("bank1000C", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := stub.State[version]
+	stub.State[version] = bytes.Replace(contract, []byte(`"bCommission":"50.00 INR"`), []byte(`"bCommission":"fifty"`), 1)
+	expectNoTrace("unparseable commission", "validator2")
+	stub.State[version] = contract
+
+	bank := stub.State[accountPrefix+"bank1"]
+	poor, err := json.Marshal(&Account{ID: "bank1", Prefix: "bank1000A", Balances: Balances{defaultCurrency: wholeMoney(10, defaultCurrency)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.State[accountPrefix+"bank1"] = poor
+	expectNoTrace("insufficient funds", "validator2")
+	stub.State[accountPrefix+"bank1"] = bank
+
+	invoke(t, cc, as(stub, "validator2"), "transferPaper", fmt.Sprintf(approve, "validator2"))
+}
+
+func TestTransferPaperAnyTwoOfThree(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	invoke(t, cc, as(stub, "admin"), "init")
+	for _, id := range []string{"bank2", "company1", "validator1", "validator2", "validator3"} {
+		invoke(t, cc, as(stub, id), "createAccount", id)
+	}
+	invoke(t, cc, as(stub, "bank2"), "issueBankContract", `{"bID":"bank2","bName":"Second Bank","validators":["validator1","validator2","validator3"],"approvalPolicy":{"threshold":2},"bCommission":"50"}`)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", `{"contract":"bank2000C","name":"Asha Rao","dateOfBirth":"1990-04-21","issuer":"company1","issueDate":"1456161763790"}`)
+
+	var contract BANKCONTRACT
+	query(t, cc, stub, &contract, "bank2000C")
+	if contract.BANKVALIDATORS != "validator1,validator2,validator3" {
+		t.Errorf("expected the validator list to be mirrored, got %q", contract.BANKVALIDATORS)
+	}
+
+	invoke(t, cc, as(stub, "validator3"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator3"}`)
+	if _, err := cc.invoke(as(stub, "validator3"), "transferPaper", []string{`{"cusip":"company1000A00","fromCompany":"validator3"}`}); err == nil {
+		t.Error("expected a second approval by the same validator to fail")
+	}
+	var record KYCRecord
+	query(t, cc, stub, &record, "GetCP", cpPrefix+"company1000A00")
+	if record.Owner != "bank2000C" || record.Status != StatusUnderReview {
+		t.Fatalf("expected the record to wait for another approval, got %q %q", record.Owner, record.Status)
+	}
+
+	invoke(t, cc, as(stub, "validator1"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator1"}`)
+	query(t, cc, stub, &record, "GetCP", cpPrefix+"company1000A00")
+	if record.Owner != "bank2" || record.Status != StatusVerified {
+		t.Fatalf("expected the record delivered after two approvals, got %q %q", record.Owner, record.Status)
+	}
+}
+
+func TestIssueBankContractValidatesPolicy(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	invoke(t, cc, as(stub, "admin"), "init")
+	for _, contract := range []string{
+		`{"bID":"bank1","bValidators":""}`,
+		`{"bID":"bank1","validators":["v1","v1"]}`,
+		`{"bID":"bank1","validators":["v1","v2"],"approvalPolicy":{"threshold":3}}`,
+		`{"bID":"bank1","validators":["v1"],"commissionSchedule":{"type":"bonus","amount":"50"}}`,
+		`{"bID":"bank1","validators":["v1"],"commissionSchedule":{"type":"percentage","rate":"150"}}`,
+		`{"bID":"bank1","validators":["v1"],"commissionSchedule":{"type":"tiered","tiers":[{"upTo":10,"amount":"50"},{"upTo":5,"amount":"40"}]}}`,
+		`{"bID":"bank1","validators":["v1"],"commissionSchedule":{"type":"flat","amount":"50","split":[{"validator":"v2","share":"10"}]}}`,
+		`{"bID":"bank1","validators":["v1","v2"],"commissionSchedule":{"type":"flat","amount":"50","split":[{"validator":"v1","share":"60"},{"validator":"v2","share":"50"}]}}`,
+		`{"bID":"bank1","validators":["v1"],"escrowHours":24,"commissionSchedule":{"type":"flat","amount":"50","split":[{"validator":"v1","share":"10"}]}}`,
+	} {
+		if _, err := cc.invoke(as(stub, "bank1"), "issueBankContract", []string{contract}); err == nil {
+			t.Errorf("expected %s to be refused", contract)
+		}
+	}
+}
+
+func TestInvokesCheckCallerIdentity(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	for _, tc := range []struct {
+		name     string
+		caller   string
+		function string
+		arg      string
+	}{
+		{"anonymous issue", "", "issueCommercialPaper", testKYC},
+		{"issue for another customer", "company2", "issueCommercialPaper", testKYC},
+		{"contract for another bank", "bank2", "issueBankContract", `{"bID":"bank1","bValidators":"validator1"}`},
+		{"account for another user", "company1", "createAccount", "company2"},
+		{"bulk accounts", "bank1", "createAccounts", "3"},
+	} {
+		caller := stub.As("", "", "")
+		if tc.caller != "" {
+			caller = as(stub, tc.caller)
+		}
+		if _, err := cc.invoke(caller, tc.function, []string{tc.arg}); err == nil {
+			t.Errorf("%s: expected the caller to be refused", tc.name)
+		}
+	}
+
+	invoke(t, cc, as(stub, "bank1"), "issueCommercialPaper", testKYC)
+	if _, err := cc.invoke(as(stub, "validator2"), "transferPaper", []string{`{"cusip":"company1000A00","fromCompany":"validator1"}`}); err == nil {
+		t.Error("expected validator2 not to approve as validator1")
+	}
+	if _, err := cc.invoke(stub.As("validator1", RoleCustomer, ""), "transferPaper", []string{`{"cusip":"company1000A00","fromCompany":"validator1"}`}); err == nil {
+		t.Error("expected a caller without the validator role not to approve")
+	}
+}
+
+func TestGetCPRedactsPII(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+
+	for _, id := range []string{"company1", "bank1", "validator2"} {
+		var record KYCRecord
+		query(t, cc, as(stub, id), &record, "GetCP", cpPrefix+"company1000A00")
+		if record.Name != "Asha Rao" {
+			t.Errorf("expected %s to read the customer's name", id)
+		}
+	}
+	for _, id := range []string{"company2", "bank2"} {
+		var record KYCRecord
+		query(t, cc, as(stub, id), &record, "GetCP", cpPrefix+"company1000A00")
+		if record.Name != "" || record.City != "" || record.Status != StatusSubmitted {
+			t.Errorf("expected %s to see only the redacted record, got %+v", id, record)
+		}
+	}
+	if _, err := cc.query(as(stub, "company2"), "query", []string{cpPrefix + "company1000A00"}); err == nil {
+		t.Error("expected a raw read of a KYC record to be refused")
+	}
+}
+
+func TestPIIEncryptedForBank(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	key := bytes.Repeat([]byte{7}, 32)
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+	invoke(t, cc, as(stub, "bank2"), "createAccount", "bank2")
+	invoke(t, cc, as(stub, "bank2"), "issueBankContract", `{"bID":"bank2","bValidators":"validator1","bCommission":"20","piiKeyFingerprint":"`+keyFingerprint(key)+`"}`)
+	const kyc = `{"contract":"bank2000C","name":"Asha Rao","gender":"F","dateOfBirth":"1990-04-21","city":"Pune","phone":"555-0100","issuer":"company1"}`
+
+	if _, err := cc.invoke(as(stub, "company1"), "issueCommercialPaper", []string{kyc}); err == nil {
+		t.Error("expected issuing without the bank's key to fail")
+	}
+	if _, err := cc.invoke(as(stub, "company1"), "issueCommercialPaper", []string{kyc, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32))}); err == nil {
+		t.Error("expected issuing with the wrong key to fail")
+	}
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", kyc, encodedKey)
+
+	stored := stub.State[cpPrefix+"company1000A00"]
+	if bytes.Contains(stored, []byte("Asha Rao")) || bytes.Contains(stored, []byte("555-0100")) ||
+		bytes.Contains(stored, []byte("1990-04-21")) || bytes.Contains(stored, []byte(`"gender":"F"`)) {
+		t.Fatalf("expected PII to be encrypted at rest, got %s", stored)
+	}
+
+	var record KYCRecord
+	query(t, cc, as(stub, "bank2"), &record, "GetCP", cpPrefix+"company1000A00")
+	if !strings.HasPrefix(record.Name, encryptedPrefix) || !record.DateOfBirth.IsZero() || record.City != "Pune" || record.Status != StatusSubmitted {
+		t.Errorf("expected only the PII to stay encrypted without the key, got %+v", record)
+	}
+	var decrypted KYCRecord
+	query(t, cc, as(stub, "bank2"), &decrypted, "GetCP", cpPrefix+"company1000A00", encodedKey)
+	if decrypted.Name != "Asha Rao" || decrypted.Gender != "F" || decrypted.DateOfBirth.Format(dateLayout) != "1990-04-21" ||
+		decrypted.Phone != "555-0100" || decrypted.Encryption != nil {
+		t.Errorf("expected the key holder to read the PII, got %+v", decrypted)
+	}
+	record = KYCRecord{}
+	query(t, cc, as(stub, "bank1"), &record, "GetCP", cpPrefix+"company1000A00", encodedKey)
+	if record.Name != "" {
+		t.Errorf("expected a bank without access to see the redacted record, got %+v", record)
+	}
+}
+
+func TestRejectKYC(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+
+	if _, err := cc.invoke(as(stub, "validator1"), "rejectKYC", []string{`{"cusip":"company1000A00","validator":"validator1"}`}); err == nil {
+		t.Error("expected a rejection without a reason to fail")
+	}
+	if _, err := cc.invoke(as(stub, "validator2"), "rejectKYC", []string{`{"cusip":"company1000A00","validator":"validator2","reason":"blurred"}`}); err == nil {
+		t.Error("expected a rejection by a validator not reviewing the record to fail")
+	}
+	invoke(t, cc, as(stub, "validator1"), "rejectKYC", `{"cusip":"company1000A00","validator":"validator1","reason":"address proof expired"}`)
+
+	var record KYCRecord
+	query(t, cc, stub, &record, "GetCP", cpPrefix+"company1000A00")
+	if record.Status != StatusRejected || record.StatusReason != "address proof expired" {
+		t.Fatalf("expected a rejected record, got %q %q", record.Status, record.StatusReason)
+	}
+	if len(record.SignOffs) != 1 || record.SignOffs[0].Decision != DecisionRejected {
+		t.Errorf("unexpected sign-offs %+v", record.SignOffs)
+	}
+
+	if _, err := cc.invoke(as(stub, "validator1"), "transferPaper", []string{`{"cusip":"company1000A00","fromCompany":"validator1"}`}); err == nil {
+		t.Error("expected a rejected record not to move on")
+	}
+}
+
+func TestKYCTransitions(t *testing.T) {
+	record := KYCRecord{CUSIP: "company1000A00", Status: StatusVerified}
+	if err := record.transition(StatusUnderReview, ""); err == nil {
+		t.Error("expected VERIFIED to UNDER_REVIEW to be refused")
+	}
+	if err := record.transition(StatusExpired, ""); err != nil || record.Status != StatusExpired {
+		t.Errorf("expected VERIFIED to EXPIRED to be allowed: %v", err)
+	}
+	if err := record.transition(StatusSubmitted, ""); err != nil || record.Status != StatusSubmitted {
+		t.Errorf("expected EXPIRED to SUBMITTED to be allowed for renewal: %v", err)
+	}
+}
+
+// testDoc anchors the content "data", stored off-chain by the client.
+const (
+	testDocHash = "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+	testDoc     = `{"id":"doc1","cusip":"company1000A00","dID":"passport-1","documents":"passport","hash":"` + testDocHash + `","size":4,"mimeType":"application/pdf","uri":"s3://kyc-docs/passport-1"}`
+)
+
+func TestGetUploadedDocuments(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	if _, err := cc.invoke(as(stub, "company1"), "getUploadedDocuments", []string{testDoc}); err == nil {
+		t.Fatal("expected a document for an unknown customer to be refused")
+	}
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+	invoke(t, cc, as(stub, "company1"), "getUploadedDocuments", testDoc)
+	if _, err := cc.invoke(as(stub, "company1"), "getUploadedDocuments", []string{testDoc}); err == nil {
+		t.Error("expected a duplicate document to be refused")
+	}
+	if _, ok := stub.State[""]; ok {
+		t.Error("expected nothing to be written under an empty key")
+	}
+
+	var doc DOCUMENT
+	query(t, cc, as(stub, "company1"), &doc, "GetDoc", "doc1000C")
+	if doc.DOCUMENTTYPE != "passport" || doc.HASH != testDocHash || doc.URI != "s3://kyc-docs/passport-1" {
+		t.Errorf("unexpected document %+v", doc)
+	}
+	var docs []DOCUMENT
+	query(t, cc, as(stub, "bank1"), &docs, "GetDocsForCustomer", "company1000A00")
+	if len(docs) != 1 || docs[0].DID != "doc1000C" {
+		t.Errorf("unexpected documents for customer %+v", docs)
+	}
+	query(t, cc, as(stub, "bank2"), &docs, "GetAllDocs")
+	if len(docs) != 1 || docs[0].URI != "" || docs[0].HASH != testDocHash {
+		t.Errorf("expected bank2 to list the document without its location, got %+v", docs)
+	}
+	if _, err := cc.query(stub, "query", []string{"GetDocsForCustomer", "company9000AAA"}); err == nil {
+		t.Error("expected documents for an unknown customer to be refused")
+	}
+	for _, key := range []string{docPrefix + "doc1000C", "doc1000C"} {
+		if out, err := cc.query(as(stub, "bank2"), "query", []string{key}); err == nil && out != nil {
+			t.Errorf("expected the generic query not to return %s, got %s", key, out)
+		}
+	}
+
+	var record KYCRecord
+	query(t, cc, as(stub, "company1"), &record, "GetCP", cpPrefix+"company1000A00")
+	if len(record.Documents) != 1 || record.Documents[0].DID != "doc1000C" {
+		t.Errorf("expected the document to be listed on the customer record, got %+v", record.Documents)
+	}
+}
+
+func TestUploadedContentIsAnchored(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+	const inline = `{"id":"doc1","cusip":"company1000A00","documents":"passport","mimeType":"image/png","myFile":"data"}`
+	if _, err := cc.invoke(as(stub, "company1"), "getUploadedDocuments", []string{inline}); err == nil {
+		t.Fatal("expected inline content to be refused without a document store")
+	}
+
+	dir, err := ioutil.TempDir("", "docstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cc.docStore = NewFileStore(dir)
+	invoke(t, cc, as(stub, "company1"), "getUploadedDocuments", inline)
+
+	if bytes.Contains(stub.State["doc1000C"], []byte("data\"")) {
+		t.Errorf("expected the content to stay off the ledger, got %s", stub.State["doc1000C"])
+	}
+	doc, err := getDoc("doc1000C", stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.HASH != testDocHash || doc.SIZE != 4 || doc.MIMETYPE != "image/png" {
+		t.Errorf("unexpected anchor %+v", doc)
+	}
+	content, err := cc.docStore.Get(doc.URI)
+	if err != nil || string(content) != "data" {
+		t.Errorf("expected the content in the store, got %q %v", content, err)
+	}
+
+	for content, want := range map[string]bool{"data": true, "dat4": false} {
+		var verification DocumentVerification
+		query(t, cc, stub, &verification, "verifyDocument", "doc1000C", base64.StdEncoding.EncodeToString([]byte(content)))
+		if verification.Verified != want {
+			t.Errorf("verifying %q: expected %v", content, want)
+		}
+	}
+}
+
+func TestGetCPDecodesLegacyRecord(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	stub.State[cpPrefix+"company1000A00"] = []byte(`{"cusip":"company1000A00","contract":"bank1000C","ticker":"Asha Rao","par":"F","qty":"26","discount":"Pune","maturity":"MH","owner":"bank1"}`)
+
+	var record KYCRecord
+	query(t, cc, as(stub, "bank1"), &record, "GetCP", cpPrefix+"company1000A00")
+	if record.SchemaVersion != kycSchemaVersion {
+		t.Errorf("expected schema version %d, got %d", kycSchemaVersion, record.SchemaVersion)
+	}
+	if record.Name != "Asha Rao" || record.Gender != "F" || record.City != "Pune" || record.State != "MH" || record.LegacyAge != "26" {
+		t.Errorf("legacy fields not mapped: %+v", record)
+	}
+}
+
+func TestInitMigratesKeyCollections(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := NewMockStub()
+	stub.State["PaperKeys"] = []byte(`["cp:company1000A00"]`)
+	stub.State["BankKeys"] = []byte(`["bank1000C"]`)
+	stub.State["DocKeys"] = []byte(`["doc1000C"]`)
+	stub.State["bank1000C"] = []byte(`{"conractid":"bank1000C","bID":"bank1","bValidators":"validator1"}`)
+	stub.State["doc1000C"] = []byte(`{"id":"doc1000C","cusip":"company1000A00","documents":"passport"}`)
+	invoke(t, cc, as(stub, "admin"), "init")
+
+	for _, key := range []string{"PaperKeys", "BankKeys", "DocKeys"} {
+		if _, ok := stub.State[key]; ok {
+			t.Errorf("expected %s to be removed", key)
+		}
+	}
+	var contracts []BANKCONTRACT
+	query(t, cc, stub, &contracts, "GetAllContracts")
+	if len(contracts) != 1 || contracts[0].CONTRACTID != "bank1000C" {
+		t.Errorf("unexpected contracts %+v", contracts)
+	}
+	docs, err := getAllDocs(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].DID != "doc1000C" {
+		t.Errorf("unexpected documents %+v", docs)
+	}
+	if _, ok := stub.State["doc1000C"]; ok {
+		t.Error("expected the document to move off its bare ID")
+	}
+}
+
+func TestInitMovesDocumentsUnderPrefix(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	key, err := createCompositeKey(docIndex, "company1000A00", "doc1000C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.State[key] = indexEntryValue
+	stub.State["doc1000C"] = []byte(`{"id":"doc1000C","cusip":"company1000A00","documents":"passport","uri":"s3://kyc-docs/passport-1"}`)
+	invoke(t, cc, as(stub, "admin"), "init")
+
+	if _, ok := stub.State["doc1000C"]; ok {
+		t.Error("expected the document to move off its bare ID")
+	}
+	doc, err := getDoc("doc1000C", stub)
+	if err != nil || doc.URI != "s3://kyc-docs/passport-1" {
+		t.Errorf("expected the document under its prefix, got %+v, %v", doc, err)
+	}
+}
+
+func TestQueryCPsPagesAndFilters(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	for _, kyc := range []string{
+		`{"contract":"bank1000C","name":"Asha Rao","dateOfBirth":"1990-04-21","city":"Pune","state":"MH","issuer":"company1","issueDate":"1000"}`,
+		`{"contract":"bank1000C","name":"Ravi Rao","dateOfBirth":"1985-05-02","city":"Mumbai","state":"MH","issuer":"company1","issueDate":"2000"}`,
+		`{"contract":"bank1000C","name":"Mira Rao","dateOfBirth":"1979-11-30","city":"Chennai","state":"TN","issuer":"company1","issueDate":"3000"}`,
+	} {
+		invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", kyc)
+	}
+
+	var cusips []string
+	bookmark := ""
+	for pages := 0; pages < 3; pages++ {
+		var page struct {
+			Results  []KYCRecord `json:"results"`
+			Count    int         `json:"count"`
+			Bookmark string      `json:"bookmark"`
+		}
+		query(t, cc, as(stub, "bank1"), &page, "QueryCPs", `{"pageSize":2,"bookmark":"`+bookmark+`"}`)
+		if page.Count != len(page.Results) {
+			t.Errorf("count %d does not match %d results", page.Count, len(page.Results))
+		}
+		for _, record := range page.Results {
+			cusips = append(cusips, record.CUSIP)
+		}
+		bookmark = page.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	if len(cusips) != 3 || bookmark != "" {
+		t.Fatalf("expected all three records over two pages, got %v", cusips)
+	}
+
+	var page struct {
+		Results []KYCRecord `json:"results"`
+	}
+	query(t, cc, as(stub, "bank1"), &page, "QueryCPs", `{"filter":{"state":"MH","issuedFrom":"1500"}}`)
+	if len(page.Results) != 1 || page.Results[0].City != "Mumbai" {
+		t.Errorf("unexpected filtered records %+v", page.Results)
+	}
+
+	// Callers who cannot read the personal fields cannot filter on them
+	query(t, cc, as(stub, "bank2"), &page, "QueryCPs", `{"filter":{"city":"Pune"}}`)
+	if len(page.Results) != 0 {
+		t.Errorf("expected no matches on redacted fields, got %+v", page.Results)
+	}
+
+	if _, err := cc.query(stub, "query", []string{"QueryCPs", `{"bookmark":"bm90LWEta2V5"}`}); err == nil {
+		t.Error("expected a bookmark from another range to be refused")
+	}
+}
+
+func TestQueryContractsAndDocs(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "bank2"), "createAccount", "bank2")
+	invoke(t, cc, as(stub, "bank2"), "issueBankContract", `{"bID":"bank2","bName":"Second Bank","bValidators":"validator1","bCommission":"20"}`)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+	invoke(t, cc, as(stub, "company1"), "getUploadedDocuments", testDoc)
+
+	var contracts struct {
+		Results []BANKCONTRACT `json:"results"`
+	}
+	query(t, cc, stub, &contracts, "QueryContracts", `{"filter":{"bank":"bank2"}}`)
+	if len(contracts.Results) != 1 || contracts.Results[0].BANKID != "bank2" {
+		t.Errorf("unexpected contracts %+v", contracts.Results)
+	}
+
+	var docs struct {
+		Results []DOCUMENT `json:"results"`
+	}
+	query(t, cc, as(stub, "bank1"), &docs, "QueryDocs", `{"filter":{"cusip":"company1000A00","documentType":"passport"}}`)
+	if len(docs.Results) != 1 || docs.Results[0].URI == "" {
+		t.Errorf("unexpected documents %+v", docs.Results)
+	}
+	query(t, cc, as(stub, "bank2"), &docs, "QueryDocs", "")
+	if len(docs.Results) != 1 || docs.Results[0].URI != "" {
+		t.Errorf("expected the location to be withheld from bank2, got %+v", docs.Results)
+	}
+}
+
+func TestKYCEvents(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+	invoke(t, cc, as(stub, "company1"), "getUploadedDocuments", testDoc)
+	invoke(t, cc, as(stub, "validator1"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator1"}`)
+	invoke(t, cc, as(stub, "validator2"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator2"}`)
+
+	want := []string{EventContractIssued, EventKYCSubmitted, EventDocumentUploaded, EventValidatorApproved, EventKYCTransferred}
+	if len(stub.Events) != len(want) {
+		t.Fatalf("expected events %v, got %+v", want, stub.Events)
+	}
+	for i, name := range want {
+		if stub.Events[i].Name != name {
+			t.Errorf("event %d: expected %s, got %s", i, name, stub.Events[i].Name)
+		}
+	}
+
+	var event Event
+	if err := json.Unmarshal(stub.Events[4].Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.SchemaVersion != eventSchemaVersion || event.CUSIP != "company1000A00" || event.From != "validator2" ||
+		event.To != "bank1" || event.Status != StatusVerified {
+		t.Errorf("unexpected payload %+v", event)
+	}
+	for _, e := range stub.Events {
+		if bytes.Contains(e.Payload, []byte("Asha Rao")) {
+			t.Errorf("event %s carries PII: %s", e.Name, e.Payload)
+		}
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+	stub.TxID = "tx1"
+	invoke(t, cc, as(stub, "validator1"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator1"}`)
+
+	var history []HistoryEntry
+	query(t, cc, as(stub, "bank1"), &history, "GetHistory", HistoryCUSIP, "company1000A00")
+	if len(history) != 2 || history[1].Action != "transferPaper" || history[1].Caller != "validator1" || history[1].TxID != "tx1" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	changed := map[string]string{}
+	for _, change := range history[1].Changes {
+		changed[change.Field] = string(change.After)
+	}
+	if changed["owner"] != `"validator2"` || changed["status"] != `"UNDER_REVIEW"` || changed["name"] != "" {
+		t.Errorf("unexpected changes %+v", history[1].Changes)
+	}
+
+	// Others see the record move but not the PII it was created with
+	query(t, cc, as(stub, "bank2"), &history, "GetHistory", HistoryCUSIP, "company1000A00")
+	for _, change := range history[0].Changes {
+		if change.Field == "name" {
+			t.Errorf("expected PII changes to be withheld, got %+v", history[0].Changes)
+		}
+	}
+
+	query(t, cc, stub, &history, "GetHistory", HistoryContract, "bank1000C")
+	if len(history) != 1 || history[0].Action != "issueBankContract" || history[0].Caller != "bank1-admin" {
+		t.Errorf("unexpected contract history %+v", history)
+	}
+	if _, err := cc.query(stub, "query", []string{"GetHistory", "paper", "company1000A00"}); err == nil {
+		t.Error("expected an unknown history subject to be refused")
+	}
+}
+
+func TestCommissionSettledInBankCurrency(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "bank2"), "createAccount", "bank2", "USD")
+	invoke(t, cc, as(stub, "bank2"), "issueBankContract", `{"bID":"bank2","bValidators":"validator1","bCommission":"166.50 INR","settlementCurrency":"USD"}`)
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", strings.Replace(testKYC, "bank1000C", "bank2000C", 1))
+
+	const rate = `{"base":"USD","quote":"INR","rate":"83.25"}`
+	if _, err := cc.invoke(as(stub, "bank2"), "setFXRate", []string{rate}); err == nil {
+		t.Fatal("expected a bank to be refused setting FX rates")
+	}
+	if _, err := cc.invoke(as(stub, "validator1"), "transferPaper", []string{`{"cusip":"company1000A00","fromCompany":"validator1"}`}); err == nil {
+		t.Fatal("expected settlement to fail without an FX rate")
+	}
+	invoke(t, cc, stub.As("oracle1", RoleFXOracle, ""), "setFXRate", rate)
+	invoke(t, cc, as(stub, "validator1"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator1"}`)
+
+	var bank, issuer Account
+	query(t, cc, stub, &bank, "GetCompany", "bank2")
+	query(t, cc, stub, &issuer, "GetCompany", "company1")
+	if bank.balance("USD").String() != "999998.00 USD" || issuer.balance("INR").String() != "266.50 INR" {
+		t.Errorf("unexpected settlement: bank %v, issuer %v", bank.Balances, issuer.Balances)
+	}
+}
+
+func TestCommissionHeldInEscrow(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "bank2"), "createAccount", "bank2")
+	invoke(t, cc, as(stub, "bank2"), "issueBankContract", `{"bID":"bank2","bValidators":"validator1","bCommission":"50","escrowHours":24}`)
+	balances := func() (string, string) {
+		var bank, issuer Account
+		query(t, cc, stub, &bank, "GetCompany", "bank2")
+		query(t, cc, stub, &issuer, "GetCompany", "company1")
+		return bank.balance(defaultCurrency).String(), issuer.balance(defaultCurrency).String()
+	}
+	deliver := func(txID string) string {
+		stub.TxID = txID
+		record := strings.Replace(testKYC, "bank1000C", "bank2000C", 1)
+		invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", record)
+		var records []KYCRecord
+		query(t, cc, stub, &records, "GetAllCPs")
+		cusip := records[len(records)-1].CUSIP
+		invoke(t, cc, as(stub, "validator1"), "transferPaper", `{"cusip":"`+cusip+`","fromCompany":"validator1"}`)
+		return cusip
+	}
+
+	deliver("tx1")
+	if bank, issuer := balances(); bank != "999950.00 INR" || issuer != "100.00 INR" {
+		t.Fatalf("expected the commission held, got bank %s, issuer %s", bank, issuer)
+	}
+	var event Event
+	if err := json.Unmarshal(stub.Events[len(stub.Events)-1].Payload, &event); err != nil || event.SettlementID != "tx1" {
+		t.Fatalf("expected the delivery to name its settlement, got %+v, %v", event, err)
+	}
+
+	var settlement Settlement
+	if _, err := cc.query(as(stub, "validator2"), "query", []string{"GetSettlement", "tx1"}); err == nil {
+		t.Error("expected a third party to be refused the settlement")
+	}
+	query(t, cc, as(stub, "company1"), &settlement, "GetSettlement", "tx1")
+	if settlement.Status != SettlementLocked || settlement.Payer != "bank2" || settlement.Payee != "company1" {
+		t.Errorf("unexpected settlement %+v", settlement)
+	}
+	if _, err := cc.invoke(as(stub, "company1"), "confirmSettlement", []string{"tx1"}); err == nil {
+		t.Error("expected only the bank to confirm")
+	}
+	invoke(t, cc, as(stub, "bank2"), "confirmSettlement", "tx1")
+	if bank, issuer := balances(); bank != "999950.00 INR" || issuer != "150.00 INR" {
+		t.Errorf("expected the commission released, got bank %s, issuer %s", bank, issuer)
+	}
+
+	// An unconfirmed commission goes back to the bank after the escrow period
+	deliver("tx2")
+	if _, err := cc.invoke(as(stub, "validator1"), "cancelSettlement", []string{"tx2"}); err == nil {
+		t.Error("expected a third party to be refused cancelling before the timeout")
+	}
+	stub.TxTimestamp = stub.TxTimestamp.Add(25 * time.Hour)
+	if _, err := cc.invoke(as(stub, "bank2"), "confirmSettlement", []string{"tx2"}); err == nil {
+		t.Error("expected a timed out settlement not to be confirmed")
+	}
+	invoke(t, cc, as(stub, "validator1"), "cancelSettlement", "tx2")
+	if bank, issuer := balances(); bank != "999950.00 INR" || issuer != "150.00 INR" {
+		t.Errorf("expected the commission refunded, got bank %s, issuer %s", bank, issuer)
+	}
+}
+
+func TestBankContractLifecycle(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "bank2"), "createAccount", "bank2")
+	onboard := func() KYCRecord {
+		t.Helper()
+		invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", testKYC)
+		var records []KYCRecord
+		query(t, cc, stub, &records, "GetAllCPs")
+		return records[len(records)-1]
+	}
+	approve := func(cusip string, validators ...string) {
+		t.Helper()
+		for _, validator := range validators {
+			invoke(t, cc, as(stub, validator), "transferPaper", `{"cusip":"`+cusip+`","fromCompany":"`+validator+`"}`)
+		}
+	}
+	commissionPaid := func() string {
+		var issuer Account
+		query(t, cc, stub, &issuer, "GetCompany", "company1")
+		return issuer.balance(defaultCurrency).String()
+	}
+	lastEvent := func() Event {
+		var event Event
+		if err := json.Unmarshal(stub.Events[len(stub.Events)-1].Payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+	later := fmt.Sprint(stub.TxTimestamp.Add(24*time.Hour).UnixNano() / int64(time.Millisecond))
+
+	first := onboard()
+	if first.ContractVersion != 1 {
+		t.Fatalf("expected the record onboarded under version 1, got %d", first.ContractVersion)
+	}
+
+	const amendment = `{"contract":"bank1000C","bCommission":"75","bValidators":"validator2"}`
+	if _, err := cc.invoke(as(stub, "bank2"), "amendBankContract", []string{amendment}); err == nil {
+		t.Error("expected another bank to be refused amending the contract")
+	}
+	invoke(t, cc, as(stub, "bank1"), "amendBankContract", amendment)
+	if event := lastEvent(); event.Type != EventContractAmended || event.Version != 2 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	var contract BANKCONTRACT
+	query(t, cc, stub, &contract, "bank1000C")
+	if contract.VERSION != 2 || contract.COMMISSION.String() != "75.00 INR" || contract.BANKVALIDATORS != "validator2" {
+		t.Errorf("unexpected amended contract %+v", contract)
+	}
+	query(t, cc, stub, &contract, "GetContractVersion", "bank1000C", "1")
+	if contract.VERSION != 1 || contract.COMMISSION.String() != "50.00 INR" {
+		t.Errorf("expected version 1 kept, got %+v", contract)
+	}
+
+	// The first record is still reviewed and paid for under version 1
+	approve(first.CUSIP, "validator1", "validator2")
+	if paid := commissionPaid(); paid != "150.00 INR" {
+		t.Errorf("expected the version 1 commission, issuer has %s", paid)
+	}
+	second := onboard()
+	if second.ContractVersion != 2 || second.Owner != "validator2" {
+		t.Fatalf("expected the record onboarded under version 2, got %d with %s", second.ContractVersion, second.Owner)
+	}
+	approve(second.CUSIP, "validator2")
+	if paid := commissionPaid(); paid != "225.00 INR" {
+		t.Errorf("expected the version 2 commission, issuer has %s", paid)
+	}
+
+	invoke(t, cc, as(stub, "bank1"), "suspendBankContract", `{"contract":"bank1000C","reason":"audit"}`)
+	if _, err := cc.invoke(as(stub, "company1"), "issueCommercialPaper", []string{testKYC}); err == nil {
+		t.Error("expected a suspended contract to refuse new records")
+	}
+	if _, err := cc.invoke(as(stub, "bank1"), "suspendBankContract", []string{`{"contract":"bank1000C"}`}); err == nil {
+		t.Error("expected a suspended contract not to be suspended again")
+	}
+	invoke(t, cc, as(stub, "bank1"), "reinstateBankContract", `{"contract":"bank1000C"}`)
+
+	// An amendment taking effect tomorrow leaves today's terms in place
+	invoke(t, cc, as(stub, "bank1"), "amendBankContract", `{"contract":"bank1000C","bCommission":"100","effectiveDate":"`+later+`"}`)
+	if third := onboard(); third.ContractVersion != 4 {
+		t.Errorf("expected the record onboarded under version 4, got %d", third.ContractVersion)
+	}
+
+	invoke(t, cc, as(stub, "bank1"), "terminateBankContract", `{"contract":"bank1000C","effectiveDate":"`+later+`"}`)
+	if event := lastEvent(); event.Type != EventContractTerminated || event.Version != 6 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if _, err := cc.invoke(as(stub, "bank1"), "amendBankContract", []string{amendment}); err == nil {
+		t.Error("expected a terminated contract not to be amended")
+	}
+	stub.TxTimestamp = stub.TxTimestamp.Add(25 * time.Hour)
+	if _, err := cc.invoke(as(stub, "company1"), "issueCommercialPaper", []string{testKYC}); err == nil {
+		t.Error("expected a terminated contract to refuse new records")
+	}
+}
+
+func TestCommissionSchedules(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "bank2"), "createAccount", "bank2")
+	invoke(t, cc, as(stub, "bank2"), "issueBankContract", `{"bID":"bank2","validators":["validator1","validator2"],"approvalPolicy":{"threshold":1},
+		"commissionSchedule":{"type":"tiered","tiers":[{"upTo":1,"amount":"50"},{"amount":"33.33"}],
+		"split":[{"validator":"validator1","share":"30"},{"validator":"validator2","share":"20"}]}}`)
+	invoke(t, cc, as(stub, "bank2"), "issueBankContract", `{"bID":"bank2","validators":["validator1"],
+		"commissionSchedule":{"type":"percentage","rate":"0.5","minimum":"20"}}`)
+	deliver := func(kyc string) {
+		t.Helper()
+		invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", kyc)
+		var records []KYCRecord
+		query(t, cc, stub, &records, "GetAllCPs")
+		for _, record := range records {
+			if record.Status == StatusSubmitted {
+				invoke(t, cc, as(stub, "validator1"), "transferPaper", `{"cusip":"`+record.CUSIP+`","fromCompany":"validator1"}`)
+			}
+		}
+	}
+	balances := func(want map[string]string) {
+		t.Helper()
+		for id, balance := range want {
+			var account Account
+			query(t, cc, stub, &account, "GetCompany", id)
+			if got := account.balance(defaultCurrency).String(); got != balance {
+				t.Errorf("expected %s to hold %s, got %s", id, balance, got)
+			}
+		}
+	}
+	tiered := strings.Replace(testKYC, "bank1000C", "bank2000C", 1)
+
+	// The first record of the month is in the first tier and the second in
+	// the next, each shared 30/20 between the validators and the rest to
+	// the issuer
+	deliver(tiered)
+	deliver(tiered)
+	balances(map[string]string{"bank2": "999916.67 INR", "validator1": "125.00 INR", "validator2": "116.67 INR", "company1": "141.66 INR"})
+
+	// Percentages are of the onboarding value, with a minimum
+	percentage := strings.Replace(testKYC, "bank1000C", "bank2001C", 1)
+	if _, err := cc.invoke(as(stub, "company1"), "issueCommercialPaper", []string{percentage}); err == nil {
+		t.Error("expected a record without an onboarding value to be refused")
+	}
+	deliver(strings.Replace(percentage, `"issuer"`, `"onboardingValue":"10000","issuer"`, 1))
+	deliver(strings.Replace(percentage, `"issuer"`, `"onboardingValue":"1000","issuer"`, 1))
+	balances(map[string]string{"bank2": "999846.67 INR", "company1": "211.66 INR"})
+
+	// A new month starts again from the first tier
+	stub.TxTimestamp = stub.TxTimestamp.AddDate(0, 1, 0)
+	deliver(tiered)
+	balances(map[string]string{"validator1": "140.00 INR", "validator2": "126.67 INR"})
+
+	june := []string{"GetCommissionStatement", "1464739200000", "1467331200000"}
+	var statements []CommissionStatement
+	query(t, cc, as(stub, "validator1"), &statements, june...)
+	if len(statements) != 1 || statements[0].Validator != "validator1" || statements[0].Accrued[defaultCurrency].String() != "25.00 INR" || len(statements[0].Entries) != 2 {
+		t.Errorf("unexpected statement %+v", statements)
+	}
+	query(t, cc, as(stub, "bank2"), &statements, june...)
+	if len(statements) != 2 || statements[1].Validator != "validator2" || statements[1].Accrued[defaultCurrency].String() != "16.67 INR" {
+		t.Errorf("unexpected statements %+v", statements)
+	}
+	query(t, cc, as(stub, "bank1"), &statements, june...)
+	if len(statements) != 0 {
+		t.Errorf("expected another bank to see no commission, got %+v", statements)
+	}
+	if _, err := cc.query(as(stub, "validator1"), "query", append(june, "validator2")); err == nil {
+		t.Error("expected a validator to be refused another's statement")
+	}
+	if _, err := cc.query(as(stub, "company1"), "query", june); err == nil {
+		t.Error("expected a customer to be refused commission statements")
+	}
+
+	// Accruals are only read through the statement
+	for key := range stub.State {
+		if !strings.HasPrefix(key, compositeKeyNamespace+commissionObject) && !strings.HasPrefix(key, compositeKeyNamespace+commissionVolumeObject) {
+			continue
+		}
+		if out, err := cc.query(as(stub, "company1"), "query", []string{key}); err == nil {
+			t.Errorf("expected the generic query not to return %q, got %s", key, out)
+		}
+	}
+}
+
+func TestConsentRegistry(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	for _, bank := range []string{"bank2", "bank3"} {
+		invoke(t, cc, as(stub, bank), "createAccount", bank)
+	}
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", strings.Replace(testKYC, `"city"`, `"email":"asha@example.com","city"`, 1))
+	expiry := fmt.Sprint(stub.TxTimestamp.AddDate(0, 0, 30).UnixNano() / int64(time.Millisecond))
+	loan := `{"cusip":"company1000A00","bank":"bank2","purpose":"loan","scope":["identity","contact"],"expiry":"` + expiry + `"}`
+	if _, err := cc.invoke(as(stub, "company1"), "grantConsent", []string{loan}); err == nil {
+		t.Error("expected a record under review not to be shared")
+	}
+	invoke(t, cc, as(stub, "validator1"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator1"}`)
+	invoke(t, cc, as(stub, "validator2"), "transferPaper", `{"cusip":"company1000A00","fromCompany":"validator2"}`)
+
+	stub.TxID = "consent1"
+	if _, err := cc.invoke(as(stub, "bank3"), "grantConsent", []string{loan}); err == nil {
+		t.Error("expected another bank to be refused granting consent")
+	}
+	for name, consent := range map[string]string{
+		"unknown scope":   strings.Replace(loan, `"contact"`, `"income"`, 1),
+		"past expiry":     strings.Replace(loan, expiry, "1464771600000", 1),
+		"onboarding bank": strings.Replace(loan, "bank2", "bank1", 1),
+	} {
+		if _, err := cc.invoke(as(stub, "company1"), "grantConsent", []string{consent}); err == nil {
+			t.Errorf("%s: expected the consent to be refused", name)
+		}
+	}
+	invoke(t, cc, as(stub, "company1"), "grantConsent", loan)
+	stub.TxID = "consent2"
+	if _, err := cc.invoke(as(stub, "company1"), "grantConsent", []string{loan}); err == nil {
+		t.Error("expected a second live consent for the same purpose to be refused")
+	}
+	// The onboarding bank may grant on the customer's behalf
+	invoke(t, cc, as(stub, "bank1"), "grantConsent", `{"cusip":"company1000A00","bank":"bank3","purpose":"account opening","scope":["address"],"expiry":"`+expiry+`"}`)
+
+	if _, err := cc.invoke(as(stub, "bank3"), "accessKYC", []string{"consent1"}); err == nil {
+		t.Error("expected a bank without the consent to be refused access")
+	}
+	if _, err := cc.query(as(stub, "bank3"), "query", []string{"GetSharedKYC", "consent1"}); err == nil {
+		t.Error("expected a bank without the consent to be refused the record")
+	}
+	if _, err := cc.query(as(stub, "bank2"), "query", []string{"GetSharedKYC", "consent1"}); err == nil {
+		t.Error("expected the record to be refused before an access is logged")
+	}
+	stub.TxID = "access1"
+	invoke(t, cc, as(stub, "bank2"), "accessKYC", "consent1")
+	var shared KYCRecord
+	query(t, cc, as(stub, "bank2"), &shared, "GetSharedKYC", "consent1")
+	if shared.Name != "Asha Rao" || shared.Email != "asha@example.com" || shared.City != "" {
+		t.Errorf("expected identity and contact only, got %+v", shared)
+	}
+	var event Event
+	if err := json.Unmarshal(stub.Events[len(stub.Events)-1].Payload, &event); err != nil || event.Type != EventKYCAccessed || event.ConsentID != "consent1" {
+		t.Errorf("unexpected event %+v, %v", event, err)
+	}
+
+	var consents []Consent
+	query(t, cc, as(stub, "company1"), &consents, "GetConsents", "company1")
+	if len(consents) != 2 || consents[0].ID != "consent1" || len(consents[0].Accesses) != 1 || consents[0].Accesses[0].AccessedBy != "bank2-admin" {
+		t.Fatalf("unexpected consents %+v", consents)
+	}
+	query(t, cc, as(stub, "bank3"), &consents, "GetConsents", "company1")
+	if len(consents) != 1 || consents[0].ID != "consent2" || consents[0].GrantedBy != "bank1-admin" {
+		t.Errorf("expected bank3 to see only its consent, got %+v", consents)
+	}
+	if _, err := cc.query(as(stub, "validator1"), "query", []string{"GetConsents", "company1"}); err == nil {
+		t.Error("expected a validator to be refused the consents")
+	}
+
+	invoke(t, cc, as(stub, "company1"), "revokeConsent", "consent1", "loan repaid")
+	if _, err := cc.invoke(as(stub, "bank2"), "accessKYC", []string{"consent1"}); err == nil {
+		t.Error("expected a revoked consent to be refused")
+	}
+	if _, err := cc.query(as(stub, "bank2"), "query", []string{"GetSharedKYC", "consent1"}); err == nil {
+		t.Error("expected a revoked consent not to share the record")
+	}
+	if _, err := cc.invoke(as(stub, "company1"), "revokeConsent", []string{"consent1"}); err == nil {
+		t.Error("expected a revoked consent not to be revoked again")
+	}
+
+	stub.TxTimestamp = stub.TxTimestamp.AddDate(0, 0, 31)
+	if _, err := cc.invoke(as(stub, "bank3"), "accessKYC", []string{"consent2"}); err == nil {
+		t.Error("expected an expired consent to be refused")
+	}
+	if _, err := cc.query(as(stub, "bank3"), "query", []string{"GetSharedKYC", "consent2"}); err == nil {
+		t.Error("expected an expired consent not to share the record")
+	}
+	query(t, cc, as(stub, "company1"), &consents, "GetConsents", "company1", "company1000A00")
+	if len(consents) != 2 || consents[0].Status != ConsentRevoked || consents[0].RevokeReason != "loan repaid" || consents[1].Status != ConsentExpired {
+		t.Errorf("unexpected consents %+v", consents)
+	}
+}
+
+func TestKYCRenewal(t *testing.T) {
+	cc, stub := newKYCLedger(t)
+	invoke(t, cc, as(stub, "bank2"), "createAccount", "bank2")
+	invoke(t, cc, as(stub, "bank2"), "issueBankContract", `{"bID":"bank2","validators":["validator1","validator2"],"approvalPolicy":{"threshold":2},"bCommission":"50",
+		"validityPolicy":{"tiers":{"high":24,"low":120},"defaultTier":"low"}}`)
+	kyc := strings.Replace(testKYC, "bank1000C", "bank2000C", 1)
+	if _, err := cc.invoke(as(stub, "company1"), "issueCommercialPaper", []string{strings.Replace(kyc, `"issuer"`, `"riskTier":"extreme","issuer"`, 1)}); err == nil {
+		t.Error("expected an unknown risk tier to be refused")
+	}
+	invoke(t, cc, as(stub, "company1"), "issueCommercialPaper", strings.Replace(kyc, `"issuer"`, `"riskTier":"low","issuer"`, 1))
+	approve := func(validator string, riskTier string) KYCRecord {
+		t.Helper()
+		invoke(t, cc, as(stub, validator), "transferPaper", `{"cusip":"company1000A00","fromCompany":"`+validator+`","riskTier":"`+riskTier+`"}`)
+		var record KYCRecord
+		query(t, cc, stub, &record, "GetCP", cpPrefix+"company1000A00")
+		return record
+	}
+	// The customer only asks for a tier; the validators decide it
+	var record KYCRecord
+	query(t, cc, stub, &record, "GetCP", cpPrefix+"company1000A00")
+	if record.RiskTier != "low" || record.RequestedRiskTier != "low" {
+		t.Errorf("expected the default tier with the request kept, got %q and %q", record.RiskTier, record.RequestedRiskTier)
+	}
+	if _, err := cc.invoke(as(stub, "validator1"), "transferPaper", []string{`{"cusip":"company1000A00","fromCompany":"validator1","riskTier":"extreme"}`}); err == nil {
+		t.Error("expected an unknown risk tier to be refused on approval")
+	}
+	approve("validator1", "high")
+	record = approve("validator2", "")
+	if record.Status != StatusVerified || record.ValidUntil == nil || !record.ValidUntil.Equal(stub.TxTimestamp.AddDate(2, 0, 0)) {
+		t.Fatalf("expected the record valid for two years, got %q until %v", record.Status, record.ValidUntil)
+	}
+
+	var expiring []KYCRecord
+	query(t, cc, as(stub, "bank2"), &expiring, "GetExpiringKYC", "1525132800000", "1530403200000")
+	if len(expiring) != 1 || expiring[0].CUSIP != "company1000A00" {
+		t.Errorf("expected the record to expire in June 2018, got %+v", expiring)
+	}
+	query(t, cc, as(stub, "bank2"), &expiring, "GetExpiringKYC", "1530403200000", "1561939200000", "bank2000C")
+	if len(expiring) != 0 {
+		t.Errorf("expected nothing to expire after June 2018, got %+v", expiring)
+	}
+
+	if _, err := cc.invoke(as(stub, "validator1"), "expireKYC", []string{"company1000A00"}); err == nil {
+		t.Error("expected a valid record not to be expired")
+	}
+	stub.TxTimestamp = stub.TxTimestamp.AddDate(2, 0, 1)
+	invoke(t, cc, as(stub, "validator1"), "expireKYC", "company1000A00")
+
+	const renewal = `{"cusip":"company1000A00","riskTier":"low"}`
+	if _, err := cc.invoke(as(stub, "bank1"), "renewKYC", []string{renewal}); err == nil {
+		t.Error("expected another bank to be refused renewing the record")
+	}
+	invoke(t, cc, as(stub, "company1"), "renewKYC", renewal)
+	query(t, cc, stub, &record, "GetCP", cpPrefix+"company1000A00")
+	if record.Status != StatusSubmitted || record.Round != 1 || record.Owner != "bank2000C" || len(record.SignOffs) != 2 {
+		t.Fatalf("expected the record back with the validators, got %+v", record)
+	}
+	if record.RiskTier != "high" || record.RequestedRiskTier != "low" {
+		t.Errorf("expected the customer's renewal not to change the tier, got %q asking %q", record.RiskTier, record.RequestedRiskTier)
+	}
+	if _, err := cc.invoke(as(stub, "company1"), "renewKYC", []string{renewal}); err == nil {
+		t.Error("expected a record under review not to be renewed again")
+	}
+
+	// The sign-offs of the first round do not count towards the renewal
+	if record = approve("validator1", ""); record.Status != StatusUnderReview {
+		t.Fatalf("expected the renewal to wait for a second approval, got %q", record.Status)
+	}
+	record = approve("validator2", "low")
+	if record.Status != StatusVerified || len(record.SignOffs) != 4 || !record.ValidUntil.Equal(stub.TxTimestamp.AddDate(10, 0, 0)) {
+		t.Errorf("expected the record verified for ten years, got %q until %v", record.Status, record.ValidUntil)
+	}
+
+	var history []HistoryEntry
+	query(t, cc, as(stub, "bank2"), &history, "GetHistory", HistoryCUSIP, "company1000A00")
+	actions := make([]string, 0, len(history))
+	for _, entry := range history {
+		actions = append(actions, entry.Action)
+	}
+	if strings.Join(actions, ",") != "issueCommercialPaper,transferPaper,transferPaper,expireKYC,renewKYC,transferPaper,transferPaper" {
+		t.Errorf("unexpected history %v", actions)
+	}
+}
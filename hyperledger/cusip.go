@@ -0,0 +1,82 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// A record's CUSIP is its issuer's account prefix and a two character
+// record number. Record numbers are allocated in sequence, so a CUSIP says
+// nothing about the customer; the next sequence number of each issuer is
+// kept under cusipSequencePrefix + issuer.
+var cusipSequencePrefix = "cusipseq:"
+
+// recordNumberChars are the characters of a record number, leaving out I
+// and O, which read as 1 and 0.
+const recordNumberChars = "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// maxRecordsPerIssuer is how many record numbers one issuer has.
+const maxRecordsPerIssuer = len(recordNumberChars) * len(recordNumberChars)
+
+func recordNumber(n int) string {
+	base := len(recordNumberChars)
+	return string(recordNumberChars[n/base]) + string(recordNumberChars[n%base])
+}
+
+// nextCUSIP returns the next unused CUSIP of an issuer and the sequence
+// number to save with saveCUSIPSequence once the record is written.
+func nextCUSIP(stub StateStub, account Account) (string, int, error) {
+	sequenceBytes, err := stub.GetState(cusipSequencePrefix + account.ID)
+	if err != nil {
+		fmt.Println("Error retrieving CUSIP sequence of " + account.ID)
+		return "", 0, errors.New("Error retrieving CUSIP sequence of " + account.ID)
+	}
+	sequence := 0
+	if sequenceBytes != nil {
+		sequence, err = strconv.Atoi(string(sequenceBytes))
+		if err != nil {
+			return "", 0, errors.New("Invalid CUSIP sequence of " + account.ID)
+		}
+	}
+
+	// Records issued before CUSIPs were allocated may hold some numbers
+	for ; sequence < maxRecordsPerIssuer; sequence++ {
+		cusip := account.Prefix + recordNumber(sequence)
+		existing, err := stub.GetState(cpPrefix + cusip)
+		if err != nil {
+			return "", 0, errors.New("Error retrieving KYC record " + cusip)
+		}
+		if existing == nil {
+			return cusip, sequence + 1, nil
+		}
+	}
+	return "", 0, errors.New("Issuer " + account.ID + " has issued the most KYC records allowed")
+}
+
+func saveCUSIPSequence(stub StateStub, account Account, sequence int) error {
+	err := stub.PutState(cusipSequencePrefix+account.ID, []byte(strconv.Itoa(sequence)))
+	if err != nil {
+		fmt.Println("Error saving CUSIP sequence of " + account.ID)
+		return errors.New("Error saving CUSIP sequence of " + account.ID)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DocumentStore keeps document content off the ledger. Content is addressed
+// by its SHA-256 hash; Put returns the URI recorded on the DOCUMENT.
+type DocumentStore interface {
+	Put(content []byte) (string, error)
+	Get(uri string) ([]byte, error)
+}
+
+const fileStoreScheme = "file://"
+
+// FileStore is a DocumentStore writing each document to a file named after
+// its hash in Dir. It is meant for tests and single-node setups.
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+func (s *FileStore) Put(content []byte) (string, error) {
+	path := filepath.Join(s.Dir, hashDocument(content))
+	err := ioutil.WriteFile(path, content, 0600)
+	if err != nil {
+		return "", err
+	}
+	return fileStoreScheme + path, nil
+}
+
+func (s *FileStore) Get(uri string) ([]byte, error) {
+	if !strings.HasPrefix(uri, fileStoreScheme) {
+		return nil, errors.New("Unsupported document URI " + uri)
+	}
+	path := filepath.Clean(strings.TrimPrefix(uri, fileStoreScheme))
+	if filepath.Dir(path) != filepath.Clean(s.Dir) {
+		return nil, errors.New("Document URI outside the store " + uri)
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New("Document not found " + uri)
+	}
+	return content, err
+}
+
+// hashDocument returns the hex SHA-256 digest anchored for a document.
+func hashDocument(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// anchorDocument fills in the hash, size and URI of an uploaded document.
+// Content sent inline in myFile is moved to the store, so only the anchor
+// reaches the ledger; otherwise the client must already have stored the
+// content and supply its anchor.
+func anchorDocument(store DocumentStore, doc *DOCUMENT) error {
+	if doc.FILE != "" {
+		if store == nil {
+			fmt.Println("No document store for inline content")
+			return errors.New("Document content must be stored off-chain; submit hash, size, mimeType and uri")
+		}
+		content := []byte(doc.FILE)
+		uri, err := store.Put(content)
+		if err != nil {
+			fmt.Println("Error storing document " + doc.DID)
+			return errors.New("Error storing document " + doc.DID)
+		}
+		doc.HASH = hashDocument(content)
+		doc.SIZE = int64(len(content))
+		doc.URI = uri
+		doc.FILE = ""
+	}
+
+	doc.HASH = strings.ToLower(doc.HASH)
+	hash, err := hex.DecodeString(doc.HASH)
+	switch {
+	case err != nil || len(hash) != sha256.Size:
+		return errors.New("Document hash must be a hex SHA-256 digest")
+	case doc.SIZE <= 0:
+		return errors.New("Document size must be positive")
+	case doc.MIMETYPE == "":
+		return errors.New("Document MIME type is required")
+	case doc.URI == "":
+		return errors.New("Document storage URI is required")
+	}
+	return nil
+}
+
+// DocumentVerification is the result of the verifyDocument query.
+type DocumentVerification struct {
+	DID      string `json:"id"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Verified bool   `json:"verified"`
+}
+
+// verifyDocument checks base64-encoded content against the hash anchored
+// for a document.
+func verifyDocument(stub StateStub, docid string, encoded string) (DocumentVerification, error) {
+	var verification DocumentVerification
+	doc, err := getDoc(docid, stub)
+	if err != nil {
+		return verification, err
+	}
+	if doc.HASH == "" {
+		return verification, errors.New("Document has no anchored hash " + docid)
+	}
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return verification, errors.New("Document content must be base64 encoded")
+	}
+	verification.DID = doc.DID
+	verification.Hash = doc.HASH
+	verification.Size = doc.SIZE
+	verification.Verified = hashDocument(content) == doc.HASH && int64(len(content)) == doc.SIZE
+	return verification, nil
+}
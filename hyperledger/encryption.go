@@ -0,0 +1,229 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Customer PII is encrypted field by field with a data key generated for
+// each write. The data key is wrapped with the key of the bank the record
+// was onboarded with, whose SHA-256 fingerprint the bank registers on its
+// BANKCONTRACT. The bank key itself never reaches the ledger: it is passed
+// as an extra argument to issueCommercialPaper, which should be submitted
+// as a confidential transaction, and to GetCP.
+//
+// Every peer must produce the same ciphertext, so data keys and nonces are
+// derived from the bank key, the transaction ID and the CUSIP rather than
+// drawn at random.
+
+const (
+	encryptedPrefix = "enc:v1:"
+	fieldKeySize    = 32
+)
+
+// FieldEncryption records how a KYCRecord's PII was encrypted.
+type FieldEncryption struct {
+	Bank           string `json:"bank"`
+	KeyFingerprint string `json:"keyFingerprint"`
+	WrappedKey     string `json:"wrappedKey"`
+}
+
+// piiFields returns the sensitive fields of a record by name. The date of
+// birth is encrypted too, by encryptPII and decryptPII themselves.
+func (record *KYCRecord) piiFields() map[string]*string {
+	return map[string]*string{
+		"name":    &record.Name,
+		"gender":  &record.Gender,
+		"phone":   &record.Phone,
+		"mobile":  &record.Mobile,
+		"email":   &record.Email,
+		"house":   &record.House,
+		"street":  &record.Street,
+		"pin":     &record.Pin,
+		"fmrdata": &record.Fmrdata,
+	}
+}
+
+func keyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+func validKeyFingerprint(fingerprint string) bool {
+	decoded, err := hex.DecodeString(fingerprint)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// decodeBankKey decodes a base64 bank key and checks it against the
+// fingerprint it must match.
+func decodeBankKey(encoded string, fingerprint string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != fieldKeySize {
+		return nil, errors.New("PII key must be 32 bytes, base64 encoded")
+	}
+	if keyFingerprint(key) != strings.ToLower(fingerprint) {
+		return nil, errors.New("PII key does not match the registered key")
+	}
+	return key, nil
+}
+
+func deriveKey(key []byte, parts ...string) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range parts {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
+
+func seal(key []byte, nonceSeed []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := nonceSeed[:gcm.NonceSize()]
+	return append(append([]byte{}, nonce...), gcm.Seal(nil, nonce, plaintext, nil)...), nil
+}
+
+func unseal(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Encrypted value too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// encryptPII encrypts the record's PII under a fresh data key wrapped with
+// the bank key.
+func encryptPII(record *KYCRecord, bank string, bankKey []byte, txID string) error {
+	dataKey := deriveKey(bankKey, "data-key", txID, record.CUSIP)
+	wrapped, err := seal(bankKey, deriveKey(bankKey, "wrap-nonce", txID, record.CUSIP), dataKey)
+	if err != nil {
+		return err
+	}
+
+	for name, field := range record.piiFields() {
+		if *field == "" {
+			continue
+		}
+		*field, err = sealField(dataKey, name, *field)
+		if err != nil {
+			return err
+		}
+	}
+	if !record.DateOfBirth.IsZero() {
+		sealed, err := sealField(dataKey, "dateOfBirth", record.DateOfBirth.Format(dateLayout))
+		if err != nil {
+			return err
+		}
+		record.DateOfBirth = Date{sealed: sealed}
+	}
+
+	record.Encryption = &FieldEncryption{
+		Bank:           bank,
+		KeyFingerprint: keyFingerprint(bankKey),
+		WrappedKey:     base64.StdEncoding.EncodeToString(wrapped),
+	}
+	return nil
+}
+
+// decryptPII decrypts the record's PII with the bank key it was encrypted
+// for, given base64 encoded.
+func decryptPII(record *KYCRecord, encodedKey string) error {
+	if record.Encryption == nil {
+		return nil
+	}
+	bankKey, err := decodeBankKey(encodedKey, record.Encryption.KeyFingerprint)
+	if err != nil {
+		return err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(record.Encryption.WrappedKey)
+	if err != nil {
+		return errors.New("Invalid wrapped key on " + record.CUSIP)
+	}
+	dataKey, err := unseal(bankKey, wrapped)
+	if err != nil {
+		return errors.New("Cannot unwrap the data key of " + record.CUSIP)
+	}
+
+	for name, field := range record.piiFields() {
+		if !strings.HasPrefix(*field, encryptedPrefix) {
+			continue
+		}
+		*field, err = unsealField(dataKey, name, *field, record.CUSIP)
+		if err != nil {
+			return err
+		}
+	}
+	if record.DateOfBirth.sealed != "" {
+		plaintext, err := unsealField(dataKey, "dateOfBirth", record.DateOfBirth.sealed, record.CUSIP)
+		if err != nil {
+			return err
+		}
+		dateOfBirth, err := time.Parse(dateLayout, plaintext)
+		if err != nil {
+			return errors.New("Invalid encrypted dateOfBirth on " + record.CUSIP)
+		}
+		record.DateOfBirth = Date{Time: dateOfBirth}
+	}
+	record.Encryption = nil
+	return nil
+}
+
+// sealField encrypts the value of a named field.
+func sealField(dataKey []byte, name string, value string) (string, error) {
+	sealed, err := seal(dataKey, deriveKey(dataKey, name), []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unsealField decrypts the value of a named field of a record.
+func unsealField(dataKey []byte, name string, value string, cusip string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", errors.New("Invalid encrypted " + name + " on " + cusip)
+	}
+	plaintext, err := unseal(dataKey, sealed)
+	if err != nil {
+		return "", errors.New("Cannot decrypt " + name + " on " + cusip)
+	}
+	return string(plaintext), nil
+}
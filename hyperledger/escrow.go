@@ -0,0 +1,225 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var escrowPrefix = "escrow:"
+
+// Settlement states.
+const (
+	SettlementLocked   = "LOCKED"
+	SettlementReleased = "RELEASED"
+	SettlementRefunded = "REFUNDED"
+)
+
+// Settlement holds a payment in escrow under one ID until the payer
+// confirms the delivery it pays for, when it is released to the payee, or
+// until it is cancelled or times out, when it is refunded to the payer.
+// Commission on a KYC record delivered under a contract with an escrow
+// period is held this way.
+type Settlement struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Payer's Cash is held; Payee is owed Payment, which is the same amount
+	// unless the payer settles in another currency
+	Payer   string `json:"payer"`
+	Payee   string `json:"payee"`
+	Cash    Money  `json:"cash"`
+	Payment Money  `json:"payment"`
+	// CUSIP and Contract identify the KYC record delivered, if any
+	CUSIP    string    `json:"cusip,omitempty"`
+	Contract string    `json:"contract,omitempty"`
+	Timeout  time.Time `json:"timeout"`
+	LockedAt time.Time `json:"lockedAt"`
+	SettleTx string    `json:"settleTx,omitempty"`
+}
+
+func getSettlement(stub StateStub, id string) (Settlement, error) {
+	var settlement Settlement
+	settlementBytes, err := stub.GetState(escrowPrefix + id)
+	if err != nil || settlementBytes == nil {
+		return settlement, errors.New("Settlement not found " + id)
+	}
+	err = json.Unmarshal(settlementBytes, &settlement)
+	if err != nil {
+		fmt.Println("Error unmarshalling settlement " + id)
+		return settlement, errors.New("Error unmarshalling settlement " + id)
+	}
+	return settlement, nil
+}
+
+// lockEscrow takes the payer's cash into escrow until timeout and stages
+// the changes in batch.
+func lockEscrow(stub StateStub, batch *writeBatch, settlement *Settlement, timeout time.Duration, payer *Account) error {
+	existing, err := stub.GetState(escrowPrefix + settlement.ID)
+	if err != nil || existing != nil {
+		fmt.Println("Settlement " + settlement.ID + " already exists")
+		return errors.New("Settlement " + settlement.ID + " already exists")
+	}
+	settlement.LockedAt, err = stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	settlement.Timeout = settlement.LockedAt.Add(timeout)
+	settlement.Status = SettlementLocked
+
+	err = payer.debit(settlement.Cash)
+	if err != nil {
+		fmt.Println("The company " + payer.ID + " cannot fund settlement " + settlement.ID)
+		return err
+	}
+	err = batch.put(accountPrefix+payer.ID, payer, HistoryAccount, payer.ID, "lockSettlement")
+	if err != nil {
+		return err
+	}
+	return batch.put(escrowPrefix+settlement.ID, settlement, "", "", "")
+}
+
+// settleEscrow releases a locked settlement to the payee, or refunds it to
+// the payer, and writes the changes together.
+func settleEscrow(stub StateStub, settlement *Settlement, release bool) error {
+	if settlement.Status != SettlementLocked {
+		return errors.New("Settlement " + settlement.ID + " is already " + settlement.Status)
+	}
+	action, to, amount := "refundSettlement", settlement.Payer, settlement.Cash
+	if release {
+		action, to, amount = "releaseSettlement", settlement.Payee, settlement.Payment
+	}
+	account, err := GetCompany(to, stub)
+	if err != nil {
+		return err
+	}
+	err = account.credit(amount)
+	if err != nil {
+		return err
+	}
+
+	batch := newWriteBatch(stub)
+	err = batch.put(accountPrefix+account.ID, &account, HistoryAccount, account.ID, action)
+	if err != nil {
+		return err
+	}
+	if release {
+		settlement.Status = SettlementReleased
+	} else {
+		settlement.Status = SettlementRefunded
+	}
+	settlement.SettleTx = stub.GetTxID()
+	err = batch.put(escrowPrefix+settlement.ID, settlement, "", "", "")
+	if err != nil {
+		return err
+	}
+	err = batch.commit()
+	if err != nil {
+		return err
+	}
+
+	event := Event{Type: EventSettlementRefunded, CUSIP: settlement.CUSIP, Contract: settlement.Contract,
+		SettlementID: settlement.ID, From: settlement.Payer, To: to}
+	if release {
+		event.Type = EventSettlementReleased
+	}
+	return setEvent(stub, event)
+}
+
+// confirmSettlement releases a locked settlement to the payee. The payer,
+// or an administrator of the paying bank, confirms before the timeout.
+func (t *SimpleChaincode) confirmSettlement(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			settlement ID
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting settlement ID")
+	}
+	settlement, err := getSettlement(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, err = requireCaller(stub, settlement.Payer, settlement.Payer)
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(settlement.Timeout) {
+		return nil, errors.New("Settlement " + settlement.ID + " has timed out and can only be refunded")
+	}
+	err = settleEscrow(stub, &settlement, true)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Released settlement " + settlement.ID)
+	return nil, nil
+}
+
+// cancelSettlement refunds a locked settlement to the payer. Before the
+// timeout only the payee may give up the payment; after it anyone may
+// reclaim it for the payer.
+func (t *SimpleChaincode) cancelSettlement(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			settlement ID
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting settlement ID")
+	}
+	settlement, err := getSettlement(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(settlement.Timeout) {
+		_, err = requireCaller(stub, settlement.Payee, settlement.Payee)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = settleEscrow(stub, &settlement, false)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Refunded settlement " + settlement.ID)
+	return nil, nil
+}
+
+// readSettlement returns a settlement to one of its parties.
+func readSettlement(stub StateStub, id string) (Settlement, error) {
+	settlement, err := getSettlement(stub, id)
+	if err != nil {
+		return settlement, err
+	}
+	_, err = requireCaller(stub, settlement.Payer, settlement.Payer)
+	if err != nil {
+		_, err = requireCaller(stub, settlement.Payee, settlement.Payee)
+	}
+	if err != nil {
+		return Settlement{}, err
+	}
+	return settlement, nil
+}
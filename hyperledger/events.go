@@ -0,0 +1,98 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// eventSchemaVersion is written into every event payload. Fields are only
+// ever added to Event; a change to existing fields bumps the version.
+const eventSchemaVersion = 1
+
+// Chaincode event names. The peer keeps one event per transaction, so each
+// invoke raises the single event describing its outcome.
+const (
+	EventKYCSubmitted       = "KYCSubmitted"
+	EventValidatorApproved  = "ValidatorApproved"
+	EventKYCRejected        = "KYCRejected"
+	EventKYCTransferred     = "KYCTransferred"
+	EventContractIssued     = "ContractIssued"
+	EventContractAmended    = "ContractAmended"
+	EventContractSuspended  = "ContractSuspended"
+	EventContractReinstated = "ContractReinstated"
+	EventContractTerminated = "ContractTerminated"
+	EventDocumentUploaded   = "DocumentUploaded"
+	EventSettlementReleased = "SettlementReleased"
+	EventSettlementRefunded = "SettlementRefunded"
+	EventConsentGranted     = "ConsentGranted"
+	EventConsentRevoked     = "ConsentRevoked"
+	EventKYCAccessed        = "KYCAccessed"
+	EventKYCRenewed         = "KYCRenewed"
+	EventKYCExpired         = "KYCExpired"
+)
+
+// Event is the JSON payload of every chaincode event. It never carries
+// customer PII, only identifiers and workflow state.
+type Event struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Type          string    `json:"type"`
+	TxID          string    `json:"txId"`
+	Timestamp     time.Time `json:"timestamp"`
+
+	CUSIP        string    `json:"cusip,omitempty"`
+	Contract     string    `json:"contract,omitempty"`
+	Bank         string    `json:"bank,omitempty"`
+	Issuer       string    `json:"issuer,omitempty"`
+	Validator    string    `json:"validator,omitempty"`
+	From         string    `json:"from,omitempty"`
+	To           string    `json:"to,omitempty"`
+	Status       KYCStatus `json:"status,omitempty"`
+	DocumentID   string    `json:"documentId,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+	SettlementID string    `json:"settlementId,omitempty"`
+	Version      int       `json:"version,omitempty"`
+	ConsentID    string    `json:"consentId,omitempty"`
+}
+
+// setEvent stamps the event with the transaction and raises it.
+func setEvent(stub StateStub, event Event) error {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	event.SchemaVersion = eventSchemaVersion
+	event.TxID = stub.GetTxID()
+	event.Timestamp = timestamp
+
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		fmt.Println("Error marshalling event " + event.Type)
+		return errors.New("Error marshalling event " + event.Type)
+	}
+	err = stub.SetEvent(event.Type, payload)
+	if err != nil {
+		fmt.Println("Error setting event " + event.Type)
+		return errors.New("Error setting event " + event.Type)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var fxPrefix = "fx:"
+
+// FXRate is the number of units of Quote one unit of Base buys, as last
+// set by an FX oracle.
+type FXRate struct {
+	Base      string    `json:"base"`
+	Quote     string    `json:"quote"`
+	Rate      Rate      `json:"rate"`
+	SetBy     string    `json:"setBy"`
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func fxKey(base string, quote string) string {
+	return fxPrefix + base + "/" + quote
+}
+
+// setFXRate records a rate. Only an FX oracle may set rates.
+//
+//	{"base": "USD", "quote": "INR", "rate": "83.25"}
+func (t *SimpleChaincode) setFXRate(stub StateStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting FX rate")
+	}
+	oracle, err := requireOracle(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var rate FXRate
+	err = json.Unmarshal([]byte(args[0]), &rate)
+	if err != nil {
+		fmt.Println("Error unmarshalling FX rate")
+		return nil, errors.New("Invalid FX rate")
+	}
+	rate.Base = strings.ToUpper(rate.Base)
+	rate.Quote = strings.ToUpper(rate.Quote)
+	for _, currency := range []string{rate.Base, rate.Quote} {
+		_, err = currencyExponent(currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rate.Base == rate.Quote || rate.Rate.Rat().Sign() <= 0 {
+		return nil, errors.New("An FX rate needs two different currencies and a positive rate")
+	}
+
+	rate.SetBy = oracle
+	rate.TxID = stub.GetTxID()
+	rate.Timestamp, err = stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	rateBytes, err := json.Marshal(&rate)
+	if err != nil {
+		fmt.Println("Error marshalling FX rate")
+		return nil, errors.New("Error setting FX rate")
+	}
+	err = stub.PutState(fxKey(rate.Base, rate.Quote), rateBytes)
+	if err != nil {
+		fmt.Println("Error setting FX rate")
+		return nil, errors.New("Error setting FX rate")
+	}
+	fmt.Println("Set FX rate " + rate.Base + "/" + rate.Quote + " " + rate.Rate.String())
+	return nil, nil
+}
+
+func getFXRate(stub StateStub, base string, quote string) (FXRate, error) {
+	var rate FXRate
+	rateBytes, err := stub.GetState(fxKey(base, quote))
+	if err != nil || rateBytes == nil {
+		return rate, errors.New("No FX rate for " + base + "/" + quote)
+	}
+	err = json.Unmarshal(rateBytes, &rate)
+	if err != nil {
+		fmt.Println("Error unmarshalling FX rate " + base + "/" + quote)
+		return rate, errors.New("Error retrieving FX rate " + base + "/" + quote)
+	}
+	return rate, nil
+}
+
+// convert exchanges amount into currency at the oracle's rate, using the
+// inverse of the opposite rate when only that one is set.
+func convert(stub StateStub, amount Money, currency string) (Money, error) {
+	if amount.Currency == currency {
+		return amount, nil
+	}
+	var factor *big.Rat
+	rate, err := getFXRate(stub, amount.Currency, currency)
+	if err == nil {
+		factor = rate.Rate.Rat()
+	} else {
+		inverse, inverseErr := getFXRate(stub, currency, amount.Currency)
+		if inverseErr != nil {
+			return amount, err
+		}
+		factor = new(big.Rat).Inv(inverse.Rate.Rat())
+	}
+	return moneyFromRat(new(big.Rat).Mul(amount.Rat(), factor), currency)
+}
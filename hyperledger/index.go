@@ -0,0 +1,241 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Composite keys index records by one or more attributes so they can be
+// listed with a range query instead of a single ever-growing list of keys.
+// They follow the layout the fabric shim uses: a zero byte, the object type
+// and each attribute, every part terminated by a zero byte.
+const (
+	compositeKeyNamespace = "\x00"
+	compositeKeySeparator = "\x00"
+	maxUnicodeRune        = string(utf8.MaxRune)
+)
+
+// Index object types. Index entries hold indexEntryValue; the record itself
+// lives under the key given by the last attribute.
+const (
+	contractIndex = "contract~bank~id"
+	docIndex      = "doc~cusip~id"
+)
+
+var indexEntryValue = []byte{0x00}
+
+func createCompositeKey(objectType string, attributes ...string) (string, error) {
+	key := compositeKeyNamespace + objectType + compositeKeySeparator
+	for _, attribute := range attributes {
+		if !utf8.ValidString(attribute) || strings.Contains(attribute, compositeKeySeparator) {
+			return "", errors.New("Invalid composite key attribute " + attribute)
+		}
+		key += attribute + compositeKeySeparator
+	}
+	return key, nil
+}
+
+func splitCompositeKey(key string) (string, []string, error) {
+	if !strings.HasPrefix(key, compositeKeyNamespace) {
+		return "", nil, errors.New("Not a composite key " + key)
+	}
+	parts := strings.Split(strings.TrimSuffix(key[len(compositeKeyNamespace):], compositeKeySeparator), compositeKeySeparator)
+	return parts[0], parts[1:], nil
+}
+
+// forEachInRange calls fn for every key starting with prefix, in key order.
+func forEachInRange(stub StateStub, prefix string, fn func(key string, value []byte) error) error {
+	iter, err := stub.RangeQueryState(prefix, prefix+maxUnicodeRune)
+	if err != nil {
+		fmt.Println("Error querying range " + prefix)
+		return errors.New("Error querying range " + prefix)
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			fmt.Println("Error iterating range " + prefix)
+			return errors.New("Error iterating range " + prefix)
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		err = fn(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachIndexed calls fn with the primary key of every entry in the index
+// whose leading attributes match the given ones.
+func forEachIndexed(stub StateStub, objectType string, attributes []string, fn func(primaryKey string) error) error {
+	prefix, err := createCompositeKey(objectType, attributes...)
+	if err != nil {
+		return err
+	}
+	return forEachInRange(stub, prefix, func(key string, value []byte) error {
+		_, parts, err := splitCompositeKey(key)
+		if err != nil {
+			return err
+		}
+		return fn(parts[len(parts)-1])
+	})
+}
+
+// putIndexEntry adds the primary key to an index under the given attributes.
+func putIndexEntry(stub StateStub, objectType string, attributes ...string) error {
+	key, err := createCompositeKey(objectType, attributes...)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, indexEntryValue)
+	if err != nil {
+		fmt.Println("Error writing index entry for " + objectType)
+		return errors.New("Error writing index entry for " + objectType)
+	}
+	return nil
+}
+
+// migrateKeyCollections indexes the records listed in the PaperKeys,
+// BankKeys and DocKeys collections written by earlier versions of the
+// chaincode, then removes the collections.
+func migrateKeyCollections(stub StateStub) error {
+	// KYC records already live under cpPrefix and need no index entry
+	_, err := legacyKeyCollection(stub, "PaperKeys")
+	if err != nil {
+		return err
+	}
+
+	contractIDs, err := legacyKeyCollection(stub, "BankKeys")
+	if err != nil {
+		return err
+	}
+	for _, contractID := range contractIDs {
+		bankcontract, err := getBankContract(stub, contractID)
+		if err != nil {
+			return err
+		}
+		err = putIndexEntry(stub, contractIndex, bankcontract.BANKID, contractID)
+		if err != nil {
+			return err
+		}
+	}
+
+	docKeys, err := legacyKeyCollection(stub, "DocKeys")
+	if err != nil {
+		return err
+	}
+	for _, docKey := range docKeys {
+		err = moveLegacyDoc(stub, docKey)
+		if err != nil {
+			return err
+		}
+		doc, err := getDoc(docKey, stub)
+		if err != nil {
+			return err
+		}
+		err = putIndexEntry(stub, docIndex, doc.CUSIP, docKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDocumentKeys moves indexed documents written under their bare ID
+// by earlier versions of the chaincode to docPrefix.
+func migrateDocumentKeys(stub StateStub) error {
+	var docIDs []string
+	err := forEachIndexed(stub, docIndex, nil, func(docID string) error {
+		docIDs = append(docIDs, docID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, docID := range docIDs {
+		err = moveLegacyDoc(stub, docID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveLegacyDoc moves a document from its bare ID to docPrefix + ID.
+func moveLegacyDoc(stub StateStub, docID string) error {
+	moved, err := stub.GetState(docPrefix + docID)
+	if err != nil {
+		fmt.Println("Error retrieving doc " + docID)
+		return errors.New("Error retrieving doc " + docID)
+	}
+	if moved != nil {
+		return nil
+	}
+	docBytes, err := stub.GetState(docID)
+	if err != nil {
+		fmt.Println("Error retrieving doc " + docID)
+		return errors.New("Error retrieving doc " + docID)
+	}
+	if docBytes == nil {
+		return nil
+	}
+	err = stub.PutState(docPrefix+docID, docBytes)
+	if err == nil {
+		err = stub.DelState(docID)
+	}
+	if err != nil {
+		fmt.Println("Error moving doc " + docID)
+		return errors.New("Error moving doc " + docID)
+	}
+	return nil
+}
+
+// legacyKeyCollection reads and deletes one of the old key collections.
+func legacyKeyCollection(stub StateStub, name string) ([]string, error) {
+	keysBytes, err := stub.GetState(name)
+	if err != nil {
+		fmt.Println("Error retrieving " + name)
+		return nil, errors.New("Error retrieving " + name)
+	}
+	if keysBytes == nil {
+		return nil, nil
+	}
+
+	var keys []string
+	err = json.Unmarshal(keysBytes, &keys)
+	if err != nil {
+		fmt.Println("Error unmarshalling " + name)
+		return nil, errors.New("Error unmarshalling " + name)
+	}
+	err = stub.DelState(name)
+	if err != nil {
+		fmt.Println("Error deleting " + name)
+		return nil, errors.New("Error deleting " + name)
+	}
+	return keys, nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kycSchemaVersion is written into every KYCRecord. Records without a
+// schemaVersion were written by the original chaincode using the CP layout.
+const kycSchemaVersion = 1
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar date serialised as "YYYY-MM-DD". An encrypted date
+// is zero and keeps its ciphertext in sealed until decrypted.
+type Date struct {
+	time.Time
+	sealed string
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.sealed != "" {
+		return json.Marshal(d.sealed)
+	}
+	if d.IsZero() {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(d.Format(dateLayout))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		d.Time = time.Time{}
+		return nil
+	}
+	if strings.HasPrefix(s, encryptedPrefix) {
+		d.Time, d.sealed = time.Time{}, s
+		return nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
+
+// KYCRecord is a customer's KYC data as stored under cpPrefix+CUSIP.
+type KYCRecord struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	CUSIP         string `json:"cusip"`
+	Contract      string `json:"contract"`
+	// ContractVersion is the version of the contract the record was
+	// onboarded under; 0 on records onboarded before versions were kept
+	ContractVersion int        `json:"contractVersion,omitempty"`
+	Name            string     `json:"name"`
+	Gender          string     `json:"gender"`
+	DateOfBirth     Date       `json:"dateOfBirth"`
+	LegacyAge       string     `json:"legacyAge,omitempty"`
+	City            string     `json:"city"`
+	State           string     `json:"state"`
+	Phone           string     `json:"phone"`
+	House           string     `json:"house"`
+	Street          string     `json:"street"`
+	Pin             string     `json:"pin"`
+	Email           string     `json:"email"`
+	Mobile          string     `json:"mobile"`
+	Fmrdata         string     `json:"fmrdata"`
+	Owner           string     `json:"owner"`
+	Filename        string     `json:"filename"`
+	Issuer          string     `json:"issuer"`
+	IssueDate       string     `json:"issueDate"`
+	Documents       []DOCUMENT `json:"documents"`
+	// OnboardingValue is the business the customer is onboarded for,
+	// which percentage commissions are worked out from
+	OnboardingValue *Money `json:"onboardingValue,omitempty"`
+
+	Status       KYCStatus          `json:"status"`
+	StatusReason string             `json:"statusReason,omitempty"`
+	SignOffs     []ValidatorSignOff `json:"signOffs"`
+	// Round counts the renewals that sent the record back for review
+	Round int `json:"round,omitempty"`
+
+	// RiskTier sets how long the record stays valid once verified, under
+	// the contract's validity policy. The bank or a validator sets it;
+	// RequestedRiskTier is the tier the customer asked for
+	RiskTier          string     `json:"riskTier,omitempty"`
+	RequestedRiskTier string     `json:"requestedRiskTier,omitempty"`
+	VerifiedAt        *time.Time `json:"verifiedAt,omitempty"`
+	ValidUntil        *time.Time `json:"validUntil,omitempty"`
+
+	// Encryption is set when the PII fields hold ciphertext
+	Encryption *FieldEncryption `json:"encryption,omitempty"`
+}
+
+// legacyCP is the layout the original chaincode used for KYC data, reusing
+// the commercial paper JSON tags. It is only used to decode old records.
+type legacyCP struct {
+	CUSIP     string `json:"cusip"`
+	Contract  string `json:"contract"`
+	Name      string `json:"ticker"`
+	Gender    string `json:"par"`
+	Age       string `json:"qty"`
+	City      string `json:"discount"`
+	State     string `json:"maturity"`
+	Phone     string `json:"phone"`
+	House     string `json:"house"`
+	Street    string `json:"street"`
+	Pin       string `json:"pin"`
+	Email     string `json:"email"`
+	Mobile    string `json:"mobile"`
+	Fmrdata   string `json:"fmrdata"`
+	Owner     string `json:"owner"`
+	Filename  string `json:"filename"`
+	Issuer    string `json:"issuer"`
+	IssueDate string `json:"issueDate"`
+	DOCUMENTS []DOCUMENT
+}
+
+func (l legacyCP) toKYCRecord() KYCRecord {
+	return KYCRecord{
+		SchemaVersion: kycSchemaVersion,
+		CUSIP:         l.CUSIP,
+		Contract:      l.Contract,
+		Name:          l.Name,
+		Gender:        l.Gender,
+		LegacyAge:     l.Age,
+		City:          l.City,
+		State:         l.State,
+		Phone:         l.Phone,
+		House:         l.House,
+		Street:        l.Street,
+		Pin:           l.Pin,
+		Email:         l.Email,
+		Mobile:        l.Mobile,
+		Fmrdata:       l.Fmrdata,
+		Owner:         l.Owner,
+		Filename:      l.Filename,
+		Issuer:        l.Issuer,
+		IssueDate:     l.IssueDate,
+		Documents:     l.DOCUMENTS,
+	}
+}
+
+// decodeKYCRecord decodes a stored record, converting records written with the
+// legacy CP layout into the current schema.
+func decodeKYCRecord(recordBytes []byte) (KYCRecord, error) {
+	var record KYCRecord
+
+	var header struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	err := json.Unmarshal(recordBytes, &header)
+	if err != nil {
+		return record, err
+	}
+
+	switch {
+	case header.SchemaVersion == 0:
+		var legacy legacyCP
+		err = json.Unmarshal(recordBytes, &legacy)
+		if err != nil {
+			return record, err
+		}
+		return legacy.toKYCRecord(), nil
+	case header.SchemaVersion > kycSchemaVersion:
+		return record, errors.New("Unsupported KYC record schema version " + strconv.Itoa(header.SchemaVersion))
+	}
+
+	err = json.Unmarshal(recordBytes, &record)
+	return record, err
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// defaultCurrency is the currency of amounts given without one.
+const defaultCurrency = "INR"
+
+// currencyExponents gives the number of minor units digits of each
+// supported ISO 4217 currency.
+var currencyExponents = map[string]int{
+	"INR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+}
+
+// Money is an amount held as an integer number of the currency's minor
+// units (paise, cents), so sums and comparisons are exact. It is written to
+// JSON as a string such as "1234.50 USD"; amounts written as plain JSON
+// numbers by earlier versions are read in defaultCurrency.
+//
+// Amounts computed from rates are rounded once, to the nearest minor unit
+// with ties to even (banker's rounding).
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+func currencyExponent(currency string) (int, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return 0, errors.New("Unsupported currency " + currency)
+	}
+	return exponent, nil
+}
+
+func minorPerUnit(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
+
+// wholeMoney returns a whole number of units of currency.
+func wholeMoney(units int64, currency string) Money {
+	exponent, err := currencyExponent(currency)
+	if err != nil {
+		panic(err)
+	}
+	return Money{Minor: units * minorPerUnit(exponent).Int64(), Currency: currency}
+}
+
+// ParseMoney parses "1234.50 USD", or "1234.50" in defaultCurrency. The
+// amount may not have more decimals than the currency's minor units.
+func ParseMoney(s string) (Money, error) {
+	fields := strings.Fields(s)
+	currency := defaultCurrency
+	switch len(fields) {
+	case 1:
+	case 2:
+		currency = strings.ToUpper(fields[1])
+	default:
+		return Money{}, errors.New("Invalid amount " + s)
+	}
+	amount, ok := new(big.Rat).SetString(fields[0])
+	if !ok || strings.ContainsAny(fields[0], "/eE") {
+		return Money{}, errors.New("Invalid amount " + s)
+	}
+	exponent, err := currencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	minor := new(big.Rat).Mul(amount, new(big.Rat).SetInt(minorPerUnit(exponent)))
+	if !minor.IsInt() || !minor.Num().IsInt64() {
+		return Money{}, errors.New("Amount " + s + " has more precision than " + currency + " allows")
+	}
+	return Money{Minor: minor.Num().Int64(), Currency: currency}, nil
+}
+
+// moneyFromRat rounds an exact amount of currency to its minor units.
+func moneyFromRat(amount *big.Rat, currency string) (Money, error) {
+	exponent, err := currencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	minor := new(big.Rat).Mul(amount, new(big.Rat).SetInt(minorPerUnit(exponent)))
+	rounded := roundHalfEven(minor)
+	if !rounded.IsInt64() {
+		return Money{}, errors.New("Amount out of range")
+	}
+	return Money{Minor: rounded.Int64(), Currency: currency}, nil
+}
+
+func roundHalfEven(r *big.Rat) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	// Compare twice the remainder with the denominator to find the nearest
+	twice := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2))
+	cmp := twice.Cmp(r.Denom())
+	if cmp > 0 || (cmp == 0 && quotient.Bit(0) == 1) {
+		if r.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return quotient
+}
+
+// Rat returns the amount in units of the currency.
+func (m Money) Rat() *big.Rat {
+	exponent, err := currencyExponent(m.Currency)
+	if err != nil {
+		exponent = 0
+	}
+	return new(big.Rat).SetFrac(big.NewInt(m.Minor), minorPerUnit(exponent))
+}
+
+func (m Money) String() string {
+	exponent, err := currencyExponent(m.Currency)
+	if err != nil {
+		return strconv.FormatInt(m.Minor, 10) + " " + m.Currency
+	}
+	return m.Rat().FloatString(exponent) + " " + m.Currency
+}
+
+func (m Money) Sign() int {
+	switch {
+	case m.Minor < 0:
+		return -1
+	case m.Minor > 0:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) sameCurrency(o Money) error {
+	if m.Currency != o.Currency {
+		return errors.New("Currency mismatch: " + m.Currency + " and " + o.Currency)
+	}
+	return nil
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return m, err
+	}
+	sum := m.Minor + o.Minor
+	if (o.Minor > 0 && sum < m.Minor) || (o.Minor < 0 && sum > m.Minor) {
+		return m, errors.New("Amount out of range")
+	}
+	return Money{Minor: sum, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(Money{Minor: -o.Minor, Currency: o.Currency})
+}
+
+// LessThan compares two amounts of the same currency.
+func (m Money) LessThan(o Money) (bool, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return false, err
+	}
+	return m.Minor < o.Minor, nil
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := ParseMoney(s)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	// Earlier versions wrote amounts as JSON numbers
+	var legacy json.Number
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	amount, ok := new(big.Rat).SetString(legacy.String())
+	if !ok {
+		return errors.New("Invalid amount " + legacy.String())
+	}
+	parsed, err := moneyFromRat(amount, defaultCurrency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Rate is an exact decimal such as a discount rate in percent. It is
+// written to JSON as a string; plain JSON numbers are also accepted.
+type Rate struct {
+	value *big.Rat
+}
+
+func ParseRate(s string) (Rate, error) {
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok || strings.ContainsAny(s, "/eE") {
+		return Rate{}, errors.New("Invalid rate " + s)
+	}
+	return Rate{value: value}, nil
+}
+
+// Rat returns the rate's value; the zero Rate is 0.
+func (r Rate) Rat() *big.Rat {
+	if r.value == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Set(r.value)
+}
+
+// String writes the rate with as many decimals as it needs, up to 18.
+func (r Rate) String() string {
+	s := r.Rat().FloatString(18)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var legacy json.Number
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		s = legacy.String()
+	}
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	for in, want := range map[string]string{
+		"1234.5 inr": "1234.50 INR",
+		"1234":       "1234.00 INR",
+		"-0.01 USD":  "-0.01 USD",
+		"500 JPY":    "500 JPY",
+	} {
+		m, err := ParseMoney(in)
+		if err != nil || m.String() != want {
+			t.Errorf("ParseMoney(%q) = %v, %v; want %s", in, m, err, want)
+		}
+	}
+	for _, in := range []string{"1.005 INR", "1.5 JPY", "12 XYZ", "1e3", "1/3", "", "1 INR extra"} {
+		if _, err := ParseMoney(in); err == nil {
+			t.Errorf("expected ParseMoney(%q) to fail", in)
+		}
+	}
+}
+
+func TestMoneyRoundsHalfToEven(t *testing.T) {
+	for _, tc := range []struct {
+		num, den int64
+		want     string
+	}{
+		{1005, 1000, "1.00 INR"},
+		{1015, 1000, "1.02 INR"},
+		{-1005, 1000, "-1.00 INR"},
+		{10051, 10000, "1.01 INR"},
+	} {
+		m, err := moneyFromRat(big.NewRat(tc.num, tc.den), "INR")
+		if err != nil || m.String() != tc.want {
+			t.Errorf("%d/%d: got %v, %v; want %s", tc.num, tc.den, m, err, tc.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var account Account
+	if err := json.Unmarshal([]byte(`{"cashBalance":2500000.005}`), &account); err != nil {
+		t.Fatal(err)
+	}
+	if account.balance(defaultCurrency).String() != "2500000.00 INR" {
+		t.Errorf("legacy balance read as %v", account.Balances)
+	}
+	out, err := json.Marshal(&account.Balances)
+	if err != nil || string(out) != `{"INR":"2500000.00 INR"}` {
+		t.Errorf("balance written as %s, %v", out, err)
+	}
+
+	var rate Rate
+	if err := json.Unmarshal([]byte(`7.5`), &rate); err != nil || rate.String() != "7.5" {
+		t.Errorf("rate read as %v, %v", rate, err)
+	}
+}
+
+func TestDebitAccountWithoutBalances(t *testing.T) {
+	var account Account
+	if err := json.Unmarshal([]byte(`{"id":"company1"}`), &account); err != nil {
+		t.Fatal(err)
+	}
+	if err := account.debit(wholeMoney(0, defaultCurrency)); err != nil {
+		t.Fatal(err)
+	}
+	if err := account.debit(wholeMoney(1, defaultCurrency)); err == nil {
+		t.Error("expected debiting an empty account to fail")
+	}
+	if err := account.credit(wholeMoney(5, "USD")); err != nil || account.balance("USD").String() != "5.00 USD" {
+		t.Errorf("credit gave %v, %v", account.Balances, err)
+	}
+}
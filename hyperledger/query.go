@@ -0,0 +1,287 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// PageRequest is the argument to the QueryCPs, QueryContracts and QueryDocs
+// queries. Bookmark is the opaque token returned with the previous page;
+// leave it empty for the first page. Filter depends on the query.
+//
+//	{"pageSize": 20, "bookmark": "", "filter": {"owner": "bank1"}}
+type PageRequest struct {
+	PageSize int             `json:"pageSize"`
+	Bookmark string          `json:"bookmark"`
+	Filter   json.RawMessage `json:"filter"`
+}
+
+// Page is the envelope query results are returned in. Bookmark is empty
+// once there are no more results.
+type Page struct {
+	Results  interface{} `json:"results"`
+	Count    int         `json:"count"`
+	Bookmark string      `json:"bookmark"`
+}
+
+// KYCFilter selects KYC records for QueryCPs. Empty fields match anything;
+// IssuedFrom and IssuedTo bound the issue date in milliseconds, inclusive.
+type KYCFilter struct {
+	Issuer     string    `json:"issuer"`
+	Owner      string    `json:"owner"`
+	Contract   string    `json:"contract"`
+	Status     KYCStatus `json:"status"`
+	State      string    `json:"state"`
+	City       string    `json:"city"`
+	IssuedFrom string    `json:"issuedFrom"`
+	IssuedTo   string    `json:"issuedTo"`
+}
+
+// ContractFilter selects bank contracts for QueryContracts.
+type ContractFilter struct {
+	Bank string `json:"bank"`
+}
+
+// DocFilter selects documents for QueryDocs.
+type DocFilter struct {
+	CUSIP        string `json:"cusip"`
+	DocumentType string `json:"documentType"`
+}
+
+func parsePageRequest(args []string, filter interface{}) (PageRequest, error) {
+	var request PageRequest
+	if len(args) > 0 && args[0] != "" {
+		err := json.Unmarshal([]byte(args[0]), &request)
+		if err != nil {
+			fmt.Println("Error unmarshalling page request")
+			return request, errors.New("Invalid page request")
+		}
+	}
+	if request.PageSize == 0 {
+		request.PageSize = defaultPageSize
+	}
+	if request.PageSize < 0 || request.PageSize > maxPageSize {
+		return request, errors.New("Page size must be between 1 and " + strconv.Itoa(maxPageSize))
+	}
+	if len(request.Filter) > 0 {
+		err := json.Unmarshal(request.Filter, filter)
+		if err != nil {
+			fmt.Println("Error unmarshalling filter")
+			return request, errors.New("Invalid filter")
+		}
+	}
+	return request, nil
+}
+
+// pageRange walks the keys under prefix, resuming after the request's
+// bookmark, and hands each to fn until fn has accepted a full page. It
+// returns the bookmark for the next page, or "" when the range is exhausted.
+func pageRange(stub StateStub, prefix string, request PageRequest, fn func(key string, value []byte) (bool, error)) (string, error) {
+	startKey := prefix
+	if request.Bookmark != "" {
+		lastKey, err := base64.URLEncoding.DecodeString(request.Bookmark)
+		if err != nil || !strings.HasPrefix(string(lastKey), prefix) {
+			return "", errors.New("Invalid bookmark")
+		}
+		// The smallest key after the last one returned
+		startKey = string(lastKey) + "\x00"
+	}
+
+	iter, err := stub.RangeQueryState(startKey, prefix+maxUnicodeRune)
+	if err != nil {
+		fmt.Println("Error querying range " + prefix)
+		return "", errors.New("Error querying range " + prefix)
+	}
+	defer iter.Close()
+
+	count := 0
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			fmt.Println("Error iterating range " + prefix)
+			return "", errors.New("Error iterating range " + prefix)
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		accepted, err := fn(key, value)
+		if err != nil {
+			return "", err
+		}
+		if accepted {
+			count++
+		}
+		if count == request.PageSize {
+			if iter.HasNext() {
+				return base64.URLEncoding.EncodeToString([]byte(key)), nil
+			}
+			break
+		}
+	}
+	return "", nil
+}
+
+func (f KYCFilter) matches(record KYCRecord) bool {
+	switch {
+	case f.Issuer != "" && record.Issuer != f.Issuer,
+		f.Owner != "" && record.Owner != f.Owner,
+		f.Contract != "" && record.Contract != f.Contract,
+		f.Status != "" && record.Status != f.Status,
+		f.State != "" && !strings.EqualFold(record.State, f.State),
+		f.City != "" && !strings.EqualFold(record.City, f.City):
+		return false
+	}
+	if f.IssuedFrom != "" || f.IssuedTo != "" {
+		issued, err := strconv.ParseInt(record.IssueDate, 10, 64)
+		if err != nil {
+			return false
+		}
+		if f.IssuedFrom != "" {
+			from, err := strconv.ParseInt(f.IssuedFrom, 10, 64)
+			if err != nil || issued < from {
+				return false
+			}
+		}
+		if f.IssuedTo != "" {
+			to, err := strconv.ParseInt(f.IssuedTo, 10, 64)
+			if err != nil || issued > to {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// queryCPs returns a page of KYC records. Records the caller may not read
+// in full are redacted before the filter is applied, so personal fields
+// cannot be probed through it.
+func queryCPs(stub StateStub, args []string) (Page, error) {
+	var filter KYCFilter
+	request, err := parsePageRequest(args, &filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	reader := newKYCReader(stub)
+	results := []KYCRecord{}
+	bookmark, err := pageRange(stub, cpPrefix, request, func(key string, cpBytes []byte) (bool, error) {
+		record, err := decodeKYCRecord(cpBytes)
+		if err != nil {
+			fmt.Println("Error retrieving cp " + key)
+			return false, errors.New("Error retrieving cp " + key)
+		}
+		record = reader.readable(record)
+		if !filter.matches(record) {
+			return false, nil
+		}
+		results = append(results, record)
+		return true, nil
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	return Page{Results: results, Count: len(results), Bookmark: bookmark}, nil
+}
+
+// queryContracts returns a page of bank contracts, optionally for one bank.
+func queryContracts(stub StateStub, args []string) (Page, error) {
+	var filter ContractFilter
+	request, err := parsePageRequest(args, &filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	var attributes []string
+	if filter.Bank != "" {
+		attributes = []string{filter.Bank}
+	}
+	prefix, err := createCompositeKey(contractIndex, attributes...)
+	if err != nil {
+		return Page{}, err
+	}
+
+	results := []BANKCONTRACT{}
+	bookmark, err := pageRange(stub, prefix, request, func(key string, value []byte) (bool, error) {
+		_, parts, err := splitCompositeKey(key)
+		if err != nil {
+			return false, err
+		}
+		bankcontract, err := getBankContract(stub, parts[len(parts)-1])
+		if err != nil {
+			return false, err
+		}
+		results = append(results, bankcontract)
+		return true, nil
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	return Page{Results: results, Count: len(results), Bookmark: bookmark}, nil
+}
+
+// queryDocs returns a page of documents, optionally for one customer.
+func queryDocs(stub StateStub, args []string) (Page, error) {
+	var filter DocFilter
+	request, err := parsePageRequest(args, &filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	var attributes []string
+	if filter.CUSIP != "" {
+		attributes = []string{filter.CUSIP}
+	}
+	prefix, err := createCompositeKey(docIndex, attributes...)
+	if err != nil {
+		return Page{}, err
+	}
+
+	reader := newKYCReader(stub)
+	results := []DOCUMENT{}
+	bookmark, err := pageRange(stub, prefix, request, func(key string, value []byte) (bool, error) {
+		_, parts, err := splitCompositeKey(key)
+		if err != nil {
+			return false, err
+		}
+		doc, err := getDoc(parts[len(parts)-1], stub)
+		if err != nil {
+			return false, err
+		}
+		if filter.DocumentType != "" && doc.DOCUMENTTYPE != filter.DocumentType {
+			return false, nil
+		}
+		results = append(results, reader.readableDoc(doc))
+		return true, nil
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	return Page{Results: results, Count: len(results), Bookmark: bookmark}, nil
+}
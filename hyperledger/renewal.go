@@ -0,0 +1,288 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidityPolicy says how long a verified KYC record stays valid before it
+// must be verified again, in months by the customer's risk tier:
+//
+//	{"tiers": {"high": 24, "medium": 96, "low": 120}, "defaultTier": "medium"}
+//
+// Records submitted without a risk tier are in the default tier.
+type ValidityPolicy struct {
+	Tiers       map[string]int `json:"tiers"`
+	DefaultTier string         `json:"defaultTier"`
+}
+
+func (p ValidityPolicy) validate() error {
+	if len(p.Tiers) == 0 {
+		return errors.New("A validity policy needs at least one risk tier")
+	}
+	for tier, months := range p.Tiers {
+		if months < 1 {
+			return errors.New("Risk tier " + tier + " must stay valid for at least a month")
+		}
+	}
+	if _, ok := p.Tiers[p.DefaultTier]; !ok {
+		return errors.New("Default risk tier " + p.DefaultTier + " is not one of the policy's tiers")
+	}
+	return nil
+}
+
+// riskTier checks the risk tier a record is submitted in under the
+// contract, defaulting it from the contract's validity policy.
+func (c BANKCONTRACT) riskTier(requested string) (string, error) {
+	if c.VALIDITYPOLICY == nil {
+		return requested, nil
+	}
+	if requested == "" {
+		return c.VALIDITYPOLICY.DefaultTier, nil
+	}
+	if _, ok := c.VALIDITYPOLICY.Tiers[requested]; !ok {
+		return "", errors.New("Bank Contract " + c.CONTRACTID + " has no risk tier " + requested)
+	}
+	return requested, nil
+}
+
+// setRiskTier puts the record in a risk tier under the contract, keeping
+// its current tier, or the policy's default, when none is given. Only the
+// bank or a validator decides the tier; anyone else's choice is kept as a
+// request for them to consider.
+func (record *KYCRecord) setRiskTier(bankcontract BANKCONTRACT, caller Caller, tier string) error {
+	if tier != "" && !caller.isBankAdmin(bankcontract.BANKID) && caller.Role != RoleValidator {
+		_, err := bankcontract.riskTier(tier)
+		if err != nil {
+			return err
+		}
+		record.RequestedRiskTier = tier
+		tier = ""
+	}
+	if tier == "" {
+		tier = record.RiskTier
+	}
+	tier, err := bankcontract.riskTier(tier)
+	if err != nil {
+		return err
+	}
+	record.RiskTier = tier
+	return nil
+}
+
+// startValidity stamps a record verified at a time with the end of its
+// validity under the contract. Records under contracts without a validity
+// policy stay valid.
+func (record *KYCRecord) startValidity(bankcontract BANKCONTRACT, at time.Time) error {
+	verifiedAt := at
+	record.VerifiedAt = &verifiedAt
+	record.ValidUntil = nil
+	if bankcontract.VALIDITYPOLICY == nil {
+		return nil
+	}
+	tier, err := bankcontract.riskTier(record.RiskTier)
+	if err != nil {
+		return err
+	}
+	record.RiskTier = tier
+	validUntil := at.AddDate(0, bankcontract.VALIDITYPOLICY.Tiers[tier], 0)
+	record.ValidUntil = &validUntil
+	return nil
+}
+
+// validAt reports whether a record is verified and within its validity at
+// a time.
+func (record KYCRecord) validAt(at time.Time) bool {
+	return record.Status == StatusVerified && (record.ValidUntil == nil || at.Before(*record.ValidUntil))
+}
+
+// Renewal is the argument to renewKYC.
+type Renewal struct {
+	CUSIP    string `json:"cusip"`
+	RiskTier string `json:"riskTier"`
+}
+
+// renewKYC sends a verified or expired record back to the validators under
+// the version of its contract now in effect. The earlier sign-offs stay on
+// the record from an earlier review round.
+func (t *SimpleChaincode) renewKYC(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			json
+			{
+				"cusip": "",
+				"riskTier": "" (optional, the record's current tier if omitted; only a request unless the bank renews)
+			}
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting renewal")
+	}
+	var renewal Renewal
+	err := json.Unmarshal([]byte(args[0]), &renewal)
+	if err != nil {
+		fmt.Println("Error unmarshalling renewal")
+		return nil, errors.New("Invalid renewal")
+	}
+	cp, err := getCustomerRecord(stub, renewal.CUSIP)
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	bankcontract, err := contractInEffect(stub, cp.Contract, now)
+	if err != nil {
+		return nil, err
+	}
+	if bankcontract.status() != ContractActive {
+		fmt.Println("Bank Contract " + cp.Contract + " is " + bankcontract.status())
+		return nil, errors.New("Bank Contract " + cp.Contract + " is " + strings.ToLower(bankcontract.status()) + " and not renewing customers")
+	}
+	caller, err := requireCaller(stub, cp.Issuer, bankcontract.BANKID)
+	if err != nil {
+		return nil, err
+	}
+	if cp.Status == "" {
+		cp.Status = legacyKYCStatus(cp, bankcontract, bankcontract.validatorList())
+	}
+
+	err = cp.transition(StatusSubmitted, "")
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	err = cp.setRiskTier(bankcontract, caller, renewal.RiskTier)
+	if err != nil {
+		return nil, err
+	}
+	cp.ContractVersion = bankcontract.version()
+	cp.Round++
+	cp.Owner = bankcontract.approvalPolicy().firstOwner(bankcontract)
+
+	cpBytes, err := json.Marshal(&cp)
+	if err != nil {
+		fmt.Println("Error marshalling the cp")
+		return nil, errors.New("Error marshalling the cp")
+	}
+	err = putWithHistory(stub, cpPrefix+cp.CUSIP, cpBytes, HistoryCUSIP, cp.CUSIP, "renewKYC")
+	if err != nil {
+		fmt.Println("Error writing the cp back")
+		return nil, errors.New("Error writing the cp back")
+	}
+	err = setEvent(stub, Event{Type: EventKYCRenewed, CUSIP: cp.CUSIP, Contract: cp.Contract, Bank: bankcontract.BANKID,
+		Issuer: cp.Issuer, To: cp.Owner, Status: cp.Status})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Renewing KYC record " + cp.CUSIP + ", review round " + strconv.Itoa(cp.Round))
+	return nil, nil
+}
+
+// expireKYC marks a verified record whose validity has ended as expired.
+// Whether it has ended is a matter of record, so anyone may do it.
+func (t *SimpleChaincode) expireKYC(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			cusip
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting CUSIP")
+	}
+	_, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := getCustomerRecord(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if cp.Status != StatusVerified || cp.validAt(now) {
+		fmt.Println("KYC record " + cp.CUSIP + " has not expired")
+		return nil, errors.New("KYC record " + cp.CUSIP + " has not expired")
+	}
+	err = cp.transition(StatusExpired, "validity ended")
+	if err != nil {
+		return nil, err
+	}
+
+	cpBytes, err := json.Marshal(&cp)
+	if err != nil {
+		fmt.Println("Error marshalling the cp")
+		return nil, errors.New("Error marshalling the cp")
+	}
+	err = putWithHistory(stub, cpPrefix+cp.CUSIP, cpBytes, HistoryCUSIP, cp.CUSIP, "expireKYC")
+	if err != nil {
+		fmt.Println("Error writing the cp back")
+		return nil, errors.New("Error writing the cp back")
+	}
+	err = setEvent(stub, Event{Type: EventKYCExpired, CUSIP: cp.CUSIP, Contract: cp.Contract, Issuer: cp.Issuer, Status: cp.Status})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Expired KYC record " + cp.CUSIP)
+	return nil, nil
+}
+
+// getExpiringKYC returns the verified and expired records whose validity
+// ends from from up to to (milliseconds), optionally under one contract.
+func getExpiringKYC(stub StateStub, args []string) ([]KYCRecord, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting the start and end of the window in milliseconds and an optional contract")
+	}
+	from, err := msToTime(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid start of window " + args[0])
+	}
+	to, err := msToTime(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid end of window " + args[1])
+	}
+	contract := ""
+	if len(args) > 2 {
+		contract = args[2]
+	}
+
+	records, err := GetAllCPs(stub)
+	if err != nil {
+		return nil, err
+	}
+	expiring := []KYCRecord{}
+	for _, record := range records {
+		if record.Status != StatusVerified && record.Status != StatusExpired {
+			continue
+		}
+		if record.ValidUntil == nil || record.ValidUntil.Before(from) || !record.ValidUntil.Before(to) {
+			continue
+		}
+		if contract != "" && record.Contract != contract {
+			continue
+		}
+		expiring = append(expiring, record)
+	}
+	return readableKYCRecords(stub, expiring), nil
+}
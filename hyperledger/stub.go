@@ -0,0 +1,118 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// StateStub is the part of the chaincode stub used by the chaincode
+// functions. Peers hand us a *shim.ChaincodeStub, which is wrapped in a
+// shimStub; tests supply an in-memory implementation instead.
+type StateStub interface {
+	GetState(key string) ([]byte, error)
+	PutState(key string, value []byte) error
+	DelState(key string) error
+	RangeQueryState(startKey, endKey string) (StateRangeIterator, error)
+
+	// GetCallerCertificate returns the enrollment certificate of the
+	// submitter and GetCallerMetadata the metadata attached to the call.
+	// ReadCertAttribute returns an attribute certified in the submitter's
+	// transaction certificate.
+	GetCallerCertificate() ([]byte, error)
+	GetCallerMetadata() ([]byte, error)
+	ReadCertAttribute(attributeName string) ([]byte, error)
+
+	GetTxID() string
+	GetTxTimestamp() (time.Time, error)
+
+	// SetEvent raises a chaincode event with the transaction. Only the
+	// last event set in a transaction is delivered.
+	SetEvent(name string, payload []byte) error
+}
+
+// StateRangeIterator walks the keys returned by RangeQueryState in order.
+type StateRangeIterator interface {
+	HasNext() bool
+	Next() (string, []byte, error)
+	Close() error
+}
+
+// shimStub adapts *shim.ChaincodeStub to StateStub.
+type shimStub struct {
+	stub *shim.ChaincodeStub
+}
+
+func newShimStub(stub *shim.ChaincodeStub) StateStub {
+	return shimStub{stub: stub}
+}
+
+func (s shimStub) GetState(key string) ([]byte, error) {
+	return s.stub.GetState(key)
+}
+
+func (s shimStub) PutState(key string, value []byte) error {
+	return s.stub.PutState(key, value)
+}
+
+func (s shimStub) DelState(key string) error {
+	return s.stub.DelState(key)
+}
+
+func (s shimStub) RangeQueryState(startKey, endKey string) (StateRangeIterator, error) {
+	iter, err := s.stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return iter, nil
+}
+
+func (s shimStub) GetCallerCertificate() ([]byte, error) {
+	return s.stub.GetCallerCertificate()
+}
+
+func (s shimStub) GetCallerMetadata() ([]byte, error) {
+	return s.stub.GetCallerMetadata()
+}
+
+func (s shimStub) ReadCertAttribute(attributeName string) ([]byte, error) {
+	return s.stub.ReadCertAttribute(attributeName)
+}
+
+func (s shimStub) GetTxID() string {
+	return s.stub.UUID
+}
+
+func (s shimStub) GetTxTimestamp() (time.Time, error) {
+	ts, err := s.stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ts == nil {
+		return time.Time{}, errors.New("Transaction timestamp not available")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+func (s shimStub) SetEvent(name string, payload []byte) error {
+	return s.stub.SetEvent(name, payload)
+}
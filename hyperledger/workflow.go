@@ -0,0 +1,224 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// KYCStatus is the position of a KYC record in the verification workflow.
+type KYCStatus string
+
+const (
+	StatusSubmitted         KYCStatus = "SUBMITTED"
+	StatusUnderReview       KYCStatus = "UNDER_REVIEW"
+	StatusValidatorApproved KYCStatus = "VALIDATOR_APPROVED"
+	StatusRejected          KYCStatus = "REJECTED"
+	StatusVerified          KYCStatus = "VERIFIED"
+	StatusExpired           KYCStatus = "EXPIRED"
+)
+
+// kycTransitions lists the statuses each status may move to.
+var kycTransitions = map[KYCStatus][]KYCStatus{
+	StatusSubmitted:         {StatusUnderReview, StatusValidatorApproved, StatusRejected},
+	StatusUnderReview:       {StatusValidatorApproved, StatusRejected},
+	StatusValidatorApproved: {StatusVerified, StatusRejected},
+	StatusVerified:          {StatusExpired, StatusSubmitted},
+	StatusRejected:          {},
+	StatusExpired:           {StatusSubmitted},
+}
+
+// Sign-off decisions recorded by validators.
+const (
+	DecisionApproved = "APPROVED"
+	DecisionRejected = "REJECTED"
+)
+
+// ValidatorSignOff is one validator's decision on a KYC record, in the
+// record's review round at the time.
+type ValidatorSignOff struct {
+	Validator string    `json:"validator"`
+	Decision  string    `json:"decision"`
+	Reason    string    `json:"reason,omitempty"`
+	TxID      string    `json:"txID"`
+	Timestamp time.Time `json:"timestamp"`
+	Round     int       `json:"round,omitempty"`
+}
+
+// Rejection is the argument to rejectKYC.
+type Rejection struct {
+	CUSIP     string `json:"cusip"`
+	Validator string `json:"validator"`
+	Reason    string `json:"reason"`
+}
+
+func (s KYCStatus) canMoveTo(to KYCStatus) bool {
+	for _, allowed := range kycTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitingValidation reports whether validators may still act on a record.
+func (s KYCStatus) awaitingValidation() bool {
+	return s == StatusSubmitted || s == StatusUnderReview
+}
+
+// transition moves the record to the given status, failing if the workflow
+// does not allow it. A reason is required when rejecting.
+func (record *KYCRecord) transition(to KYCStatus, reason string) error {
+	if !record.Status.canMoveTo(to) {
+		return errors.New("KYC record " + record.CUSIP + " cannot move from " + string(record.Status) + " to " + string(to))
+	}
+	if to == StatusRejected && reason == "" {
+		return errors.New("A reason is required to reject KYC record " + record.CUSIP)
+	}
+	record.Status = to
+	record.StatusReason = reason
+	return nil
+}
+
+// signOff records a validator's decision against the current transaction.
+func (record *KYCRecord) signOff(stub StateStub, validator string, decision string, reason string) error {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	record.SignOffs = append(record.SignOffs, ValidatorSignOff{
+		Validator: validator,
+		Decision:  decision,
+		Reason:    reason,
+		TxID:      stub.GetTxID(),
+		Timestamp: timestamp,
+		Round:     record.Round,
+	})
+	return nil
+}
+
+// currentSignOffs returns the sign-offs of the record's current review
+// round; those of earlier rounds verified it before it was renewed.
+func (record KYCRecord) currentSignOffs() []ValidatorSignOff {
+	var signOffs []ValidatorSignOff
+	for _, signOff := range record.SignOffs {
+		if signOff.Round == record.Round {
+			signOffs = append(signOffs, signOff)
+		}
+	}
+	return signOffs
+}
+
+// legacyKYCStatus infers the status of a record written before the workflow
+// was recorded, from where the record currently sits.
+func legacyKYCStatus(record KYCRecord, bankcontract BANKCONTRACT, validators []string) KYCStatus {
+	switch {
+	case record.Owner == bankcontract.BANKID:
+		return StatusVerified
+	case len(validators) > 0 && record.Owner == validators[0]:
+		return StatusSubmitted
+	default:
+		return StatusUnderReview
+	}
+}
+
+func (t *SimpleChaincode) rejectKYC(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			json
+			{
+				"cusip": "",
+				"validator": "",
+				"reason": ""
+			}
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting rejection record")
+	}
+
+	var rejection Rejection
+	err := json.Unmarshal([]byte(args[0]), &rejection)
+	if err != nil {
+		fmt.Println("Error unmarshalling rejection")
+		return nil, errors.New("Invalid rejection")
+	}
+	_, err = requireRole(stub, rejection.Validator, RoleValidator)
+	if err != nil {
+		return nil, err
+	}
+
+	cpBytes, err := stub.GetState(cpPrefix + rejection.CUSIP)
+	if err != nil || cpBytes == nil {
+		fmt.Println("CUSIP not found " + rejection.CUSIP)
+		return nil, errors.New("CUSIP not found " + rejection.CUSIP)
+	}
+	cp, err := decodeKYCRecord(cpBytes)
+	if err != nil {
+		fmt.Println("Error unmarshalling cp " + rejection.CUSIP)
+		return nil, errors.New("Error unmarshalling cp " + rejection.CUSIP)
+	}
+
+	bankcontract, err := contractForRecord(stub, cp)
+	if err != nil {
+		return nil, err
+	}
+	validators := bankcontract.validatorList()
+	if cp.Status == "" {
+		cp.Status = legacyKYCStatus(cp, bankcontract, validators)
+	}
+	if !cp.Status.awaitingValidation() {
+		fmt.Println("KYC record " + rejection.CUSIP + " is not awaiting validation, status " + string(cp.Status))
+		return nil, errors.New("KYC record " + rejection.CUSIP + " is not awaiting validation, status " + string(cp.Status))
+	}
+	err = bankcontract.approvalPolicy().checkReviewer(cp, validators, rejection.Validator)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	err = cp.transition(StatusRejected, rejection.Reason)
+	if err != nil {
+		return nil, err
+	}
+	err = cp.signOff(stub, rejection.Validator, DecisionRejected, rejection.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	cpBytesToWrite, err := json.Marshal(&cp)
+	if err != nil {
+		fmt.Println("Error marshalling the cp")
+		return nil, errors.New("Error marshalling the cp")
+	}
+	err = putWithHistory(stub, cpPrefix+rejection.CUSIP, cpBytesToWrite, HistoryCUSIP, rejection.CUSIP, "rejectKYC")
+	if err != nil {
+		fmt.Println("Error writing the cp back")
+		return nil, errors.New("Error writing the cp back")
+	}
+	err = setEvent(stub, Event{Type: EventKYCRejected, CUSIP: cp.CUSIP, Contract: cp.Contract, Bank: bankcontract.BANKID,
+		Validator: rejection.Validator, Status: cp.Status})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Rejected KYC record " + rejection.CUSIP + ": " + rejection.Reason)
+	return nil, nil
+}
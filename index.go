@@ -0,0 +1,54 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxUnicodeRune = string(utf8.MaxRune)
+
+// forEachInRange calls fn for every key starting with prefix, in key order.
+func forEachInRange(stub StateStub, prefix string, fn func(key string, value []byte) error) error {
+	iter, err := stub.RangeQueryState(prefix, prefix+maxUnicodeRune)
+	if err != nil {
+		fmt.Println("Error querying range " + prefix)
+		return errors.New("Error querying range " + prefix)
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			fmt.Println("Error iterating range " + prefix)
+			return errors.New("Error iterating range " + prefix)
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		err = fn(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// hasMatured reports whether the paper's maturity date has been reached.
+func (cp CP) hasMatured(now time.Time) (bool, error) {
+	maturity, err := cp.maturityDate()
+	if err != nil {
+		return false, err
+	}
+	return !truncateToDay(now).Before(maturity), nil
+}
+
+// redeemPaper pays out a paper on or after its maturity date: the issuer
+// pays every other owner par for each unit held, and the paper is marked
+// matured so it can no longer be traded or redeemed again.
+func (t *SimpleChaincode) redeemPaper(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			cusip
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting CUSIP")
+	}
+	cusip := args[0]
+
+	cpBytes, err := stub.GetState(cpPrefix + cusip)
+	if err != nil || cpBytes == nil {
+		fmt.Println("CUSIP not found")
+		return nil, errors.New("CUSIP not found " + cusip)
+	}
+	var cp CP
+	err = json.Unmarshal(cpBytes, &cp)
+	if err != nil {
+		fmt.Println("Error unmarshalling cp " + cusip)
+		return nil, errors.New("Error unmarshalling cp " + cusip)
+	}
+	if cp.Matured {
+		fmt.Println("Paper " + cusip + " has already been redeemed")
+		return nil, errors.New("Paper " + cusip + " has already been redeemed")
+	}
+
+	if cp.Escrowed > 0 {
+		fmt.Println("Paper " + cusip + " has unsettled trades")
+		return nil, errors.New("Paper " + cusip + " has " + strconv.Itoa(cp.Escrowed) + " units in escrow; settle or refund them first")
+	}
+
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		fmt.Println("Error getting the transaction time")
+		return nil, err
+	}
+	matured, err := cp.hasMatured(now)
+	if err != nil {
+		return nil, err
+	}
+	if !matured {
+		maturity, _ := cp.maturityDate()
+		fmt.Println("Paper " + cusip + " does not mature until " + maturity.Format("2006-01-02"))
+		return nil, errors.New("Paper " + cusip + " does not mature until " + maturity.Format("2006-01-02"))
+	}
+
+	issuer, err := GetCompany(cp.Issuer, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := newWriteBatch(stub)
+	for _, owner := range cp.Owners {
+		if owner.Company == cp.Issuer || owner.Quantity <= 0 {
+			continue
+		}
+		holder, err := GetCompany(owner.Company, stub)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := moneyFromRat(new(big.Rat).Mul(cp.Par.Rat(), big.NewRat(int64(owner.Quantity), 1)), cp.Par.Currency)
+		if err != nil {
+			return nil, err
+		}
+		err = issuer.debit(amount)
+		if err != nil {
+			fmt.Println("The issuer " + cp.Issuer + " cannot redeem " + cusip)
+			return nil, err
+		}
+		err = holder.credit(amount)
+		if err != nil {
+			return nil, err
+		}
+		err = batch.put(accountPrefix+holder.ID, &holder, HistoryAccount, holder.ID, "redeemPaper")
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = batch.put(accountPrefix+issuer.ID, &issuer, HistoryAccount, issuer.ID, "redeemPaper")
+	if err != nil {
+		return nil, err
+	}
+
+	cp.Matured = true
+	err = batch.put(cpPrefix+cusip, &cp, HistoryCUSIP, cusip, "redeemPaper")
+	if err != nil {
+		return nil, err
+	}
+	err = batch.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, Event{Type: EventPaperRedeemed, CUSIP: cusip, Issuer: cp.Issuer, Quantity: cp.Qty})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Redeemed paper " + cusip)
+	return nil, nil
+}
+
+// getMaturingCPs returns the papers maturing between from and to, both in
+// milliseconds and inclusive by day, soonest first.
+func getMaturingCPs(stub StateStub, from string, to string) ([]CP, error) {
+	fromTime, err := msToTime(from)
+	if err != nil {
+		return nil, errors.New("Invalid start of window " + from + ", expecting milliseconds since the epoch")
+	}
+	toTime, err := msToTime(to)
+	if err != nil {
+		return nil, errors.New("Invalid end of window " + to + ", expecting milliseconds since the epoch")
+	}
+	start, end := truncateToDay(fromTime), truncateToDay(toTime)
+
+	allCPs, err := GetAllCPs(stub)
+	if err != nil {
+		return nil, err
+	}
+	maturing := []CP{}
+	maturities := make(map[string]time.Time)
+	for _, cp := range allCPs {
+		maturity, err := cp.maturityDate()
+		if err != nil {
+			fmt.Println("Skipping paper " + cp.CUSIP + " without a valid issue date")
+			continue
+		}
+		if maturity.Before(start) || maturity.After(end) {
+			continue
+		}
+		maturing = append(maturing, cp)
+		maturities[cp.CUSIP] = maturity
+	}
+	sort.SliceStable(maturing, func(i, j int) bool {
+		return maturities[maturing[i].CUSIP].Before(maturities[maturing[j].CUSIP])
+	})
+	return maturing, nil
+}
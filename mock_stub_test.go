@@ -0,0 +1,140 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// MockStub is an in-memory StateStub used to drive the chaincode from tests.
+type MockStub struct {
+	State       map[string][]byte
+	Certificate []byte
+	Metadata    []byte
+	TxID        string
+	TxTimestamp time.Time
+	Events      []MockEvent
+}
+
+// MockEvent is an event raised through SetEvent.
+type MockEvent struct {
+	Name    string
+	Payload []byte
+}
+
+func NewMockStub() *MockStub {
+	return &MockStub{
+		State:       make(map[string][]byte),
+		TxID:        "tx0",
+		TxTimestamp: time.Date(2016, time.June, 1, 9, 0, 0, 0, time.UTC),
+	}
+}
+
+func (s *MockStub) GetState(key string) ([]byte, error) {
+	value, ok := s.State[key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *MockStub) PutState(key string, value []byte) error {
+	s.State[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *MockStub) DelState(key string) error {
+	delete(s.State, key)
+	return nil
+}
+
+// Snapshot copies the current state, for checking a failed invoke left it
+// untouched.
+func (s *MockStub) Snapshot() map[string]string {
+	snapshot := make(map[string]string, len(s.State))
+	for key, value := range s.State {
+		snapshot[key] = string(value)
+	}
+	return snapshot
+}
+
+// RangeQueryState returns the keys in [startKey, endKey) in lexical order.
+// An empty endKey leaves the range open ended.
+func (s *MockStub) RangeQueryState(startKey, endKey string) (StateRangeIterator, error) {
+	var keys []string
+	for key := range s.State {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	iter := &mockRangeIterator{}
+	for _, key := range keys {
+		iter.keys = append(iter.keys, key)
+		iter.values = append(iter.values, append([]byte(nil), s.State[key]...))
+	}
+	return iter, nil
+}
+
+func (s *MockStub) GetCallerCertificate() ([]byte, error) {
+	return s.Certificate, nil
+}
+
+func (s *MockStub) GetCallerMetadata() ([]byte, error) {
+	return s.Metadata, nil
+}
+
+func (s *MockStub) GetTxID() string {
+	return s.TxID
+}
+
+func (s *MockStub) GetTxTimestamp() (time.Time, error) {
+	return s.TxTimestamp, nil
+}
+
+func (s *MockStub) SetEvent(name string, payload []byte) error {
+	s.Events = append(s.Events, MockEvent{Name: name, Payload: append([]byte(nil), payload...)})
+	return nil
+}
+
+type mockRangeIterator struct {
+	keys   []string
+	values [][]byte
+	pos    int
+}
+
+func (it *mockRangeIterator) HasNext() bool {
+	return it.pos < len(it.keys)
+}
+
+func (it *mockRangeIterator) Next() (string, []byte, error) {
+	if !it.HasNext() {
+		return "", nil, errors.New("range iterator exhausted")
+	}
+	key, value := it.keys[it.pos], it.values[it.pos]
+	it.pos++
+	return key, value, nil
+}
+
+func (it *mockRangeIterator) Close() error {
+	return nil
+}
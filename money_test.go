@@ -0,0 +1,101 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	for in, want := range map[string]string{
+		"1234.5 usd": "1234.50 USD",
+		"1234":       "1234.00 USD",
+		"-0.01 INR":  "-0.01 INR",
+		"500 JPY":    "500 JPY",
+	} {
+		m, err := ParseMoney(in)
+		if err != nil || m.String() != want {
+			t.Errorf("ParseMoney(%q) = %v, %v; want %s", in, m, err, want)
+		}
+	}
+	for _, in := range []string{"1.005 USD", "1.5 JPY", "12 XYZ", "1e3", "1/3", "", "1 USD extra"} {
+		if _, err := ParseMoney(in); err == nil {
+			t.Errorf("expected ParseMoney(%q) to fail", in)
+		}
+	}
+}
+
+func TestMoneyRoundsHalfToEven(t *testing.T) {
+	for _, tc := range []struct {
+		num, den int64
+		want     string
+	}{
+		{1005, 1000, "1.00 USD"},
+		{1015, 1000, "1.02 USD"},
+		{-1005, 1000, "-1.00 USD"},
+		{-1015, 1000, "-1.02 USD"},
+		{10051, 10000, "1.01 USD"},
+	} {
+		m, err := moneyFromRat(big.NewRat(tc.num, tc.den), "USD")
+		if err != nil || m.String() != tc.want {
+			t.Errorf("%d/%d: got %v, %v; want %s", tc.num, tc.den, m, err, tc.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var account Account
+	if err := json.Unmarshal([]byte(`{"cashBalance":9996024.999999}`), &account); err != nil {
+		t.Fatal(err)
+	}
+	if account.balance(defaultCurrency).String() != "9996025.00 USD" {
+		t.Errorf("legacy balance read as %v", account.Balances)
+	}
+	out, err := json.Marshal(&account.Balances)
+	if err != nil || string(out) != `{"USD":"9996025.00 USD"}` {
+		t.Errorf("balance written as %s, %v", out, err)
+	}
+
+	var cp CP
+	if err := json.Unmarshal([]byte(`{"par":"1000 INR","discount":7.5}`), &cp); err != nil {
+		t.Fatal(err)
+	}
+	if cp.Par.String() != "1000.00 INR" || cp.Discount.String() != "7.5" {
+		t.Errorf("unexpected par %v and discount %v", cp.Par, cp.Discount)
+	}
+
+	if _, err := wholeMoney(1, "USD").Add(wholeMoney(1, "INR")); err == nil {
+		t.Error("expected adding different currencies to fail")
+	}
+}
+
+func TestDebitAccountWithoutBalances(t *testing.T) {
+	var account Account
+	if err := json.Unmarshal([]byte(`{"id":"company1"}`), &account); err != nil {
+		t.Fatal(err)
+	}
+	if err := account.debit(wholeMoney(0, defaultCurrency)); err != nil {
+		t.Fatal(err)
+	}
+	if err := account.debit(wholeMoney(1, defaultCurrency)); err == nil {
+		t.Error("expected debiting an empty account to fail")
+	}
+}
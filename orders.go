@@ -0,0 +1,585 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Orders are kept under orderPrefix + CUSIP + ":" + order ID so the book
+// for one paper is a single range.
+var orderPrefix = "order:"
+
+// Order sides and states.
+const (
+	OrderSell = "SELL"
+	OrderBuy  = "BUY"
+
+	OrderOpen      = "OPEN"
+	OrderFilled    = "FILLED"
+	OrderCancelled = "CANCELLED"
+	OrderExpired   = "EXPIRED"
+)
+
+// Order is an offer to sell paper at no less than Price a unit, or a bid to
+// buy it at no more than Price a unit. Orders trade only when matchOrders
+// finds a crossing order on the other side, so both parties consent to
+// every trade.
+type Order struct {
+	ID       string `json:"id"`
+	Side     string `json:"side"`
+	CUSIP    string `json:"cusip"`
+	Company  string `json:"company"`
+	Quantity int    `json:"quantity"`
+	Filled   int    `json:"filled"`
+	Price    Money  `json:"price"`
+	// Expiry, in milliseconds like issueDate, is when an unfilled order
+	// lapses; it is optional
+	Expiry string `json:"expiry,omitempty"`
+	// A bid may pay in PaymentCurrency, converted from Price at the FX
+	// oracle's rate when it is filled
+	PaymentCurrency string `json:"paymentCurrency,omitempty"`
+	// With EscrowFor, in milliseconds, each fill of a bid is held in escrow
+	// until the buyer confirms it, and may be refunded once that long has
+	// passed
+	EscrowFor string `json:"escrowFor,omitempty"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	// PlacedBy is the fingerprint of the certificate that placed the
+	// order, which owns Company; only it may cancel the order
+	PlacedBy string    `json:"placedBy"`
+	PlacedAt time.Time `json:"placedAt"`
+}
+
+func orderKey(cusip string, id string) string {
+	return orderPrefix + cusip + ":" + id
+}
+
+func (o Order) remaining() int {
+	return o.Quantity - o.Filled
+}
+
+// escrowPeriod returns how long fills of the order are held in escrow, zero
+// if they settle at once.
+func (o Order) escrowPeriod() (time.Duration, error) {
+	if o.EscrowFor == "" {
+		return 0, nil
+	}
+	period, err := strconv.ParseInt(o.EscrowFor, 10, 64)
+	if err != nil || period <= 0 {
+		return 0, errors.New("An escrow period must be a positive number of milliseconds")
+	}
+	return time.Duration(period) * time.Millisecond, nil
+}
+
+func (o Order) expired(now time.Time) bool {
+	if o.Expiry == "" {
+		return false
+	}
+	expiry, err := msToTime(o.Expiry)
+	return err == nil && !now.Before(expiry)
+}
+
+// Trade is one fill between a bid and an offer.
+type Trade struct {
+	CUSIP     string `json:"cusip"`
+	Buyer     string `json:"buyer"`
+	Seller    string `json:"seller"`
+	BuyOrder  string `json:"buyOrder"`
+	SellOrder string `json:"sellOrder"`
+	Quantity  int    `json:"quantity"`
+	Price     Money  `json:"price"`
+	Amount    Money  `json:"amount"`
+	// Paid is what the buyer paid, in its payment currency
+	Paid Money `json:"paid"`
+	// SettlementID names the escrow holding an escrowed fill
+	SettlementID string `json:"settlementId,omitempty"`
+}
+
+// holding returns how many units of the paper company owns.
+func (cp CP) holding(company string) int {
+	for _, owner := range cp.Owners {
+		if owner.Company == company {
+			return owner.Quantity
+		}
+	}
+	return 0
+}
+
+// move transfers quantity units of the paper between owners.
+func (cp *CP) move(from string, to string, quantity int) error {
+	if cp.holding(from) < quantity {
+		return errors.New("The company " + from + " doesn't own enough of this paper")
+	}
+	cp.addHolding(from, -quantity)
+	cp.addHolding(to, quantity)
+	return nil
+}
+
+// addHolding changes company's holding by quantity, adding it as an owner
+// if need be.
+func (cp *CP) addHolding(company string, quantity int) {
+	for key, owner := range cp.Owners {
+		if owner.Company == company {
+			cp.Owners[key].Quantity += quantity
+			return
+		}
+	}
+	cp.Owners = append(cp.Owners, Owner{Company: company, Quantity: quantity})
+}
+
+// tradablePaper returns the paper, failing once it has matured.
+func tradablePaper(stub StateStub, cusip string, now time.Time) (CP, error) {
+	var cp CP
+	cpBytes, err := stub.GetState(cpPrefix + cusip)
+	if err != nil || cpBytes == nil {
+		fmt.Println("CUSIP not found")
+		return cp, errors.New("CUSIP not found " + cusip)
+	}
+	err = json.Unmarshal(cpBytes, &cp)
+	if err != nil {
+		fmt.Println("Error unmarshalling cp " + cusip)
+		return cp, errors.New("Error unmarshalling cp " + cusip)
+	}
+	matured, err := cp.hasMatured(now)
+	if err != nil {
+		return cp, err
+	}
+	if cp.Matured || matured {
+		fmt.Println("Paper " + cusip + " has matured")
+		return cp, errors.New("Paper " + cusip + " has matured and can no longer be traded")
+	}
+	return cp, nil
+}
+
+// placeOrder posts an offer or a bid to the book for a company the
+// caller's certificate owns.
+func (t *SimpleChaincode) placeOrder(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			json
+			{
+				"side": "sell", (or "buy")
+				"cusip": "",
+				"company": "company1",
+				"quantity": 5,
+				"price": "995.00 USD", (a unit: the least a seller accepts, the most a buyer pays)
+				"expiry": "1456161763790", (optional, milliseconds)
+				"paymentCurrency": "INR", (optional, bids only)
+				"escrowFor": "3600000" (optional, bids only, milliseconds)
+			}
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting order")
+	}
+	var order Order
+	err := json.Unmarshal([]byte(args[0]), &order)
+	if err != nil {
+		fmt.Println("Error unmarshalling order")
+		return nil, errors.New("Invalid order")
+	}
+	order.Side = strings.ToUpper(order.Side)
+	if order.Side != OrderSell && order.Side != OrderBuy {
+		return nil, errors.New("Order side must be sell or buy")
+	}
+	if order.Quantity <= 0 || order.Price.Sign() <= 0 {
+		return nil, errors.New("An order needs a positive quantity and price")
+	}
+	if order.Side == OrderSell && (order.PaymentCurrency != "" || order.EscrowFor != "") {
+		return nil, errors.New("Only bids may choose a payment currency or escrow")
+	}
+	_, err = order.escrowPeriod()
+	if err != nil {
+		return nil, err
+	}
+	order.PlacedAt, err = stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if order.Expiry != "" {
+		expiry, err := msToTime(order.Expiry)
+		if err != nil || !expiry.After(order.PlacedAt) {
+			return nil, errors.New("Order expiry must be a future time in milliseconds")
+		}
+	}
+
+	cp, err := tradablePaper(stub, order.CUSIP, order.PlacedAt)
+	if err != nil {
+		return nil, err
+	}
+	if order.Price.Currency != cp.Par.Currency {
+		return nil, errors.New("Orders for " + order.CUSIP + " must be priced in " + cp.Par.Currency)
+	}
+	company, err := requireCompany(stub, order.Company)
+	if err != nil {
+		return nil, err
+	}
+	order.PlacedBy = company.Owner
+	// Holdings and cash are checked again when the order is matched
+	if order.Side == OrderSell && cp.holding(order.Company) < order.Quantity {
+		return nil, errors.New("The company " + order.Company + " doesn't own enough of this paper")
+	}
+	if order.Side == OrderBuy {
+		cost, err := moneyFromRat(new(big.Rat).Mul(order.Price.Rat(), big.NewRat(int64(order.Quantity), 1)), order.Price.Currency)
+		if err != nil {
+			return nil, err
+		}
+		if order.PaymentCurrency != "" {
+			order.PaymentCurrency = strings.ToUpper(order.PaymentCurrency)
+			cost, err = convert(stub, cost, order.PaymentCurrency)
+			if err != nil {
+				fmt.Println(err)
+				return nil, err
+			}
+		}
+		short, err := company.balance(cost.Currency).LessThan(cost)
+		if err != nil {
+			return nil, err
+		}
+		if short {
+			return nil, errors.New("The company " + order.Company + " doesn't have enough " + cost.Currency + " to pay " + cost.String())
+		}
+	}
+
+	order.ID = stub.GetTxID()
+	order.Filled = 0
+	order.Status = OrderOpen
+	order.Reason = ""
+	key := orderKey(order.CUSIP, order.ID)
+	existing, err := stub.GetState(key)
+	if err != nil || existing != nil {
+		return nil, errors.New("Order " + order.ID + " already exists")
+	}
+	orderBytes, err := json.Marshal(&order)
+	if err != nil {
+		fmt.Println("Error marshalling order")
+		return nil, errors.New("Error placing order")
+	}
+	err = stub.PutState(key, orderBytes)
+	if err != nil {
+		fmt.Println("Error placing order")
+		return nil, errors.New("Error placing order")
+	}
+
+	err = setEvent(stub, Event{Type: EventOrderPlaced, CUSIP: order.CUSIP, OrderID: order.ID, Quantity: order.Quantity})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Placed order " + order.ID)
+	return nil, nil
+}
+
+func getOrder(stub StateStub, cusip string, id string) (Order, error) {
+	var order Order
+	orderBytes, err := stub.GetState(orderKey(cusip, id))
+	if err != nil || orderBytes == nil {
+		return order, errors.New("Order not found " + id)
+	}
+	err = json.Unmarshal(orderBytes, &order)
+	if err != nil {
+		fmt.Println("Error unmarshalling order " + id)
+		return order, errors.New("Error unmarshalling order " + id)
+	}
+	return order, nil
+}
+
+// cancelOrder withdraws an open order. Only the certificate that owns the
+// order's company may cancel it.
+func (t *SimpleChaincode) cancelOrder(stub StateStub, args []string) ([]byte, error) {
+	/*		0		1
+			cusip	order ID
+	*/
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting CUSIP and order ID")
+	}
+	order, err := getOrder(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	_, err = requireCompany(stub, order.Company)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != OrderOpen {
+		return nil, errors.New("Order " + order.ID + " is " + strings.ToLower(order.Status))
+	}
+
+	order.Status = OrderCancelled
+	orderBytes, err := json.Marshal(&order)
+	if err != nil {
+		return nil, errors.New("Error cancelling order " + order.ID)
+	}
+	err = stub.PutState(orderKey(order.CUSIP, order.ID), orderBytes)
+	if err != nil {
+		fmt.Println("Error cancelling order " + order.ID)
+		return nil, errors.New("Error cancelling order " + order.ID)
+	}
+	err = setEvent(stub, Event{Type: EventOrderCancelled, CUSIP: order.CUSIP, OrderID: order.ID, Quantity: order.remaining()})
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// getOrders returns every order for the paper in the order placed.
+func getOrders(stub StateStub, cusip string) ([]Order, error) {
+	orders := []Order{}
+	err := forEachInRange(stub, orderPrefix+cusip+":", func(key string, value []byte) error {
+		var order Order
+		err := json.Unmarshal(value, &order)
+		if err != nil {
+			fmt.Println("Error unmarshalling order " + key)
+			return errors.New("Error unmarshalling order " + key)
+		}
+		orders = append(orders, order)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].PlacedAt.Before(orders[j].PlacedAt)
+	})
+	return orders, nil
+}
+
+// matchOrders settles the crossing orders for a paper. Bids are filled
+// best price first, against offers best price first, and orders at the
+// same price in the order they were placed. Each fill trades at the price
+// of the order placed first and is delivered against payment: the paper
+// and the cash move in the same write batch, so either both do or neither.
+// Orders past their expiry lapse; orders the seller can no longer deliver
+// or the buyer can no longer pay for are cancelled. The trades are
+// returned.
+func (t *SimpleChaincode) matchOrders(stub StateStub, args []string) ([]byte, error) {
+	/*		0
+			cusip
+	*/
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting CUSIP")
+	}
+	cusip := args[0]
+	now, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	orders, err := getOrders(stub, cusip)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := tradablePaper(stub, cusip, now)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := newWriteBatch(stub)
+	var bids, offers []*Order
+	for i := range orders {
+		order := &orders[i]
+		if order.Status != OrderOpen {
+			continue
+		}
+		if order.expired(now) {
+			order.Status = OrderExpired
+			err = putOrder(batch, order)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if order.Side == OrderBuy {
+			bids = append(bids, order)
+		} else {
+			offers = append(offers, order)
+		}
+	}
+	sort.SliceStable(bids, func(i, j int) bool {
+		return bids[i].Price.Minor > bids[j].Price.Minor
+	})
+	sort.SliceStable(offers, func(i, j int) bool {
+		return offers[i].Price.Minor < offers[j].Price.Minor
+	})
+
+	accounts := make(map[string]*Account)
+	account := func(id string) (*Account, error) {
+		if a, ok := accounts[id]; ok {
+			return a, nil
+		}
+		a, err := GetCompany(id, stub)
+		if err != nil {
+			return nil, err
+		}
+		accounts[id] = &a
+		return &a, nil
+	}
+
+	trades := []Trade{}
+	for _, bid := range bids {
+		for _, offer := range offers {
+			if bid.remaining() == 0 || bid.Status != OrderOpen {
+				break
+			}
+			if offer.Price.Minor > bid.Price.Minor {
+				break
+			}
+			if offer.remaining() == 0 || offer.Status != OrderOpen || offer.Company == bid.Company {
+				continue
+			}
+
+			quantity := bid.remaining()
+			if offer.remaining() < quantity {
+				quantity = offer.remaining()
+			}
+			price := offer.Price
+			if bid.PlacedAt.Before(offer.PlacedAt) {
+				price = bid.Price
+			}
+
+			if cp.holding(offer.Company) < quantity {
+				offer.Status = OrderCancelled
+				offer.Reason = "The seller no longer owns enough of this paper"
+				err = putOrder(batch, offer)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			amount, err := moneyFromRat(new(big.Rat).Mul(price.Rat(), big.NewRat(int64(quantity), 1)), price.Currency)
+			if err != nil {
+				return nil, err
+			}
+			// The seller is paid in the paper's currency; the buyer may
+			// pay in another at the oracle's rate
+			paid := amount
+			if bid.PaymentCurrency != "" {
+				paid, err = convert(stub, amount, bid.PaymentCurrency)
+				if err != nil {
+					fmt.Println(err)
+					return nil, err
+				}
+			}
+			buyer, err := account(bid.Company)
+			if err != nil {
+				return nil, err
+			}
+			seller, err := account(offer.Company)
+			if err != nil {
+				return nil, err
+			}
+			err = buyer.debit(paid)
+			if err != nil {
+				bid.Status = OrderCancelled
+				bid.Reason = "The buyer no longer has enough cash"
+				err = putOrder(batch, bid)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+			trade := Trade{CUSIP: cusip, Buyer: bid.Company, Seller: offer.Company,
+				BuyOrder: bid.ID, SellOrder: offer.ID, Quantity: quantity, Price: price, Amount: amount, Paid: paid}
+			changed := []*Account{buyer, seller}
+
+			period, err := bid.escrowPeriod()
+			if err != nil {
+				return nil, err
+			}
+			if period > 0 {
+				// Hold both legs until the buyer confirms delivery
+				settlement := Settlement{ID: bid.ID + "-" + offer.ID, Payer: bid.Company, Payee: offer.Company,
+					Cash: paid, Payment: amount, CUSIP: cusip, Quantity: quantity}
+				err = lockEscrow(stub, batch, &settlement, now.Add(period), &cp)
+				if err != nil {
+					return nil, err
+				}
+				trade.SettlementID = settlement.ID
+				changed = changed[:1]
+			} else {
+				err = seller.credit(amount)
+				if err != nil {
+					return nil, err
+				}
+				err = cp.move(offer.Company, bid.Company, quantity)
+				if err != nil {
+					return nil, err
+				}
+				buyer.addAsset(cusip)
+			}
+
+			for _, order := range []*Order{bid, offer} {
+				order.Filled += quantity
+				if order.remaining() == 0 {
+					order.Status = OrderFilled
+				}
+				err = putOrder(batch, order)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, a := range changed {
+				err = batch.put(accountPrefix+a.ID, a, HistoryAccount, a.ID, "matchOrders")
+				if err != nil {
+					return nil, err
+				}
+			}
+			trades = append(trades, trade)
+		}
+	}
+
+	traded := 0
+	for _, trade := range trades {
+		traded += trade.Quantity
+	}
+	if traded > 0 {
+		err = batch.put(cpPrefix+cusip, &cp, HistoryCUSIP, cusip, "matchOrders")
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = batch.commit()
+	if err != nil {
+		return nil, err
+	}
+	if traded > 0 {
+		err = setEvent(stub, Event{Type: EventOrdersMatched, CUSIP: cusip, Quantity: traded})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tradesBytes, err := json.Marshal(&trades)
+	if err != nil {
+		fmt.Println("Error marshalling trades")
+		return nil, errors.New("Error marshalling trades")
+	}
+	fmt.Println("Matched orders for " + cusip)
+	return tradesBytes, nil
+}
+
+// putOrder stages an order; orders are not part of the paper or account
+// history, which records the trades they settle.
+func putOrder(batch *writeBatch, order *Order) error {
+	return batch.put(orderKey(order.CUSIP, order.ID), order, "", "", "")
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// placeOrder places an order in its own transaction, signed by the
+// company's certificate.
+func placeOrder(t *testing.T, cc *SimpleChaincode, stub *MockStub, txID string, order string) {
+	t.Helper()
+	var o Order
+	if err := json.Unmarshal([]byte(order), &o); err != nil {
+		t.Fatal(err)
+	}
+	stub.TxID = txID
+	stub.Certificate = []byte(o.Company + "-cert")
+	run(t, cc, stub, "placeOrder", order)
+}
+
+// trade sells quantity units of the paper from seller to buyer at price
+// through the order book, adding bid's fields to the buy order. The orders
+// are placed in transactions named after the current one, which matches
+// them.
+func trade(t *testing.T, cc *SimpleChaincode, stub *MockStub, cusip string, seller string, buyer string, quantity int, price string, bid string) []Trade {
+	t.Helper()
+	txID := stub.TxID
+	order := func(side, company string) string {
+		return `{"side":"` + side + `","cusip":"` + cusip + `","company":"` + company + `","quantity":` +
+			strconv.Itoa(quantity) + `,"price":"` + price + `"`
+	}
+	placeOrder(t, cc, stub, txID+"-sell", order("sell", seller)+"}")
+	placeOrder(t, cc, stub, txID+"-buy", order("buy", buyer)+bid+"}")
+	stub.TxID = txID
+	out, err := cc.run(stub, "matchOrders", []string{cusip})
+	if err != nil {
+		t.Fatalf("matchOrders: %v", err)
+	}
+	var trades []Trade
+	if err := json.Unmarshal(out, &trades); err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1 || trades[0].Quantity != quantity {
+		t.Fatalf("expected one trade of %d units, got %+v", quantity, trades)
+	}
+	return trades
+}
+
+func TestOrderBook(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	order := func(side, company string, quantity int, price string) string {
+		return `{"side":"` + side + `","cusip":"` + cusip + `","company":"` + company + `","quantity":` +
+			strconv.Itoa(quantity) + `,"price":"` + price + `"}`
+	}
+
+	placeOrder(t, cc, stub, "o1", order("sell", "company1", 6, "995 USD"))
+	placeOrder(t, cc, stub, "o2", order("buy", "company2", 4, "990 USD"))
+	for _, invalid := range []string{
+		order("sell", "company2", 1, "995 USD"), // owns none
+		order("buy", "company2", 1, "995 INR"),  // wrong currency
+		order("hold", "company2", 1, "995 USD"), // no such side
+		order("buy", "company2", 1, "0.00 USD"), // no price
+		order("sell", "company1", 1, "995 USD"), // not the caller's company
+		strings.Replace(order("buy", "company2", 1, "995 USD"), "}", `,"escrowFor":"-1"}`, 1),
+	} {
+		if _, err := cc.run(stub, "placeOrder", []string{invalid}); err == nil {
+			t.Errorf("expected order %s to be refused", invalid)
+		}
+	}
+
+	stub.TxID = "m1"
+	trades, err := cc.run(stub, "matchOrders", []string{cusip})
+	if err != nil || string(trades) != "[]" {
+		t.Fatalf("expected no trades between orders that do not cross, got %s, %v", trades, err)
+	}
+
+	// A later bid above the offer trades at the offer's price
+	placeOrder(t, cc, stub, "o3", order("buy", "company2", 5, "996 USD"))
+	stub.TxID = "m2"
+	out, err := cc.run(stub, "matchOrders", []string{cusip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filled []Trade
+	if err := json.Unmarshal(out, &filled); err != nil {
+		t.Fatal(err)
+	}
+	if len(filled) != 1 || filled[0].BuyOrder != "o3" || filled[0].SellOrder != "o1" || filled[0].Quantity != 5 ||
+		filled[0].Price.String() != "995.00 USD" || filled[0].Amount.String() != "4975.00 USD" {
+		t.Fatalf("unexpected trades %+v", filled)
+	}
+
+	var cp CP
+	query(t, cc, stub, &cp, "GetCP", cpPrefix+cusip)
+	if cp.holding("company1") != 5 || cp.holding("company2") != 5 {
+		t.Errorf("unexpected owners %+v", cp.Owners)
+	}
+	var seller, buyer Account
+	query(t, cc, stub, &seller, "GetCompany", "company1")
+	query(t, cc, stub, &buyer, "GetCompany", "company2")
+	if seller.balance(defaultCurrency).String() != "10004975.00 USD" || buyer.balance(defaultCurrency).String() != "9995025.00 USD" {
+		t.Errorf("unexpected balances: seller %v, buyer %v", seller.Balances, buyer.Balances)
+	}
+
+	var orders []Order
+	query(t, cc, stub, &orders, "GetOrders", cusip)
+	if len(orders) != 3 || orders[0].Status != OrderOpen || orders[0].Filled != 5 || orders[2].Status != OrderFilled {
+		t.Errorf("unexpected orders %+v", orders)
+	}
+
+	// Only the owner of an order's company may cancel it
+	stub.Certificate = []byte("company1-cert")
+	if _, err := cc.run(stub, "cancelOrder", []string{cusip, "o2"}); err == nil {
+		t.Error("expected another company to be refused cancelling the bid")
+	}
+	stub.Certificate = []byte("company2-cert")
+	run(t, cc, stub, "cancelOrder", cusip, "o2")
+	if _, err := cc.run(stub, "cancelOrder", []string{cusip, "o2"}); err == nil {
+		t.Error("expected a cancelled order not to be cancelled again")
+	}
+
+	// Orders lapse at their expiry
+	expiring := strings.Replace(order("sell", "company2", 1, "999 USD"), "}", `,"expiry":"`+ms(stub.TxTimestamp.AddDate(0, 0, 1))+`"}`, 1)
+	placeOrder(t, cc, stub, "o4", expiring)
+	stub.TxTimestamp = stub.TxTimestamp.AddDate(0, 0, 2)
+	stub.TxID = "m3"
+	run(t, cc, stub, "matchOrders", cusip)
+	query(t, cc, stub, &orders, "GetOrders", cusip)
+	if len(orders) != 4 || orders[1].Status != OrderCancelled || orders[3].Status != OrderExpired {
+		t.Errorf("unexpected orders %+v", orders)
+	}
+}
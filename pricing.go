@@ -0,0 +1,242 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Day-count conventions for accruing discount on commercial paper. The
+// convention names the way days between two dates are counted and the
+// number of days in the year they are divided by.
+const (
+	DayCountACT360  = "ACT/360"
+	DayCountACT365  = "ACT/365"
+	DayCount30360   = "30/360"
+	defaultDayCount = DayCountACT360
+)
+
+// yieldDecimals is how many decimals of a percent yields are quoted to.
+const yieldDecimals = 6
+
+// normalizeDayCount returns the canonical name of a day-count convention,
+// defaulting to ACT/360 which paper issued before conventions were recorded
+// was priced with.
+func normalizeDayCount(convention string) (string, error) {
+	switch strings.ToUpper(strings.TrimSpace(convention)) {
+	case "":
+		return defaultDayCount, nil
+	case DayCountACT360:
+		return DayCountACT360, nil
+	case DayCountACT365:
+		return DayCountACT365, nil
+	case DayCount30360:
+		return DayCount30360, nil
+	}
+	return "", errors.New("Unknown day count convention " + convention)
+}
+
+// dayCountBasis is the number of days in a year under a convention.
+func dayCountBasis(convention string) int64 {
+	if convention == DayCountACT365 {
+		return 365
+	}
+	return 360
+}
+
+// truncateToDay returns midnight UTC of the day t falls on.
+func truncateToDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// actualDays counts the calendar days from one date to another.
+func actualDays(from, to time.Time) int64 {
+	return int64(truncateToDay(to).Sub(truncateToDay(from)) / (24 * time.Hour))
+}
+
+// thirty360Days counts days as if every month had 30 days, following the
+// 30/360 bond basis: a 31st is treated as the 30th, and a closing 31st only
+// when the opening date is the 30th or 31st.
+func thirty360Days(from, to time.Time) int64 {
+	y1, m1, d1 := from.UTC().Date()
+	y2, m2, d2 := to.UTC().Date()
+	if d1 == 31 {
+		d1 = 30
+	}
+	if d2 == 31 && d1 == 30 {
+		d2 = 30
+	}
+	return int64(360*(y2-y1) + 30*(int(m2)-int(m1)) + (d2 - d1))
+}
+
+// dayCount counts the days from one date to another under a convention.
+func dayCount(convention string, from, to time.Time) int64 {
+	if convention == DayCount30360 {
+		return thirty360Days(from, to)
+	}
+	return actualDays(from, to)
+}
+
+// includesLeapDay reports whether a 29th of February falls after from and
+// on or before to.
+func includesLeapDay(from, to time.Time) bool {
+	from, to = truncateToDay(from), truncateToDay(to)
+	for year := from.Year(); year <= to.Year(); year++ {
+		leapDay := time.Date(year, time.February, 29, 0, 0, 0, 0, time.UTC)
+		if leapDay.Month() == time.February && leapDay.After(from) && !leapDay.After(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// issueTime is when the paper was issued.
+func (cp CP) issueTime() (time.Time, error) {
+	issued, err := msToTime(cp.IssueDate)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid issue date " + cp.IssueDate)
+	}
+	return issued.UTC(), nil
+}
+
+// maturityDate is the day the paper matures, Maturity days after issue.
+func (cp CP) maturityDate() (time.Time, error) {
+	issued, err := cp.issueTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return truncateToDay(issued).AddDate(0, 0, cp.Maturity), nil
+}
+
+// Quote is the value of one unit of paper on a given day.
+type Quote struct {
+	CUSIP    string    `json:"cusip"`
+	AsOf     time.Time `json:"asOf"`
+	DayCount string    `json:"dayCount"`
+	Maturity time.Time `json:"maturityDate"`
+	// DaysToMaturity is counted under the paper's convention
+	DaysToMaturity int64 `json:"daysToMaturity"`
+	Price          Money `json:"price"`
+	// DiscountYield and BondEquivalentYield are in percent; they are zero
+	// once the paper has matured
+	DiscountYield       Rate `json:"discountYield"`
+	BondEquivalentYield Rate `json:"bondEquivalentYield"`
+
+	// exact price of one unit, before rounding to the currency
+	price *big.Rat
+}
+
+// quotePaper prices one unit of paper as of a moment between its issue and
+// maturity: par less the discount accrued over the remaining days,
+//
+//	price = par * (1 - discount/100 * days/basis)
+//
+// The discount yield is the bank discount the price implies over actual days
+// on a 360-day year, whatever the paper's convention; the bond-equivalent
+// yield restates it as simple interest on the price over an actual/365 year
+// (366 when a leap day is ahead).
+func quotePaper(cp CP, asOf time.Time) (Quote, error) {
+	var quote Quote
+	convention, err := normalizeDayCount(cp.DayCount)
+	if err != nil {
+		return quote, err
+	}
+	issued, err := cp.issueTime()
+	if err != nil {
+		return quote, err
+	}
+	maturity, err := cp.maturityDate()
+	if err != nil {
+		return quote, err
+	}
+	if truncateToDay(asOf).Before(truncateToDay(issued)) {
+		fmt.Println("Paper " + cp.CUSIP + " had not been issued by " + asOf.UTC().Format(time.RFC3339))
+		return quote, errors.New("Paper " + cp.CUSIP + " had not been issued by " + asOf.UTC().Format(time.RFC3339))
+	}
+
+	quote.CUSIP = cp.CUSIP
+	quote.AsOf = asOf.UTC()
+	quote.DayCount = convention
+	quote.Maturity = maturity
+	quote.price = cp.Par.Rat()
+
+	days := dayCount(convention, asOf, maturity)
+	actual := actualDays(asOf, maturity)
+	if days <= 0 || actual <= 0 {
+		// matured paper is worth par
+		quote.Price, err = moneyFromRat(quote.price, cp.Par.Currency)
+		return quote, err
+	}
+	quote.DaysToMaturity = days
+
+	hundred := big.NewRat(100, 1)
+	discount := new(big.Rat).Quo(cp.Discount.Rat(), hundred)
+	discount.Mul(discount, big.NewRat(days, dayCountBasis(convention)))
+	if discount.Cmp(big.NewRat(1, 1)) >= 0 {
+		fmt.Println("Discount on " + cp.CUSIP + " leaves no value to the paper")
+		return quote, errors.New("Discount on " + cp.CUSIP + " leaves no value to the paper")
+	}
+	par := cp.Par.Rat()
+	quote.price.Mul(par, new(big.Rat).Sub(big.NewRat(1, 1), discount))
+	quote.Price, err = moneyFromRat(quote.price, cp.Par.Currency)
+	if err != nil {
+		return quote, err
+	}
+
+	gain := new(big.Rat).Sub(par, quote.price)
+	discountYield := new(big.Rat).Quo(gain, par)
+	discountYield.Mul(discountYield, big.NewRat(360, actual))
+	quote.DiscountYield = roundRate(discountYield.Mul(discountYield, hundred), yieldDecimals)
+
+	year := int64(365)
+	if includesLeapDay(asOf, maturity) {
+		year = 366
+	}
+	bey := new(big.Rat).Quo(gain, quote.price)
+	bey.Mul(bey, big.NewRat(year, actual))
+	quote.BondEquivalentYield = roundRate(bey.Mul(bey, hundred), yieldDecimals)
+	return quote, nil
+}
+
+// roundRate rounds r half to even at the given number of decimals.
+func roundRate(r *big.Rat, decimals int) Rate {
+	scale := new(big.Rat).SetInt(minorPerUnit(decimals))
+	rounded := new(big.Rat).SetInt(roundHalfEven(new(big.Rat).Mul(r, scale)))
+	return Rate{value: rounded.Quo(rounded, scale)}
+}
+
+// getQuote prices the paper with the given CUSIP as of a time in
+// milliseconds.
+func getQuote(stub StateStub, cusip string, asOf string) (Quote, error) {
+	when, err := msToTime(asOf)
+	if err != nil {
+		fmt.Println("Invalid as-of time " + asOf)
+		return Quote{}, errors.New("Invalid as-of time " + asOf + ", expecting milliseconds since the epoch")
+	}
+	cp, err := GetCP(cpPrefix+cusip, stub)
+	if err != nil {
+		return Quote{}, err
+	}
+	return quotePaper(cp, when)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2016 IBM
+
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Licensed Materials - Property of IBM
+© Copyright IBM Corp. 2016
+*/
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func ms(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func testCP(t *testing.T, dayCount string, issued time.Time) CP {
+	par, err := ParseMoney("1000 USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	discount, err := ParseRate("7.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return CP{CUSIP: "company1000AG1", Par: par, Discount: discount, Maturity: 30, DayCount: dayCount, IssueDate: ms(issued)}
+}
+
+func TestQuotePaper(t *testing.T) {
+	june1 := time.Date(2016, time.June, 1, 9, 0, 0, 0, time.UTC)
+	jan30 := time.Date(2016, time.January, 30, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name          string
+		dayCount      string
+		issued, asOf  time.Time
+		days          int64
+		price         string
+		discountYield string
+		bey           string
+	}{
+		{"ACT/360 at issue", "", june1, june1, 30, "993.75 USD", "7.5", "7.651992"},
+		{"ACT/365 at issue", "act/365", june1, june1, 30, "993.84 USD", "7.39726", "7.54652"},
+		{"halfway to maturity", DayCountACT360, june1, june1.AddDate(0, 0, 15), 15, "996.88 USD", "7.5", "7.628004"},
+		// 30 actual days to the 29th of February are 29 under 30/360
+		{"30/360 over a leap day", DayCount30360, jan30, jan30, 29, "993.96 USD", "7.25", "7.415636"},
+		{"matured", "", june1, june1.AddDate(0, 1, 0), 0, "1000.00 USD", "0", "0"},
+	} {
+		quote, err := quotePaper(testCP(t, tc.dayCount, tc.issued), tc.asOf)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if quote.DaysToMaturity != tc.days || quote.Price.String() != tc.price ||
+			quote.DiscountYield.String() != tc.discountYield || quote.BondEquivalentYield.String() != tc.bey {
+			t.Errorf("%s: got %d days, price %v, discount yield %v, BEY %v", tc.name,
+				quote.DaysToMaturity, quote.Price, quote.DiscountYield, quote.BondEquivalentYield)
+		}
+	}
+
+	if _, err := quotePaper(testCP(t, "", june1), june1.AddDate(0, 0, -1)); err == nil {
+		t.Error("expected paper to have no price before it is issued")
+	}
+	if _, err := quotePaper(testCP(t, "ACT/ACT", june1), june1); err == nil {
+		t.Error("expected an unknown day count to be rejected")
+	}
+}
+
+func TestPriceQueries(t *testing.T) {
+	cc, stub, cusip := newPaperLedger(t)
+	asOf := ms(stub.TxTimestamp.AddDate(0, 0, 15))
+
+	var price Money
+	query(t, cc, stub, &price, "GetPrice", cusip, asOf)
+	var discountYield, bey Rate
+	query(t, cc, stub, &discountYield, "GetDiscountYield", cusip, asOf)
+	query(t, cc, stub, &bey, "GetBondEquivalentYield", cusip, asOf)
+	if price.String() != "996.88 USD" || discountYield.String() != "7.5" || bey.String() != "7.628004" {
+		t.Errorf("unexpected quote: price %v, discount yield %v, BEY %v", price, discountYield, bey)
+	}
+
+	if _, err := cc.run(stub, "issueCommercialPaper", []string{`{"ticker":"ACME","par":1000,"qty":1,"discount":7.5,"maturity":30,"dayCount":"ACT/ACT","issuer":"company1","issueDate":"1464771600000"}`}); err == nil {
+		t.Error("expected paper with an unknown day count to be refused")
+	}
+}